@@ -0,0 +1,108 @@
+package sexp
+
+// The accessors below are safe to call on nil and never panic on out-of-range
+// indices, so that tree-walking code can chain them without checks.
+
+func (s *Sexp) IsAtom() bool {
+	return s != nil && s.Atom != nil
+}
+
+func (s *Sexp) IsList() bool {
+	return s != nil && s.Atom == nil
+}
+
+func (s *Sexp) isAtomOf(typ TokenType) bool {
+	return s.IsAtom() && s.Atom.Type == typ
+}
+
+// IsSymbol reports whether s is a symbol. If names are given, the symbol must be one of them.
+func (s *Sexp) IsSymbol(names ...string) bool {
+	if !s.isAtomOf(TokenTypeSymbol) {
+		return false
+	}
+	if len(names) == 0 {
+		return true
+	}
+	for _, name := range names {
+		if s.Atom.Value == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Sexp) IsString() bool {
+	return s.isAtomOf(TokenTypeString)
+}
+
+func (s *Sexp) IsNumber() bool {
+	return s.isAtomOf(TokenTypeNumber)
+}
+
+// HasHead reports whether s is a list starting with a symbol. If names are given,
+// the symbol must be one of them.
+func (s *Sexp) HasHead(names ...string) bool {
+	return s.Head().IsSymbol(names...)
+}
+
+// Len returns the number of elements of a list, or 0 for an atom.
+func (s *Sexp) Len() int {
+	if !s.IsList() {
+		return 0
+	}
+	return len(s.Children)
+}
+
+// Nth returns the i-th element of a list, or nil if there is no such element.
+func (s *Sexp) Nth(i int) *Sexp {
+	if i < 0 || i >= s.Len() {
+		return nil
+	}
+	return s.Children[i]
+}
+
+// Head returns the first element of a list, or nil.
+func (s *Sexp) Head() *Sexp {
+	return s.Nth(0)
+}
+
+// Tail returns the elements of a list after the first one, or nil.
+func (s *Sexp) Tail() []*Sexp {
+	if s.Len() == 0 {
+		return nil
+	}
+	return s.Children[1:]
+}
+
+// Symbol returns the name of a symbol.
+func (s *Sexp) Symbol() (string, bool) {
+	if !s.IsSymbol() {
+		return "", false
+	}
+	return s.Atom.Value, true
+}
+
+// Str returns the decoded value of a string. It returns false if s is not a string
+// or if the string contains a malformed escape sequence or is not valid UTF-8.
+func (s *Sexp) Str() (string, bool) {
+	if !s.IsString() {
+		return "", false
+	}
+	v, err := s.Atom.StringValue()
+	if err != nil {
+		return "", false
+	}
+	return v, true
+}
+
+// Number returns the parsed value of a number.
+func (s *Sexp) Number() (*Number, bool) {
+	if !s.IsNumber() {
+		return nil, false
+	}
+	n, err := s.Atom.Number()
+	if err != nil {
+		return nil, false
+	}
+	return n, true
+}
@@ -0,0 +1,119 @@
+package sexp
+
+import (
+	"testing"
+)
+
+func TestSexpPredicates(t *testing.T) {
+	s := MustParse(`(func $f "name" 42 (param i32) ())`)
+
+	testData := []struct {
+		Name     string
+		Actual   bool
+		Expected bool
+	}{
+		{Name: "list is list", Actual: s.IsList(), Expected: true},
+		{Name: "list is not atom", Actual: s.IsAtom(), Expected: false},
+		{Name: "list is not symbol", Actual: s.IsSymbol(), Expected: false},
+		{Name: "head is symbol", Actual: s.Head().IsSymbol(), Expected: true},
+		{Name: "head is symbol func", Actual: s.Head().IsSymbol("func"), Expected: true},
+		{Name: "head is one of the symbols", Actual: s.Head().IsSymbol("module", "func"), Expected: true},
+		{Name: "head is not symbol module", Actual: s.Head().IsSymbol("module"), Expected: false},
+		{Name: "has head func", Actual: s.HasHead("func"), Expected: true},
+		{Name: "has no head module", Actual: s.HasHead("module"), Expected: false},
+		{Name: "string is string", Actual: s.Nth(2).IsString(), Expected: true},
+		{Name: "string is not symbol", Actual: s.Nth(2).IsSymbol(), Expected: false},
+		{Name: "number is number", Actual: s.Nth(3).IsNumber(), Expected: true},
+		{Name: "empty list is list", Actual: s.Nth(5).IsList(), Expected: true},
+		{Name: "empty list has no head", Actual: s.Nth(5).HasHead(), Expected: false},
+		{Name: "nil is not list", Actual: s.Nth(6).IsList(), Expected: false},
+		{Name: "nil is not atom", Actual: s.Nth(6).IsAtom(), Expected: false},
+		{Name: "nil is not symbol", Actual: s.Nth(6).IsSymbol(), Expected: false},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			if data.Expected != data.Actual {
+				t.Fatalf("\nExpected: %v\nActual:   %v", data.Expected, data.Actual)
+			}
+		})
+	}
+}
+
+func TestSexpElements(t *testing.T) {
+	s := MustParse(`(a b c)`)
+
+	if s.Len() != 3 {
+		t.Fatalf("expected 3 elements, but got %d", s.Len())
+	}
+	if s.Nth(1).String() != "b" {
+		t.Fatalf("expected b, but got %s", s.Nth(1))
+	}
+	if s.Nth(3) != nil || s.Nth(-1) != nil {
+		t.Fatalf("expected nil for out-of-range indices")
+	}
+	if s.Head().String() != "a" {
+		t.Fatalf("expected a, but got %s", s.Head())
+	}
+	tail := s.Tail()
+	if len(tail) != 2 || tail[0].String() != "b" || tail[1].String() != "c" {
+		t.Fatalf("unexpected tail: %v", tail)
+	}
+
+	atom := s.Head()
+	if atom.Len() != 0 || atom.Head() != nil || atom.Tail() != nil || atom.Nth(0) != nil {
+		t.Fatalf("expected an atom to have no elements")
+	}
+
+	var nilSexp *Sexp
+	if nilSexp.Len() != 0 || nilSexp.Head() != nil || nilSexp.Tail() != nil {
+		t.Fatalf("expected nil to have no elements")
+	}
+
+	empty := MustParse(`()`)
+	if empty.Head() != nil || empty.Tail() != nil {
+		t.Fatalf("expected an empty list to have no head and tail")
+	}
+}
+
+func TestSexpValues(t *testing.T) {
+	s := MustParse(`(sym "he said \"hi\"" 0x10 "\ff")`)
+
+	name, ok := s.Nth(0).Symbol()
+	if !ok || name != "sym" {
+		t.Fatalf("unexpected symbol: %q, %v", name, ok)
+	}
+	_, ok = s.Nth(1).Symbol()
+	if ok {
+		t.Fatalf("expected a string not to be a symbol")
+	}
+
+	str, ok := s.Nth(1).Str()
+	if !ok || str != `he said "hi"` {
+		t.Fatalf("unexpected string: %q, %v", str, ok)
+	}
+	_, ok = s.Nth(0).Str()
+	if ok {
+		t.Fatalf("expected a symbol not to be a string")
+	}
+	_, ok = s.Nth(3).Str()
+	if ok {
+		t.Fatalf("expected invalid UTF-8 not to be a string")
+	}
+
+	n, ok := s.Nth(2).Number()
+	if !ok {
+		t.Fatalf("expected a number")
+	}
+	v, err := n.Int64()
+	if err != nil || v != 16 {
+		t.Fatalf("unexpected number: %d, %v", v, err)
+	}
+	_, ok = s.Nth(4).Number()
+	if ok {
+		t.Fatalf("expected no number out of range")
+	}
+}
@@ -0,0 +1,136 @@
+// Command sexp2json converts S-expressions to JSON and back.
+//
+// Usage:
+//
+//	sexp2json [flags] [file ...]
+//
+// It reads the top-level expressions of the files, or of the standard input, one at
+// a time and writes each as a JSON value on a line of its own. With -r, it reads a
+// stream of JSON values and writes each as an S-expression on a line of its own.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/bearmini/sexp"
+)
+
+var (
+	reverse = flag.Bool("r", false, "convert JSON to S-expressions")
+	mapping = flag.String("mapping", "tagged", "JSON mapping: tagged or natural")
+	dialect = flag.String("dialect", "wat", "comment syntax: wat, scheme, lisp or none")
+)
+
+var mappings = map[string]sexp.JSONMapping{
+	"tagged":  sexp.JSONTagged,
+	"natural": sexp.JSONNatural,
+}
+
+var dialects = map[string]sexp.Dialect{
+	"wat":    sexp.DialectWAT,
+	"scheme": sexp.DialectScheme,
+	"lisp":   sexp.DialectCommonLisp,
+	"none":   sexp.DialectNone,
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: sexp2json [flags] [file ...]\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	m, ok := mappings[*mapping]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "sexp2json: unknown mapping %q\n", *mapping)
+		os.Exit(2)
+	}
+	d, ok := dialects[*dialect]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "sexp2json: unknown dialect %q\n", *dialect)
+		os.Exit(2)
+	}
+
+	// the output is not buffered, so that each value is written as soon as it is read.
+	w := os.Stdout
+	convert := func(r io.Reader) error {
+		if *reverse {
+			return fromJSON(w, r, m)
+		}
+		return toJSON(w, r, m, d)
+	}
+
+	if flag.NArg() == 0 {
+		err := convert(os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "<standard input>: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	for _, filename := range flag.Args() {
+		f, err := os.Open(filename)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		err = convert(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", filename, err)
+			os.Exit(1)
+		}
+	}
+}
+
+// toJSON writes the JSON representation of each expression read from r.
+func toJSON(w io.Writer, r io.Reader, m sexp.JSONMapping, d sexp.Dialect) error {
+	dec := sexp.NewDecoder(r, sexp.WithDialect(d))
+	for {
+		s, err := dec.Decode()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		b, err := sexp.ToJSON(s, m)
+		if err != nil {
+			return fmt.Errorf("%s: %v", s.Start, err)
+		}
+		_, err = fmt.Fprintf(w, "%s\n", b)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// fromJSON writes the expression for each JSON value read from r.
+func fromJSON(w io.Writer, r io.Reader, m sexp.JSONMapping) error {
+	dec := json.NewDecoder(r)
+	for i := 1; ; i++ {
+		var raw json.RawMessage
+		err := dec.Decode(&raw)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("value %d: %v", i, err)
+		}
+		s, err := sexp.FromJSON(raw, m)
+		if err != nil {
+			return fmt.Errorf("value %d: %v", i, err)
+		}
+		_, err = fmt.Fprintf(w, "%s\n", s)
+		if err != nil {
+			return err
+		}
+	}
+}
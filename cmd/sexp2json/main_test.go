@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bearmini/sexp"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestToJSON(t *testing.T) {
+	var buf bytes.Buffer
+	src := "(module (func $f)) ;; a module\n((host \"x\") (port 8080))\n"
+	err := toJSON(&buf, strings.NewReader(src), sexp.JSONNatural, sexp.DialectWAT)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	expected := `["module",["func","$f"]]
+{"host":"x","port":8080}
+`
+	if expected != buf.String() {
+		t.Fatalf("\n%s", pretty.Compare(expected, buf.String()))
+	}
+}
+
+// lineWriter sends each write to a channel.
+type lineWriter chan string
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestToJSONStream(t *testing.T) {
+	// the pipe is kept open: each value must be written as soon as its expression is read.
+	r, pw := io.Pipe()
+	defer pw.Close()
+	go func() {
+		_, _ = pw.Write([]byte("(a)\n"))
+	}()
+
+	w := make(lineWriter)
+	go func() {
+		_ = toJSON(w, r, sexp.JSONNatural, sexp.DialectWAT)
+	}()
+
+	select {
+	case a := <-w:
+		if a != "[\"a\"]\n" {
+			t.Fatalf("\nExpected: %q\nActual:   %q", "[\"a\"]\n", a)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("the value is not written before the end of input")
+	}
+}
+
+func TestFromJSON(t *testing.T) {
+	var buf bytes.Buffer
+	src := `[{"sym":"a"},{"num":"1"}]
+[{"str":"b"}]`
+	err := fromJSON(&buf, strings.NewReader(src), sexp.JSONTagged)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	expected := `(a 1)
+("b")
+`
+	if expected != buf.String() {
+		t.Fatalf("\n%s", pretty.Compare(expected, buf.String()))
+	}
+
+	err = fromJSON(&buf, strings.NewReader(`[] [{"x":"y"}]`), sexp.JSONTagged)
+	expectedErr := `value 2: sexp: invalid tagged JSON: unknown tag "x"`
+	if err == nil || expectedErr != err.Error() {
+		t.Fatalf("\nExpected: %s\nActual:   %v", expectedErr, err)
+	}
+}
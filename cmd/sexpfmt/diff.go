@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around changes, as in diff -u.
+const diffContext = 3
+
+// lineOp is an operation of an edit script: an unchanged (' '), deleted ('-') or
+// inserted ('+') line, with its line break if it has one.
+type lineOp struct {
+	kind byte
+	line string
+}
+
+// unifiedDiff returns the differences between a and b in the unified format of
+// diff -u, or nil if there are none.
+func unifiedDiff(nameA, nameB string, a, b []byte) []byte {
+	ops := diffLines(splitLines(a), splitLines(b))
+
+	// posA[i] and posB[i] are the numbers of lines of a and b before ops[i].
+	posA := make([]int, len(ops)+1)
+	posB := make([]int, len(ops)+1)
+	for i, op := range ops {
+		posA[i+1], posB[i+1] = posA[i], posB[i]
+		if op.kind != '+' {
+			posA[i+1]++
+		}
+		if op.kind != '-' {
+			posB[i+1]++
+		}
+	}
+
+	var buf bytes.Buffer
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		// changes separated by at most twice the context go into the same hunk.
+		start := i - diffContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for {
+			for end < len(ops) && ops[end].kind != ' ' {
+				end++
+			}
+			next := end
+			for next < len(ops) && next < end+2*diffContext && ops[next].kind == ' ' {
+				next++
+			}
+			if next == len(ops) || ops[next].kind == ' ' {
+				break
+			}
+			end = next
+		}
+		stop := end + diffContext
+		if stop > len(ops) {
+			stop = len(ops)
+		}
+
+		if buf.Len() == 0 {
+			fmt.Fprintf(&buf, "--- %s\n+++ %s\n", nameA, nameB)
+		}
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", hunkRange(posA[start], posA[stop]), hunkRange(posB[start], posB[stop]))
+		for _, op := range ops[start:stop] {
+			buf.WriteByte(op.kind)
+			buf.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = stop
+	}
+
+	if buf.Len() == 0 {
+		return nil
+	}
+	return buf.Bytes()
+}
+
+// hunkRange formats the lines from start to end, counted from 0, as in a hunk header.
+func hunkRange(start, end int) string {
+	switch end - start {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, end-start)
+}
+
+// splitLines splits b into lines, each with its line break if it has one.
+func splitLines(b []byte) []string {
+	lines := []string{}
+	for len(b) > 0 {
+		i := bytes.IndexByte(b, '\n') + 1
+		if i == 0 {
+			i = len(b)
+		}
+		lines = append(lines, string(b[:i]))
+		b = b[i:]
+	}
+	return lines
+}
+
+// diffLines returns a shortest edit script turning a into b.
+func diffLines(a, b []string) []lineOp {
+	// the common prefix and suffix are left out of the search.
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+
+	ops := []lineOp{}
+	for _, line := range a[:pre] {
+		ops = append(ops, lineOp{kind: ' ', line: line})
+	}
+	ops = append(ops, myers(a[pre:len(a)-suf], b[pre:len(b)-suf])...)
+	for _, line := range a[len(a)-suf:] {
+		ops = append(ops, lineOp{kind: ' ', line: line})
+	}
+	return ops
+}
+
+// myers computes a shortest edit script with Myers' O(ND) algorithm.
+func myers(a, b []string) []lineOp {
+	n, m := len(a), len(b)
+	max := n + m
+
+	// v[max+k] is the furthest x reached on diagonal k = x - y. trace[d] keeps the
+	// diagonals -d to d of v before step d, for finding the path back.
+	v := make([]int, 2*max+2)
+	trace := [][]int{}
+	d := 0
+search:
+	for ; d <= max; d++ {
+		trace = append(trace, append([]int{}, v[max-d:max+d+1]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || k != d && v[max+k-1] < v[max+k+1] {
+				x = v[max+k+1] // an insertion
+			} else {
+				x = v[max+k-1] + 1 // a deletion
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[max+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// the path is followed back from the end, so the operations are reversed.
+	ops := []lineOp{}
+	x, y := n, m
+	for ; d > 0; d-- {
+		prev := trace[d]
+		at := func(k int) int { return prev[k+d] }
+		k := x - y
+		prevK := k - 1
+		if k == -d || k != d && at(k-1) < at(k+1) {
+			prevK = k + 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, lineOp{kind: ' ', line: a[x]})
+		}
+		if x == prevX {
+			y--
+			ops = append(ops, lineOp{kind: '+', line: b[y]})
+		} else {
+			x--
+			ops = append(ops, lineOp{kind: '-', line: a[x]})
+		}
+	}
+	for x > 0 {
+		x--
+		ops = append(ops, lineOp{kind: ' ', line: a[x]})
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
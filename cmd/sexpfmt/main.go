@@ -0,0 +1,251 @@
+// Command sexpfmt formats S-expressions.
+//
+// Usage:
+//
+//	sexpfmt [flags] [path ...]
+//
+// Without paths, it formats the standard input. Directories are walked for
+// files with a known extension (.wat, .wast, .scm, .ss, .lisp, .lsp, .cl, .el).
+// Comments are preserved, and at most one blank line is kept between expressions.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bearmini/sexp"
+)
+
+var (
+	write   = flag.Bool("w", false, "write result to (source) file instead of stdout")
+	list    = flag.Bool("l", false, "list files whose formatting differs from sexpfmt's")
+	doDiff  = flag.Bool("d", false, "display diffs instead of rewriting files")
+	dialect = flag.String("dialect", "", "comment syntax: wat, scheme, lisp or none (default: by file extension, wat for stdin)")
+	width   = flag.Int("width", sexp.DefaultPrintOptions.Width, "target line width")
+	indent  = flag.Int("indent", sexp.DefaultPrintOptions.Indent, "indentation of list bodies")
+)
+
+var dialects = map[string]sexp.Dialect{
+	"wat":    sexp.DialectWAT,
+	"scheme": sexp.DialectScheme,
+	"lisp":   sexp.DialectCommonLisp,
+	"none":   sexp.DialectNone,
+}
+
+var extensions = map[string]string{
+	".wat":  "wat",
+	".wast": "wat",
+	".scm":  "scheme",
+	".ss":   "scheme",
+	".lisp": "lisp",
+	".lsp":  "lisp",
+	".cl":   "lisp",
+	".el":   "lisp",
+}
+
+var exitCode = 0
+
+func report(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	exitCode = 2
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: sexpfmt [flags] [path ...]\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if *dialect != "" {
+		if _, ok := dialects[*dialect]; !ok {
+			fmt.Fprintf(os.Stderr, "sexpfmt: unknown dialect %q\n", *dialect)
+			os.Exit(2)
+		}
+	}
+
+	if flag.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "sexpfmt: cannot use -w with standard input")
+			os.Exit(2)
+		}
+		err := processFile("<standard input>", os.Stdin, os.Stdout)
+		if err != nil {
+			report(err)
+		}
+		os.Exit(exitCode)
+	}
+
+	for _, path := range flag.Args() {
+		fi, err := os.Stat(path)
+		if err != nil {
+			report(err)
+			continue
+		}
+		if fi.IsDir() {
+			walkDir(path)
+			continue
+		}
+		err = processFile(path, nil, os.Stdout)
+		if err != nil {
+			report(err)
+		}
+	}
+	os.Exit(exitCode)
+}
+
+func walkDir(root string) {
+	err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			report(err)
+			return nil
+		}
+		if fi.IsDir() || strings.HasPrefix(fi.Name(), ".") {
+			return nil
+		}
+		if _, ok := extensions[filepath.Ext(path)]; !ok {
+			return nil
+		}
+		err = processFile(path, nil, os.Stdout)
+		if err != nil {
+			report(err)
+		}
+		return nil
+	})
+	if err != nil {
+		report(err)
+	}
+}
+
+func dialectFor(filename string) sexp.Dialect {
+	name := *dialect
+	if name == "" {
+		name = extensions[filepath.Ext(filename)]
+	}
+	if d, ok := dialects[name]; ok {
+		return d
+	}
+	return sexp.DialectWAT
+}
+
+// processFile formats the file and writes, lists or diffs the result as requested.
+// If in is nil, the file is opened by name.
+func processFile(filename string, in io.Reader, out io.Writer) error {
+	if in == nil {
+		f, err := os.Open(filename)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+
+	src, err := ioutil.ReadAll(in)
+	if err != nil {
+		return err
+	}
+
+	opts := &sexp.PrintOptions{
+		Width:  *width,
+		Indent: *indent,
+		Rules:  sexp.DefaultPrintOptions.Rules,
+	}
+	res, err := format(src, dialectFor(filename), opts)
+	if err != nil {
+		return fmt.Errorf("%s:%v", filename, err)
+	}
+
+	if !bytes.Equal(src, res) {
+		if *list {
+			fmt.Fprintln(out, filename)
+		}
+		if *write {
+			fi, err := os.Stat(filename)
+			if err != nil {
+				return err
+			}
+			err = ioutil.WriteFile(filename, res, fi.Mode().Perm())
+			if err != nil {
+				return err
+			}
+		}
+		if *doDiff {
+			_, err = out.Write(unifiedDiff(filename+".orig", filename, src, res))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if !*list && !*write && !*doDiff {
+		_, err = out.Write(res)
+	}
+	return err
+}
+
+// format returns src with every top-level expression laid out by sexp.Fprint.
+func format(src []byte, d sexp.Dialect, opts *sexp.PrintOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	dec := sexp.NewDecoder(bytes.NewReader(src), sexp.WithDialect(d), sexp.KeepComments())
+
+	line := 0 // the last line written, or 0 if nothing was written
+	separate := func(next int) {
+		if line == 0 {
+			return
+		}
+		buf.WriteString("\n")
+		if next > line+1 {
+			// keep a blank line, but only one
+			buf.WriteString("\n")
+		}
+	}
+
+	for {
+		s, err := dec.Decode()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		separate(firstLine(s))
+		err = sexp.Fprint(&buf, s, opts)
+		if err != nil {
+			return nil, err
+		}
+		line = lastLine(s)
+	}
+
+	for _, c := range dec.TrailingComments() {
+		separate(c.Start.Line)
+		buf.WriteString(c.Value)
+		line = c.End.Line
+	}
+
+	if buf.Len() > 0 {
+		buf.WriteString("\n")
+	}
+	return buf.Bytes(), nil
+}
+
+func lastLine(s *sexp.Sexp) int {
+	if n := len(s.TrailingComments); n > 0 {
+		return s.TrailingComments[n-1].End.Line
+	}
+	return s.End.Line
+}
+
+func firstLine(s *sexp.Sexp) int {
+	if len(s.LeadingComments) > 0 {
+		return s.LeadingComments[0].Start.Line
+	}
+	return s.Start.Line
+}
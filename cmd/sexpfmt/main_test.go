@@ -0,0 +1,130 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bearmini/sexp"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestFormat(t *testing.T) {
+	testData := []struct {
+		Name     string
+		Pattern  string
+		Expected string
+	}{
+		{
+			Name:     "pattern 1 - empty",
+			Pattern:  "",
+			Expected: "",
+		},
+		{
+			Name:     "pattern 2 - expressions and blank lines",
+			Pattern:  "(a   b)\n(c)\n\n\n\n(d)",
+			Expected: "(a b)\n(c)\n\n(d)\n",
+		},
+		{
+			Name:     "pattern 3 - comments",
+			Pattern:  ";; header\n\n(module ;; m\n (memory 1))\n\n;; end\n",
+			Expected: ";; header\n\n(module ;; m\n  (memory 1))\n\n;; end\n",
+		},
+		{
+			Name:     "pattern 4 - only comments",
+			Pattern:  ";; a\n;; b",
+			Expected: ";; a\n;; b\n",
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			a, err := format([]byte(data.Pattern), sexp.DialectWAT, sexp.DefaultPrintOptions)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if data.Expected != string(a) {
+				t.Fatalf("\n%s", pretty.Compare(data.Expected, string(a)))
+			}
+
+			// formatting is idempotent
+			b, err := format(a, sexp.DialectWAT, sexp.DefaultPrintOptions)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(a) != string(b) {
+				t.Fatalf("\n%s", pretty.Compare(string(a), string(b)))
+			}
+		})
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	testData := []struct {
+		Name     string
+		A        string
+		B        string
+		Expected string
+	}{
+		{
+			Name:     "pattern 1 - no differences",
+			A:        "(a)\n(b)\n",
+			B:        "(a)\n(b)\n",
+			Expected: "",
+		},
+		{
+			Name:     "pattern 2 - changed line",
+			A:        "1\n2\n3\n4\n5\nX\n7\n8\n9\n10\n",
+			B:        "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			Expected: "--- x.wat.orig\n+++ x.wat\n@@ -3,7 +3,7 @@\n 3\n 4\n 5\n-X\n+6\n 7\n 8\n 9\n",
+		},
+		{
+			Name:     "pattern 3 - two hunks",
+			A:        "X\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\nX\n13\n14\n",
+			B:        "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n13\n14\n",
+			Expected: "--- x.wat.orig\n+++ x.wat\n@@ -1,4 +1,4 @@\n-X\n+1\n 2\n 3\n 4\n@@ -9,6 +9,6 @@\n 9\n 10\n 11\n-X\n+12\n 13\n 14\n",
+		},
+		{
+			Name:     "pattern 4 - no line break at the end",
+			A:        "(a)",
+			B:        "(a)\n",
+			Expected: "--- x.wat.orig\n+++ x.wat\n@@ -1 +1 @@\n-(a)\n\\ No newline at end of file\n+(a)\n",
+		},
+		{
+			Name:     "pattern 5 - empty file",
+			A:        "",
+			B:        "(a)\n",
+			Expected: "--- x.wat.orig\n+++ x.wat\n@@ -0,0 +1 @@\n+(a)\n",
+		},
+		{
+			Name:     "pattern 6 - deleted line",
+			A:        "(a)\n(b)\n",
+			B:        "(a)\n",
+			Expected: "--- x.wat.orig\n+++ x.wat\n@@ -1,2 +1 @@\n (a)\n-(b)\n",
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			a := string(unifiedDiff("x.wat.orig", "x.wat", []byte(data.A), []byte(data.B)))
+			if data.Expected != a {
+				t.Fatalf("\n%s", pretty.Compare(data.Expected, a))
+			}
+		})
+	}
+}
+
+func TestFormatError(t *testing.T) {
+	_, err := format([]byte("(a)\n(b"), sexp.DialectWAT, sexp.DefaultPrintOptions)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	expected := `2:3: expected ")", but found EOF: "(b"`
+	if expected != err.Error() {
+		t.Fatalf("\nExpected: %s\nActual:   %s", expected, err.Error())
+	}
+}
@@ -0,0 +1,81 @@
+// Command wast2json converts a WebAssembly script (.wast) to a JSON command manifest
+// in the format of the reference toolchain's wast2json.
+//
+// Usage:
+//
+//	wast2json [-o output.json] file.wast
+//
+// The modules of the script are written next to the manifest, named after it, as in
+// i32.0.wasm. Text modules are written as .wat files.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bearmini/sexp/wast"
+)
+
+var output = flag.String("o", "", "write the manifest to `file` (default: the script name with .json)")
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: wast2json [-o output.json] file.wast\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+	filename := flag.Arg(0)
+
+	out := *output
+	if out == "" {
+		out = strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename)) + ".json"
+	}
+
+	err := convert(filename, out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", filename, err)
+		os.Exit(1)
+	}
+}
+
+// convert writes the manifest of the script in filename to out, and the modules of the
+// script to the directory of out.
+func convert(filename, out string) error {
+	src, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	script, err := wast.Parse(string(src))
+	if err != nil {
+		return err
+	}
+
+	base := strings.TrimSuffix(filepath.Base(out), filepath.Ext(out))
+	manifest, files, err := wast.ToJSON(script, &wast.JSONOptions{
+		SourceFilename: filepath.Base(filename),
+		ModuleBase:     base,
+	})
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(out)
+	for _, f := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, f.Name), f.Data, 0666)
+		if err != nil {
+			return err
+		}
+	}
+	return ioutil.WriteFile(out, manifest, 0666)
+}
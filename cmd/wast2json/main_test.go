@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestConvert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wast2json")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "test.wast")
+	src := `(module binary "\00asm" "\01\00\00\00")
+(assert_return (invoke "f" (i32.const -1)) (i32.const 1))
+`
+	err = ioutil.WriteFile(filename, []byte(src), 0666)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	err = convert(filename, filepath.Join(dir, "out.json"))
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "out.json"))
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	expected := `{"source_filename": "test.wast",
+ "commands": [
+  {"type": "module", "line": 1, "filename": "out.0.wasm"}, 
+  {"type": "assert_return", "line": 2, "action": {"type": "invoke", "field": "f", "args": [{"type": "i32", "value": "4294967295"}]}, "expected": [{"type": "i32", "value": "1"}]}]}
+`
+	if expected != string(b) {
+		t.Fatalf("\n%s", pretty.Compare(expected, string(b)))
+	}
+
+	b, err = ioutil.ReadFile(filepath.Join(dir, "out.0.wasm"))
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if string(b) != "\x00asm\x01\x00\x00\x00" {
+		t.Fatalf("\nExpected: %q\nActual:   %q", "\x00asm\x01\x00\x00\x00", b)
+	}
+}
+
+func TestConvertError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "wast2json")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "test.wast")
+	err = ioutil.WriteFile(filename, []byte(`(assert_return (invoke "f" (i32.const x)))`), 0666)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	err = convert(filename, filepath.Join(dir, "out.json"))
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	_, err = os.Stat(filepath.Join(dir, "out.json"))
+	if !os.IsNotExist(err) {
+		t.Fatalf("the manifest should not be written: %v", err)
+	}
+}
@@ -0,0 +1,264 @@
+package sexp
+
+import (
+	"io"
+	"strings"
+)
+
+// This file implements a concrete syntax tree that keeps everything in the input,
+// so that an unmodified tree is printed back byte for byte. Use it to edit source
+// files without losing comments and formatting; Sexp is simpler for everything else.
+
+type TriviaKind int
+
+const (
+	TriviaWhitespace TriviaKind = iota // white space other than line breaks
+	TriviaNewline                      // a line break, "\n" or "\r\n"
+	TriviaComment
+)
+
+// Trivia is text between tokens that does not affect the meaning of the input.
+type Trivia struct {
+	Kind TriviaKind
+	Text string
+}
+
+// Node is an atom or a list of a concrete syntax tree.
+//
+// Trivia before a node belongs to the node as Leading, except for the trivia on the
+// same line after the previous sibling, which belongs to the sibling as Trailing up
+// to and including the line break.
+type Node struct {
+	Leading []Trivia
+
+	// Token is the atom, or the open paren of a list. Its Value is the exact text in
+	// the input and is printed as it is, so changing it edits the source.
+	Token    *Token
+	Children []*Node  // valid if the node is a list
+	Inner    []Trivia // trivia before the close paren of a list
+	Close    *Token   // the close paren of a list, nil for an atom
+	Trailing []Trivia
+}
+
+// CST is a whole input parsed as a concrete syntax tree.
+type CST struct {
+	Nodes    []*Node
+	Trailing []Trivia // trivia after the last node
+}
+
+// ParseCST parses all the top-level expressions in src into a concrete syntax tree.
+// Comments are recognized as specified by the options; KeepComments is implied.
+func ParseCST(src string, opts ...LexerOption) (*CST, error) {
+	opts = append(opts[:len(opts):len(opts)], KeepComments())
+	p := &cstParser{
+		src: src,
+		lex: NewLexer(strings.NewReader(src), opts...),
+	}
+	c, err := p.parseCST()
+	if err != nil {
+		return nil, withExcerpt(err, src)
+	}
+	return c, nil
+}
+
+func (n *Node) IsAtom() bool {
+	return n.Close == nil
+}
+
+func (n *Node) IsList() bool {
+	return n.Close != nil
+}
+
+// Sexp converts the node to the simplified model, dropping the trivia.
+func (n *Node) Sexp() *Sexp {
+	if n.IsAtom() {
+		return newAtom(n.Token)
+	}
+	s := &Sexp{
+		Children: []*Sexp{},
+		Start:    n.Token.Start,
+		End:      n.Close.End,
+	}
+	for _, c := range n.Children {
+		s.Children = append(s.Children, c.Sexp())
+	}
+	return s
+}
+
+// String returns the text of the node including its trivia.
+func (n *Node) String() string {
+	var b strings.Builder
+	n.writeTo(&b)
+	return b.String()
+}
+
+func (n *Node) writeTo(b *strings.Builder) {
+	writeTrivia(b, n.Leading)
+	b.WriteString(n.Token.Value)
+	if n.IsList() {
+		for _, c := range n.Children {
+			c.writeTo(b)
+		}
+		writeTrivia(b, n.Inner)
+		b.WriteString(n.Close.Value)
+	}
+	writeTrivia(b, n.Trailing)
+}
+
+// String returns the text of the tree. It is the input of ParseCST if the tree is unmodified.
+func (c *CST) String() string {
+	var b strings.Builder
+	for _, n := range c.Nodes {
+		n.writeTo(&b)
+	}
+	writeTrivia(&b, c.Trailing)
+	return b.String()
+}
+
+// WriteTo writes the text of the tree to w.
+func (c *CST) WriteTo(w io.Writer) (int64, error) {
+	n, err := io.WriteString(w, c.String())
+	return int64(n), err
+}
+
+func writeTrivia(b *strings.Builder, trivia []Trivia) {
+	for _, t := range trivia {
+		b.WriteString(t.Text)
+	}
+}
+
+type cstParser struct {
+	src    string
+	lex    *Lexer
+	offset int      // the end of the last token read
+	trivia []Trivia // the trivia read since the last token
+}
+
+// next returns the next token other than a comment, and the trivia before it.
+// It returns a nil token at the end of input.
+func (p *cstParser) next() (*Token, []Trivia, error) {
+	for {
+		token := p.lex.NextToken()
+		if token == nil {
+			if p.lex.Err() != nil {
+				return nil, nil, p.lex.Err()
+			}
+			p.addWhitespace(len(p.src))
+			return nil, p.takeTrivia(), nil
+		}
+
+		p.addWhitespace(token.Start.Offset)
+		p.offset = token.End.Offset
+		text := p.src[token.Start.Offset:token.End.Offset]
+		if token.Type == TokenTypeComment {
+			p.trivia = append(p.trivia, Trivia{Kind: TriviaComment, Text: text})
+			continue
+		}
+
+		return token, p.takeTrivia(), nil
+	}
+}
+
+func (p *cstParser) takeTrivia() []Trivia {
+	trivia := p.trivia
+	p.trivia = nil
+	return trivia
+}
+
+// addWhitespace adds the white space from the end of the last token to the given offset.
+func (p *cstParser) addWhitespace(end int) {
+	s := p.src[p.offset:end]
+	p.offset = end
+	for s != "" {
+		i := strings.IndexByte(s, '\n')
+		if i < 0 {
+			p.trivia = append(p.trivia, Trivia{Kind: TriviaWhitespace, Text: s})
+			return
+		}
+
+		nl := 1
+		if i > 0 && s[i-1] == '\r' {
+			i--
+			nl = 2
+		}
+		if i > 0 {
+			p.trivia = append(p.trivia, Trivia{Kind: TriviaWhitespace, Text: s[:i]})
+		}
+		p.trivia = append(p.trivia, Trivia{Kind: TriviaNewline, Text: s[i : i+nl]})
+		s = s[i+nl:]
+	}
+}
+
+// splitTrailing splits trivia after a node into its trailing trivia and the rest.
+func splitTrailing(trivia []Trivia) ([]Trivia, []Trivia) {
+	for i, t := range trivia {
+		if t.Kind == TriviaNewline {
+			return trivia[:i+1], trivia[i+1:]
+		}
+	}
+	return trivia, nil
+}
+
+func (p *cstParser) parseCST() (*CST, error) {
+	c := &CST{Nodes: []*Node{}}
+	var last *Node
+	for {
+		token, trivia, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if last != nil {
+			last.Trailing, trivia = splitTrailing(trivia)
+		}
+		if token == nil {
+			c.Trailing = trivia
+			return c, nil
+		}
+
+		last, err = p.parseNode(token, trivia)
+		if err != nil {
+			return nil, err
+		}
+		c.Nodes = append(c.Nodes, last)
+	}
+}
+
+func (p *cstParser) parseNode(token *Token, leading []Trivia) (*Node, error) {
+	n := &Node{
+		Leading: leading,
+		Token:   token,
+	}
+	switch token.Type {
+	case TokenTypeSymbol, TokenTypeString, TokenTypeNumber:
+		return n, nil
+	case TokenTypeOpenParen:
+	default:
+		return nil, p.lex.unexpectedToken(token, "expression")
+	}
+
+	n.Children = []*Node{}
+	var last *Node
+	for {
+		token, trivia, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if token == nil {
+			return nil, p.lex.unexpectedEOF(`")"`)
+		}
+		if last != nil {
+			last.Trailing, trivia = splitTrailing(trivia)
+		}
+		if token.Type == TokenTypeCloseParen {
+			n.Inner = trivia
+			n.Close = token
+			return n, nil
+		}
+
+		last, err = p.parseNode(token, trivia)
+		if err != nil {
+			return nil, err
+		}
+		n.Children = append(n.Children, last)
+	}
+}
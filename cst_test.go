@@ -0,0 +1,149 @@
+package sexp
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestParseCSTRoundTrip(t *testing.T) {
+	testData := []struct {
+		Name    string
+		Pattern string
+		Options []LexerOption
+	}{
+		{
+			Name:    "pattern 1 - empty",
+			Pattern: "",
+		},
+		{
+			Name:    "pattern 2 - white space only",
+			Pattern: " \t\r\n\n",
+		},
+		{
+			Name: "pattern 3 - wat module",
+			Pattern: `;; a module
+(module $m   ;; the name
+  (func $f (param i32)   (; inline ;) (result i32)
+    local.get 0)
+
+
+  ( memory 1 )
+  ;; the end
+)
+`,
+		},
+		{
+			Name:    "pattern 4 - strings, numbers and CRLF",
+			Pattern: "(a \"b\\\"c\"\r\n  0x1_0 -inf\t\"\xff\" sym\xfe)\r\n",
+		},
+		{
+			Name:    "pattern 5 - datum comment",
+			Pattern: "(define x #;(unused (stuff)) 1) ; done",
+			Options: []LexerOption{WithDialect(DialectScheme)},
+		},
+		{
+			Name:    "pattern 6 - no trailing line break",
+			Pattern: "(a)(b) c",
+		},
+		{
+			Name:    "pattern 7 - invalid UTF-8 in string literals",
+			Pattern: "(data \"\xff\xc3\" \"\xe2\x82\" (; \xfe ;) \"ok\xc0\xaf\")\n",
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			c, err := ParseCST(data.Pattern, data.Options...)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			a := c.String()
+			if data.Pattern != a {
+				t.Fatalf("\n%s", pretty.Compare(data.Pattern, a))
+			}
+		})
+	}
+}
+
+func TestParseCSTTrivia(t *testing.T) {
+	c, err := ParseCST(";; a\n(b ;; c\n  d)\n\n;; e\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	n := c.Nodes[0]
+	a := [][]Trivia{n.Leading, n.Children[0].Trailing, n.Children[1].Leading, n.Trailing, c.Trailing}
+	expected := [][]Trivia{
+		{{Kind: TriviaComment, Text: ";; a"}, {Kind: TriviaNewline, Text: "\n"}},
+		{{Kind: TriviaWhitespace, Text: " "}, {Kind: TriviaComment, Text: ";; c"}, {Kind: TriviaNewline, Text: "\n"}},
+		{{Kind: TriviaWhitespace, Text: "  "}},
+		{{Kind: TriviaNewline, Text: "\n"}},
+		{{Kind: TriviaNewline, Text: "\n"}, {Kind: TriviaComment, Text: ";; e"}, {Kind: TriviaNewline, Text: "\n"}},
+	}
+	if !reflect.DeepEqual(expected, a) {
+		t.Fatalf("\n%s", pretty.Compare(expected, a))
+	}
+
+	if n.Sexp().String() != "(b d)" {
+		t.Fatalf("unexpected expression: %s", n.Sexp())
+	}
+}
+
+func TestParseCSTEdit(t *testing.T) {
+	c, err := ParseCST("(module\n  ;; memory\n  (memory 1)   ;; pages\n  (func $f))\n")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	c.Nodes[0].Children[1].Children[1].Token.Value = "16"
+	c.Nodes[0].Children = append(c.Nodes[0].Children[:2], c.Nodes[0].Children[3:]...)
+
+	expected := "(module\n  ;; memory\n  (memory 16)   ;; pages\n)\n"
+	a := c.String()
+	if expected != a {
+		t.Fatalf("\n%s", pretty.Compare(expected, a))
+	}
+}
+
+func TestParseCSTError(t *testing.T) {
+	testData := []struct {
+		Name     string
+		Pattern  string
+		Expected string
+	}{
+		{
+			Name:     "pattern 1 - not terminated",
+			Pattern:  "(a\n  (b)",
+			Expected: `2:6: expected ")", but found EOF: "  (b)"`,
+		},
+		{
+			Name:     "pattern 2 - unexpected close paren",
+			Pattern:  "(a))",
+			Expected: `1:4: expected expression, but found ")": "(a))"`,
+		},
+		{
+			Name:     "pattern 3 - string not terminated",
+			Pattern:  `(a "b)`,
+			Expected: `1:4: string literal not terminated: "(a \"b)"`,
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			_, err := ParseCST(data.Pattern)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if data.Expected != err.Error() {
+				t.Fatalf("\nExpected: %s\nActual:   %s", data.Expected, err.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package sexp
+
+import (
+	"io"
+)
+
+// Decoder reads top-level expressions one at a time from an input stream.
+type Decoder struct {
+	lex      *Lexer
+	src      *sourceBuffer
+	offset   int
+	trailing []*Token
+
+	disallowUnknownFields bool
+}
+
+func NewDecoder(r io.Reader, opts ...LexerOption) *Decoder {
+	src := &sourceBuffer{r: r}
+	return &Decoder{
+		lex: NewLexer(src, opts...),
+		src: src,
+	}
+}
+
+// Decode reads the next top-level expression.
+// It returns io.EOF when there are no more expressions in the input.
+func (dec *Decoder) Decode() (*Sexp, error) {
+	s, err := dec.parse()
+	if err != nil {
+		return nil, err
+	}
+	dec.src.discard(s.End.Offset)
+	return s, nil
+}
+
+// parse reads the next top-level expression, keeping its text in dec.src.
+func (dec *Decoder) parse() (*Sexp, error) {
+	comments := readComments(dec.lex)
+	s, err := parseExpr(dec.lex)
+	if err == io.EOF {
+		dec.trailing = append(dec.trailing, comments...)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.LeadingComments = comments
+	s.TrailingComments = append(s.TrailingComments, dec.readTrailingComments()...)
+
+	// tokens of a complete expression are never unread, so we can drop them
+	// to keep the memory usage proportional to a single expression.
+	dec.lex.forget()
+	dec.offset = s.End.Offset
+	return s, nil
+}
+
+// readTrailingComments reads the comments after an expression on the line where it ends.
+// The expression is complete, so the input is only read further if the Lexer keeps
+// comments and one has already started on that line.
+func (dec *Decoder) readTrailingComments() []*Token {
+	var comments []*Token
+	for dec.lex.keepComments && len(dec.lex.unread) == 0 && dec.lex.commentFollows() {
+		token := dec.lex.NextToken()
+		if token == nil {
+			break
+		}
+		if token.Type != TokenTypeComment {
+			_ = dec.lex.Unread()
+			break
+		}
+		comments = append(comments, token)
+	}
+	return comments
+}
+
+// TrailingComments returns the comments after the last expression in the input.
+// They are available once Decode has returned io.EOF and only if the Lexer keeps comments.
+func (dec *Decoder) TrailingComments() []*Token {
+	return dec.trailing
+}
+
+// More reports whether there is another expression in the input.
+// It returns false on errors as well; the following Decode reports them.
+func (dec *Decoder) More() bool {
+	n := 0
+	more := false
+	for {
+		token := dec.lex.NextToken()
+		if token == nil {
+			break
+		}
+		n++
+		if token.Type != TokenTypeComment {
+			more = true
+			break
+		}
+	}
+
+	// put everything back so that Decode sees the comments as well.
+	for i := 0; i < n; i++ {
+		err := dec.lex.Unread()
+		if err != nil {
+			return false
+		}
+	}
+	return more
+}
+
+// InputOffset returns the byte offset just after the most recently decoded expression.
+func (dec *Decoder) InputOffset() int64 {
+	return int64(dec.offset)
+}
@@ -0,0 +1,222 @@
+package sexp
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestDecoder(t *testing.T) {
+	testData := []struct {
+		Name            string
+		Pattern         string
+		Expected        []string
+		ExpectedOffsets []int64
+	}{
+		{
+			Name:            "pattern 1 - empty",
+			Pattern:         "",
+			Expected:        []string{},
+			ExpectedOffsets: []int64{},
+		},
+		{
+			Name:            "pattern 2 - single",
+			Pattern:         "(a b c)\n",
+			Expected:        []string{"(a b c)"},
+			ExpectedOffsets: []int64{7},
+		},
+		{
+			Name:            "pattern 3 - multiple",
+			Pattern:         "(a)\n(b (c))\nd \"e\"",
+			Expected:        []string{"(a)", "(b (c))", "d", `"e"`},
+			ExpectedOffsets: []int64{3, 11, 13, 17},
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			dec := NewDecoder(strings.NewReader(data.Pattern))
+			a := []string{}
+			offsets := []int64{}
+			for dec.More() {
+				s, err := dec.Decode()
+				if err != nil {
+					t.Fatalf("unexpected error: %+v", err)
+				}
+				a = append(a, s.String())
+				offsets = append(offsets, dec.InputOffset())
+			}
+			_, err := dec.Decode()
+			if err != io.EOF {
+				t.Fatalf("expected io.EOF, but got %+v", err)
+			}
+			if !reflect.DeepEqual(data.Expected, a) {
+				t.Fatalf("\n%s", pretty.Compare(data.Expected, a))
+			}
+			if !reflect.DeepEqual(data.ExpectedOffsets, offsets) {
+				t.Fatalf("\n%s", pretty.Compare(data.ExpectedOffsets, offsets))
+			}
+		})
+	}
+}
+
+func TestDecoderTrailingComment(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("(a) ;; b\n(; c ;)"), KeepComments())
+	if !dec.More() {
+		t.Fatalf("expected more input")
+	}
+	s, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if s.String() != "(a)" {
+		t.Fatalf("unexpected expression: %s", s)
+	}
+	if dec.More() {
+		t.Fatalf("expected no more input")
+	}
+	_, err = dec.Decode()
+	if err != io.EOF {
+		t.Fatalf("expected io.EOF, but got %+v", err)
+	}
+}
+
+func TestDecoderPipe(t *testing.T) {
+	testData := []struct {
+		Name     string
+		Pattern  string
+		Options  []LexerOption
+		Expected string
+	}{
+		{
+			Name:     "pattern 1 - list",
+			Pattern:  "(a)\n",
+			Expected: "(a)",
+		},
+		{
+			Name:     "pattern 2 - list without a newline",
+			Pattern:  "(a)",
+			Expected: "(a)",
+		},
+		{
+			Name:     "pattern 3 - trailing comment",
+			Pattern:  "(a) ;; b\n",
+			Options:  []LexerOption{KeepComments()},
+			Expected: "(a) ;; b",
+		},
+		{
+			Name:     "pattern 4 - comments kept, without a newline",
+			Pattern:  "(a)",
+			Options:  []LexerOption{KeepComments()},
+			Expected: "(a)",
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			// the pipe is kept open: Decode must return the complete expression without more input.
+			r, w := io.Pipe()
+			defer w.Close()
+			go func() {
+				_, _ = w.Write([]byte(data.Pattern))
+			}()
+
+			done := make(chan string)
+			go func() {
+				s, err := NewDecoder(r, data.Options...).Decode()
+				if err != nil {
+					done <- err.Error()
+					return
+				}
+				a := s.String()
+				for _, c := range s.TrailingComments {
+					a += " " + c.Value
+				}
+				done <- a
+			}()
+
+			select {
+			case a := <-done:
+				if a != data.Expected {
+					t.Fatalf("\nExpected: %s\nActual:   %s", data.Expected, a)
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("Decode waits for more input")
+			}
+		})
+	}
+}
+
+func TestDecoderError(t *testing.T) {
+	dec := NewDecoder(strings.NewReader("(a)\n(b"))
+	_, err := dec.Decode()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if !dec.More() {
+		t.Fatalf("expected more input")
+	}
+	_, err = dec.Decode()
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	expected := `2:3: expected ")", but found EOF: "(b"`
+	if expected != err.Error() {
+		t.Fatalf("\nExpected: %s\nActual:   %s", expected, err.Error())
+	}
+}
+
+func TestDecoderMemory(t *testing.T) {
+	const n = 1000
+	r := strings.NewReader(strings.Repeat("(assert_return (invoke \"add\" (i32.const 1) (i32.const 1)) (i32.const 2))\n", n))
+	dec := NewDecoder(r)
+	count := 0
+	for dec.More() {
+		_, err := dec.Decode()
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+		if len(dec.lex.history) > 1 {
+			t.Fatalf("expected the token history to be dropped, but got %d tokens", len(dec.lex.history))
+		}
+		count++
+	}
+	if count != n {
+		t.Fatalf("expected %d expressions, but got %d", n, count)
+	}
+}
+
+func TestDecoderLongLine(t *testing.T) {
+	const n = 100000
+	r := strings.NewReader(strings.Repeat("(a b) ", n) + "(c))")
+	dec := NewDecoder(r)
+	count := 0
+	for dec.More() {
+		_, err := dec.Decode()
+		if err != nil {
+			expected := fmt.Sprintf(`1:%d: expected expression, but found ")": "...(a b) (a b) (a b) (a b) (a b) (a b) (c))"`, 6*n+4)
+			if expected != err.Error() {
+				t.Fatalf("\nExpected: %s\nActual:   %s", expected, err.Error())
+			}
+			break
+		}
+		if len(dec.lex.br.line) > 2*maxLineBytes {
+			t.Fatalf("expected the line to be dropped, but got %d bytes", len(dec.lex.br.line))
+		}
+		count++
+	}
+	if count != n+1 {
+		t.Fatalf("expected %d expressions, but got %d", n+1, count)
+	}
+}
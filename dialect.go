@@ -0,0 +1,41 @@
+package sexp
+
+// Dialect describes the comment syntax of an s-expression language.
+// An empty delimiter disables the corresponding kind of comment.
+type Dialect struct {
+	LineComment         string // starts a comment running to the end of the line
+	BlockCommentStart   string
+	BlockCommentEnd     string
+	NestedBlockComments bool
+	DatumComment        string // comments out the expression that follows it
+}
+
+var (
+	// DialectWAT is the WebAssembly text format: ;; line and (; nested block ;) comments.
+	DialectWAT = Dialect{
+		LineComment:         ";;",
+		BlockCommentStart:   "(;",
+		BlockCommentEnd:     ";)",
+		NestedBlockComments: true,
+	}
+
+	// DialectScheme is R7RS Scheme: ; line, #| nested block |# and #; datum comments.
+	DialectScheme = Dialect{
+		LineComment:         ";",
+		BlockCommentStart:   "#|",
+		BlockCommentEnd:     "|#",
+		NestedBlockComments: true,
+		DatumComment:        "#;",
+	}
+
+	// DialectCommonLisp is Common Lisp: ; line and #| nested block |# comments.
+	DialectCommonLisp = Dialect{
+		LineComment:         ";",
+		BlockCommentStart:   "#|",
+		BlockCommentEnd:     "|#",
+		NestedBlockComments: true,
+	}
+
+	// DialectNone recognizes no comments at all.
+	DialectNone = Dialect{}
+)
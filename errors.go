@@ -0,0 +1,87 @@
+package sexp
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// SyntaxError describes malformed input and where it was found.
+type SyntaxError struct {
+	Pos      Position
+	Msg      string // set when the error is not about a missing token
+	Expected string
+	Found    string
+	Excerpt  string // a short excerpt of the offending line
+}
+
+func (e *SyntaxError) Error() string {
+	msg := e.Msg
+	if msg == "" {
+		msg = fmt.Sprintf("expected %s, but found %s", e.Expected, e.Found)
+	}
+	if e.Excerpt == "" {
+		return fmt.Sprintf("%s: %s", e.Pos, msg)
+	}
+	return fmt.Sprintf("%s: %s: %q", e.Pos, msg, e.Excerpt)
+}
+
+const maxExcerptRunes = 40
+
+// excerpt shortens line to a window around the given column.
+func excerpt(line string, column int) string {
+	line = strings.TrimRight(line, "\r\n")
+	if utf8.RuneCountInString(line) <= maxExcerptRunes {
+		return line
+	}
+
+	rs := []rune(line)
+	start := column - 1 - maxExcerptRunes/2
+	if start < 0 {
+		start = 0
+	}
+	end := start + maxExcerptRunes
+	if end > len(rs) {
+		end = len(rs)
+		start = end - maxExcerptRunes
+	}
+
+	s := string(rs[start:end])
+	if start > 0 {
+		s = "..." + s
+	}
+	if end < len(rs) {
+		s = s + "..."
+	}
+	return s
+}
+
+// lineAt returns the line of src that contains pos.
+func lineAt(src string, pos Position) string {
+	if pos.Offset > len(src) {
+		return ""
+	}
+	start := strings.LastIndexByte(src[:pos.Offset], '\n') + 1
+	end := strings.IndexByte(src[pos.Offset:], '\n')
+	if end < 0 {
+		return src[start:]
+	}
+	return src[start : pos.Offset+end]
+}
+
+// withExcerpt fills in the excerpt of a syntax error using the whole source text.
+func withExcerpt(err error, src string) error {
+	se, ok := err.(*SyntaxError)
+	if !ok {
+		return err
+	}
+	se.Excerpt = excerpt(lineAt(src, se.Pos), se.Pos.Column)
+	return se
+}
+
+func describeToken(token *Token) string {
+	if token == nil {
+		return "EOF"
+	}
+	return fmt.Sprintf("%q", token.Value)
+}
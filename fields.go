@@ -0,0 +1,157 @@
+package sexp
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// field is an exported struct field as seen by Marshal and Unmarshal.
+type field struct {
+	name      string
+	index     []int // the index sequence for reflect.Value.FieldByIndex
+	typ       reflect.Type
+	omitEmpty bool
+	style     MapStyle // the style of the field value if it is a map or a struct
+	hasStyle  bool
+	tagged    bool // named by the tag rather than by the field name
+}
+
+var fieldCache sync.Map // map[reflect.Type][]field
+
+// cachedFields returns the fields of a struct type, including the promoted fields
+// of embedded structs without a tag. A field hides the fields of the same name
+// at deeper levels of embedding. Fields of the same name at the same level are
+// dropped, unless exactly one of them is named by a tag, as in encoding/json.
+func cachedFields(t reflect.Type) []field {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.([]field)
+	}
+	f, _ := fieldCache.LoadOrStore(t, typeFields(t))
+	return f.([]field)
+}
+
+func typeFields(t reflect.Type) []field {
+	fields := []field{}
+	seen := map[string]bool{}
+
+	type embedded struct {
+		typ   reflect.Type
+		index []int
+	}
+	current := []embedded{{typ: t}}
+	visited := map[reflect.Type]bool{}
+	for len(current) > 0 {
+		next := []embedded{}
+		names := []string{}
+		candidates := map[string][]field{}
+		for _, e := range current {
+			if visited[e.typ] {
+				continue
+			}
+
+			for i := 0; i < e.typ.NumField(); i++ {
+				sf := e.typ.Field(i)
+				tag := sf.Tag.Get("sexp")
+				if tag == "-" {
+					continue
+				}
+				index := append(append([]int{}, e.index...), i)
+
+				ft := sf.Type
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if sf.Anonymous && tag == "" && ft.Kind() == reflect.Struct {
+					next = append(next, embedded{typ: ft, index: index})
+					continue
+				}
+				if sf.PkgPath != "" {
+					continue // unexported
+				}
+
+				f := parseTag(tag)
+				f.tagged = f.name != ""
+				if !f.tagged {
+					f.name = sf.Name
+				}
+				if seen[f.name] {
+					continue
+				}
+				if candidates[f.name] == nil {
+					names = append(names, f.name)
+				}
+				f.index = index
+				f.typ = sf.Type
+				candidates[f.name] = append(candidates[f.name], f)
+			}
+		}
+		for _, e := range current {
+			visited[e.typ] = true
+		}
+
+		for _, name := range names {
+			seen[name] = true
+			if f, ok := dominantField(candidates[name]); ok {
+				fields = append(fields, f)
+			}
+		}
+		current = next
+	}
+	return fields
+}
+
+// dominantField returns the field of a name at a level of embedding: the only one,
+// or the only one named by a tag.
+func dominantField(fields []field) (field, bool) {
+	if len(fields) == 1 {
+		return fields[0], true
+	}
+	var tagged []field
+	for _, f := range fields {
+		if f.tagged {
+			tagged = append(tagged, f)
+		}
+	}
+	if len(tagged) == 1 {
+		return tagged[0], true
+	}
+	return field{}, false
+}
+
+// parseTag parses a tag such as `sexp:"name,omitempty,plist"`.
+func parseTag(tag string) field {
+	opts := strings.Split(tag, ",")
+	f := field{name: opts[0]}
+	for _, opt := range opts[1:] {
+		switch opt {
+		case "omitempty":
+			f.omitEmpty = true
+		case "alist":
+			f.style = MapStyleAlist
+			f.hasStyle = true
+		case "plist":
+			f.style = MapStylePlist
+			f.hasStyle = true
+		}
+	}
+	return f
+}
+
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package sexp
+
+import (
+	"math"
+	"strconv"
+)
+
+// floatFormat describes an IEEE 754 binary interchange format.
+type floatFormat struct {
+	bitSize      int
+	mantissaBits uint
+	exponentBits uint
+}
+
+var (
+	float32Format = floatFormat{bitSize: 32, mantissaBits: 23, exponentBits: 8}
+	float64Format = floatFormat{bitSize: 64, mantissaBits: 52, exponentBits: 11}
+)
+
+// Float32Bits returns the bit pattern of the literal as a float32, rounded to nearest
+// even as the WebAssembly text format requires.
+//
+// NaN literals get the canonical payload unless one is given with nan:0x...,
+// which must be non-zero and fit in the mantissa. nan:canonical and nan:arithmetic
+// also yield the canonical NaN; check NaN to tell them apart.
+// A finite literal that rounds to infinity is reported as strconv.ErrRange.
+func (n *Number) Float32Bits() (uint32, error) {
+	bits, err := n.floatBits(float32Format, "Float32Bits")
+	return uint32(bits), err
+}
+
+// Float64Bits is the float64 counterpart of Float32Bits.
+func (n *Number) Float64Bits() (uint64, error) {
+	return n.floatBits(float64Format, "Float64Bits")
+}
+
+func (n *Number) floatBits(f floatFormat, fn string) (uint64, error) {
+	var sign uint64
+	if n.Negative {
+		sign = 1 << uint(f.bitSize-1)
+	}
+	expMask := uint64(1)<<f.exponentBits - 1
+	inf := expMask << f.mantissaBits
+
+	switch n.Kind {
+	case NumberKindInf:
+		return sign | inf, nil
+	case NumberKindNaN:
+		payload := uint64(1) << (f.mantissaBits - 1)
+		if n.NaN == NaNKindPayload {
+			p, err := strconv.ParseUint(n.payload, 16, 64)
+			if err != nil || p == 0 || p >= 1<<f.mantissaBits {
+				return 0, n.numError(fn, strconv.ErrRange)
+			}
+			payload = p
+		}
+		return sign | inf | payload, nil
+	}
+
+	// ParseFloat rounds to the precision of bitSize, so v is exactly representable and
+	// narrowing it to float32 does not round a second time. A value that rounds past
+	// the largest finite value, including an exact tie with it, is out of range.
+	v, err := strconv.ParseFloat(n.floatString(), f.bitSize)
+	if err != nil {
+		return 0, n.numError(fn, err.(*strconv.NumError).Err)
+	}
+	if math.IsInf(v, 0) {
+		return 0, n.numError(fn, strconv.ErrRange)
+	}
+	if f.bitSize == 32 {
+		return uint64(math.Float32bits(float32(v))), nil
+	}
+	return math.Float64bits(v), nil
+}
+
+func (t *Token) Float32Bits() (uint32, error) {
+	n, err := t.Number()
+	if err != nil {
+		return 0, err
+	}
+	return n.Float32Bits()
+}
+
+func (t *Token) Float64Bits() (uint64, error) {
+	n, err := t.Number()
+	if err != nil {
+		return 0, err
+	}
+	return n.Float64Bits()
+}
@@ -0,0 +1,153 @@
+package sexp
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestNumberFloat32Bits(t *testing.T) {
+	testData := []struct {
+		Pattern       string
+		Expected      uint32
+		ExpectedError error
+	}{
+		{Pattern: "0", Expected: 0x00000000},
+		{Pattern: "-0", Expected: 0x80000000},
+		{Pattern: "-0.0", Expected: 0x80000000},
+		{Pattern: "-0x0p0", Expected: 0x80000000},
+		{Pattern: "1.1", Expected: 0x3f8ccccd},
+		{Pattern: "1_000", Expected: 0x447a0000},
+		{Pattern: "16777217", Expected: 0x4b800000},
+		{Pattern: "16777219", Expected: 0x4b800002},
+		{Pattern: "0x1p127", Expected: 0x7f000000},
+		{Pattern: "0x1.fffffep127", Expected: 0x7f7fffff},
+		{Pattern: "0x1.fffffefffffff8000000p127", Expected: 0x7f7fffff},
+		{Pattern: "0x1.fffffe8p127", Expected: 0x7f7fffff},
+		{Pattern: "0x1.fffffeffffffffffffffp127", Expected: 0x7f7fffff},
+		{Pattern: "340282356779733661637539395458142568447", Expected: 0x7f7fffff},
+		{Pattern: "0x1p-149", Expected: 0x00000001},
+		{Pattern: "0x1p-150", Expected: 0x00000000},
+		{Pattern: "0x1.000002p-150", Expected: 0x00000001},
+		{Pattern: "0x1.00000100000000000p-50", Expected: 0x26800000},
+		{Pattern: "0x1.00000100000000001p-50", Expected: 0x26800001},
+		{Pattern: "0x1.00000300000000000p-50", Expected: 0x26800002},
+		{Pattern: "inf", Expected: 0x7f800000},
+		{Pattern: "-inf", Expected: 0xff800000},
+		{Pattern: "nan", Expected: 0x7fc00000},
+		{Pattern: "-nan", Expected: 0xffc00000},
+		{Pattern: "nan:0x200000", Expected: 0x7fa00000},
+		{Pattern: "-nan:0x7f_ffff", Expected: 0xffffffff},
+		{Pattern: "nan:0x1", Expected: 0x7f800001},
+		{Pattern: "nan:canonical", Expected: 0x7fc00000},
+		{Pattern: "nan:arithmetic", Expected: 0x7fc00000},
+		{Pattern: "0x1.ffffffp127", ExpectedError: strconv.ErrRange},
+		{Pattern: "-0x1.ffffffp127", ExpectedError: strconv.ErrRange},
+		{Pattern: "0x1.ffffff0000001p127", ExpectedError: strconv.ErrRange},
+		{Pattern: "340282356779733661637539395458142568448", ExpectedError: strconv.ErrRange},
+		{Pattern: "1e39", ExpectedError: strconv.ErrRange},
+		{Pattern: "nan:0x0", ExpectedError: strconv.ErrRange},
+		{Pattern: "nan:0x800000", ExpectedError: strconv.ErrRange},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Pattern, func(t *testing.T) {
+			//t.Parallel()
+
+			n, err := ParseNumber(data.Pattern)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			a, err := n.Float32Bits()
+			if data.ExpectedError != nil {
+				checkNumError(t, data.ExpectedError, err)
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if data.Expected != a {
+				t.Fatalf("\nExpected: %#08x\nActual:   %#08x", data.Expected, a)
+			}
+		})
+	}
+}
+
+func TestNumberFloat64Bits(t *testing.T) {
+	testData := []struct {
+		Pattern       string
+		Expected      uint64
+		ExpectedError error
+	}{
+		{Pattern: "0", Expected: 0x0000000000000000},
+		{Pattern: "-0.0", Expected: 0x8000000000000000},
+		{Pattern: "1.1", Expected: 0x3ff199999999999a},
+		{Pattern: "0x1.fffffffffffffp1023", Expected: 0x7fefffffffffffff},
+		{Pattern: "0x1p-1074", Expected: 0x0000000000000001},
+		{Pattern: "0x1p-1075", Expected: 0x0000000000000000},
+		{Pattern: "0x1.00000000000008p0", Expected: 0x3ff0000000000000},
+		{Pattern: "0x1.00000000000018p0", Expected: 0x3ff0000000000002},
+		{Pattern: "1e-400", Expected: 0x0000000000000000},
+		{Pattern: "inf", Expected: 0x7ff0000000000000},
+		{Pattern: "-inf", Expected: 0xfff0000000000000},
+		{Pattern: "nan", Expected: 0x7ff8000000000000},
+		{Pattern: "nan:0x7fc00000", Expected: 0x7ff000007fc00000},
+		{Pattern: "-nan:0xf_ffff_ffff_ffff", Expected: 0xffffffffffffffff},
+		{Pattern: "nan:canonical", Expected: 0x7ff8000000000000},
+		{Pattern: "0x1.fffffffffffff8p1023", ExpectedError: strconv.ErrRange},
+		{Pattern: "nan:0x10000000000000", ExpectedError: strconv.ErrRange},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Pattern, func(t *testing.T) {
+			//t.Parallel()
+
+			n, err := ParseNumber(data.Pattern)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			a, err := n.Float64Bits()
+			if data.ExpectedError != nil {
+				checkNumError(t, data.ExpectedError, err)
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if data.Expected != a {
+				t.Fatalf("\nExpected: %#016x\nActual:   %#016x", data.Expected, a)
+			}
+		})
+	}
+}
+
+func TestNumberNaNKind(t *testing.T) {
+	testData := []struct {
+		Pattern  string
+		Expected NaNKind
+	}{
+		{Pattern: "nan", Expected: NaNKindDefault},
+		{Pattern: "-nan:0x1", Expected: NaNKindPayload},
+		{Pattern: "nan:canonical", Expected: NaNKindCanonical},
+		{Pattern: "nan:arithmetic", Expected: NaNKindArithmetic},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Pattern, func(t *testing.T) {
+			//t.Parallel()
+
+			n, err := ParseNumber(data.Pattern)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if n.Kind != NumberKindNaN {
+				t.Fatalf("expected NaN")
+			}
+			if data.Expected != n.NaN {
+				t.Fatalf("\nExpected: %d\nActual:   %d", data.Expected, n.NaN)
+			}
+		})
+	}
+}
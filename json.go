@@ -0,0 +1,350 @@
+package sexp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// JSONMapping selects how expressions are represented in JSON.
+type JSONMapping int
+
+const (
+	// JSONTagged represents atoms as objects tagged with their type and lists as
+	// arrays. It is lossless except for the spelling of escapes in strings.
+	//
+	//	(a "x" 0x10)  <->  [{"sym":"a"},{"str":"x"},{"num":"0x10"}]
+	//
+	// Strings that are not valid UTF-8 are represented as {"bytes":"<base64>"}.
+	JSONTagged JSONMapping = iota
+
+	// JSONNatural represents expressions with the closest JSON values: association
+	// lists and property lists as objects, numbers as numbers, true, false and nil as
+	// true, false and null, other symbols and strings as strings, and other lists as arrays.
+	//
+	//	((host "x") (port 0x1f90))  ->  {"host":"x","port":8080}
+	//
+	// Numbers that JSON cannot represent, such as inf, are written as strings.
+	// Objects are converted back to association lists.
+	JSONNatural
+)
+
+// ToJSON returns the JSON representation of s.
+func ToJSON(s *Sexp, m JSONMapping) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch m {
+	case JSONTagged:
+		err = writeTaggedJSON(&buf, s)
+	case JSONNatural:
+		err = writeNaturalJSON(&buf, s)
+	default:
+		err = fmt.Errorf("sexp: unknown JSON mapping %d", m)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// FromJSON converts the JSON representation of an expression back to an expression.
+func FromJSON(data []byte, m JSONMapping) (*Sexp, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+
+	var s *Sexp
+	var err error
+	switch m {
+	case JSONTagged:
+		s, err = readTaggedJSON(dec)
+	case JSONNatural:
+		s, err = readNaturalJSON(dec)
+	default:
+		err = fmt.Errorf("sexp: unknown JSON mapping %d", m)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = dec.Token()
+	if err != io.EOF {
+		return nil, fmt.Errorf("sexp: invalid JSON: data after the top-level value")
+	}
+	return s, nil
+}
+
+func writeJSONString(buf *bytes.Buffer, s string) {
+	b, _ := json.Marshal(s) // never fails for a string
+	buf.Write(b)
+}
+
+func writeTaggedJSON(buf *bytes.Buffer, s *Sexp) error {
+	if s.IsList() {
+		buf.WriteByte('[')
+		for i, c := range s.Children {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			err := writeTaggedJSON(buf, c)
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+		return nil
+	}
+
+	tag, value := "", s.Atom.Value
+	switch s.Atom.Type {
+	case TokenTypeSymbol:
+		tag = "sym"
+	case TokenTypeNumber:
+		tag = "num"
+	case TokenTypeString:
+		b, err := s.Atom.Bytes()
+		if err != nil {
+			return err
+		}
+		tag, value = "str", string(b)
+		if !utf8.Valid(b) {
+			tag, value = "bytes", base64.StdEncoding.EncodeToString(b)
+		}
+	}
+	buf.WriteString(`{"` + tag + `":`)
+	writeJSONString(buf, value)
+	buf.WriteByte('}')
+	return nil
+}
+
+func writeNaturalJSON(buf *bytes.Buffer, s *Sexp) error {
+	switch {
+	case s.IsList():
+		if keys, values, ok := objectEntries(s); ok {
+			buf.WriteByte('{')
+			for i, key := range keys {
+				if i > 0 {
+					buf.WriteByte(',')
+				}
+				writeJSONString(buf, key)
+				buf.WriteByte(':')
+				err := writeNaturalJSON(buf, values[i])
+				if err != nil {
+					return err
+				}
+			}
+			buf.WriteByte('}')
+			return nil
+		}
+
+		buf.WriteByte('[')
+		for i, c := range s.Children {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			err := writeNaturalJSON(buf, c)
+			if err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case s.IsNumber():
+		n, err := s.Atom.Number()
+		if err != nil {
+			return err
+		}
+		buf.WriteString(jsonNumber(n))
+	case s.IsString():
+		b, err := s.Atom.Bytes()
+		if err != nil {
+			return err
+		}
+		writeJSONString(buf, string(b))
+	case s.IsSymbol("true", "false"):
+		buf.WriteString(s.Atom.Value)
+	case isNil(s):
+		buf.WriteString("null")
+	default:
+		writeJSONString(buf, s.Atom.Value)
+	}
+	return nil
+}
+
+// jsonNumber returns n as a JSON number, or as a JSON string if it has no JSON representation.
+func jsonNumber(n *Number) string {
+	if i, err := n.Int64(); err == nil {
+		return fmt.Sprint(i)
+	}
+	if u, err := n.Uint64(); err == nil {
+		return fmt.Sprint(u)
+	}
+	if n.Kind == NumberKindFloat {
+		if f, err := n.Float64(); err == nil {
+			return formatFloat(f, 64)
+		}
+	}
+	b, _ := json.Marshal(n.Text)
+	return string(b)
+}
+
+// objectEntries returns the keys and values of a non-empty association list or
+// property list, or false if s is neither.
+func objectEntries(s *Sexp) ([]string, []*Sexp, bool) {
+	if s.Len() == 0 {
+		return nil, nil, false
+	}
+
+	keys := []string{}
+	values := []*Sexp{}
+	if isKeyword(s.Head()) {
+		if s.Len()%2 != 0 {
+			return nil, nil, false
+		}
+		for i := 0; i < s.Len(); i += 2 {
+			if !isKeyword(s.Children[i]) {
+				return nil, nil, false
+			}
+			keys = append(keys, strings.TrimPrefix(s.Children[i].Atom.Value, ":"))
+			values = append(values, s.Children[i+1])
+		}
+		return keys, values, true
+	}
+
+	for _, c := range s.Children {
+		if c.Len() != 2 {
+			return nil, nil, false
+		}
+		key, ok := keyName(c.Head())
+		if !ok || isKeyword(c.Head()) {
+			return nil, nil, false
+		}
+		keys = append(keys, key)
+		values = append(values, c.Children[1])
+	}
+	return keys, values, true
+}
+
+func readTaggedJSON(dec *json.Decoder) (*Sexp, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return nil, jsonError(err)
+	}
+
+	switch t {
+	case json.Delim('['):
+		s := newList()
+		for dec.More() {
+			c, err := readTaggedJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			s.Children = append(s.Children, c)
+		}
+		_, err = dec.Token()
+		if err != nil {
+			return nil, jsonError(err)
+		}
+		return s, nil
+	case json.Delim('{'):
+	default:
+		return nil, fmt.Errorf("sexp: invalid tagged JSON: expected an array or an object, but found %v", t)
+	}
+
+	t, err = dec.Token()
+	if err != nil {
+		return nil, jsonError(err)
+	}
+	tag, _ := t.(string)
+	t, err = dec.Token()
+	if err != nil {
+		return nil, jsonError(err)
+	}
+	value, ok := t.(string)
+	if !ok {
+		return nil, fmt.Errorf("sexp: invalid tagged JSON: expected a string value for %q", tag)
+	}
+	t, err = dec.Token()
+	if err != nil {
+		return nil, jsonError(err)
+	}
+	if t != json.Delim('}') {
+		return nil, fmt.Errorf("sexp: invalid tagged JSON: expected an object with a single key")
+	}
+
+	switch tag {
+	case "sym":
+		if !isSymbolText(value) {
+			return nil, fmt.Errorf("sexp: invalid tagged JSON: %q is not a symbol", value)
+		}
+		return symbolAtom(value), nil
+	case "num":
+		if !isNumber(value) {
+			return nil, fmt.Errorf("sexp: invalid tagged JSON: %q is not a number", value)
+		}
+		return numberAtom(value), nil
+	case "str":
+		return stringAtom([]byte(value)), nil
+	case "bytes":
+		b, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("sexp: invalid tagged JSON: %v", err)
+		}
+		return stringAtom(b), nil
+	}
+	return nil, fmt.Errorf("sexp: invalid tagged JSON: unknown tag %q", tag)
+}
+
+func readNaturalJSON(dec *json.Decoder) (*Sexp, error) {
+	t, err := dec.Token()
+	if err != nil {
+		return nil, jsonError(err)
+	}
+
+	switch t := t.(type) {
+	case json.Delim:
+		s := newList()
+		for dec.More() {
+			var key *Sexp
+			if t == json.Delim('{') {
+				k, err := dec.Token()
+				if err != nil {
+					return nil, jsonError(err)
+				}
+				key = keyAtom(k.(string))
+			}
+			c, err := readNaturalJSON(dec)
+			if err != nil {
+				return nil, err
+			}
+			if key != nil {
+				c = newList(key, c)
+			}
+			s.Children = append(s.Children, c)
+		}
+		_, err = dec.Token()
+		if err != nil {
+			return nil, jsonError(err)
+		}
+		return s, nil
+	case json.Number:
+		if !isNumber(t.String()) {
+			return nil, fmt.Errorf("sexp: invalid JSON number %s", t)
+		}
+		return numberAtom(t.String()), nil
+	case string:
+		return stringAtom([]byte(t)), nil
+	case bool:
+		return symbolAtom(fmt.Sprint(t)), nil
+	}
+	return symbolAtom("nil"), nil
+}
+
+func jsonError(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return fmt.Errorf("sexp: invalid JSON: %v", err)
+}
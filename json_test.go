@@ -0,0 +1,183 @@
+package sexp
+
+import (
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestToJSON(t *testing.T) {
+	testData := []struct {
+		Name     string
+		Pattern  string
+		Mapping  JSONMapping
+		Expected string
+	}{
+		{
+			Name:     "pattern 1 - tagged atoms",
+			Pattern:  `(a "x\n" 0x10 ())`,
+			Mapping:  JSONTagged,
+			Expected: `[{"sym":"a"},{"str":"x\n"},{"num":"0x10"},[]]`,
+		},
+		{
+			Name:     "pattern 2 - tagged bytes",
+			Pattern:  `"\00asm\ff"`,
+			Mapping:  JSONTagged,
+			Expected: `{"bytes":"AGFzbf8="}`,
+		},
+		{
+			Name:     "pattern 3 - natural alist",
+			Pattern:  `((host "x") (port 0x1f90) (tls true) (cert nil) (tags (a "b")))`,
+			Mapping:  JSONNatural,
+			Expected: `{"host":"x","port":8080,"tls":true,"cert":null,"tags":["a","b"]}`,
+		},
+		{
+			Name:     "pattern 4 - natural plist",
+			Pattern:  `(:b 1.5e3 :a (:c -inf))`,
+			Mapping:  JSONNatural,
+			Expected: `{"b":1500,"a":{"c":"-inf"}}`,
+		},
+		{
+			Name:     "pattern 5 - natural list",
+			Pattern:  `(module (func $f) 18446744073709551615 nan:0x1)`,
+			Mapping:  JSONNatural,
+			Expected: `["module",["func","$f"],18446744073709551615,"nan:0x1"]`,
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			b, err := ToJSON(MustParse(data.Pattern), data.Mapping)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if data.Expected != string(b) {
+				t.Fatalf("\n%s", pretty.Compare(data.Expected, string(b)))
+			}
+		})
+	}
+}
+
+func TestFromJSON(t *testing.T) {
+	testData := []struct {
+		Name     string
+		Pattern  string
+		Mapping  JSONMapping
+		Expected string
+	}{
+		{
+			Name:     "pattern 1 - tagged",
+			Pattern:  `[{"sym":"a"}, {"str":"x\n\"y"}, {"num":"0x10"}, [], {"bytes":"AGFzbf8="}]`,
+			Mapping:  JSONTagged,
+			Expected: `(a "x\n\"y" 0x10 () "\00asm\ff")`,
+		},
+		{
+			Name:     "pattern 2 - natural",
+			Pattern:  `{"host": "x", "port": 8080, "a b": [true, null, 1.5e3], "": {}}`,
+			Mapping:  JSONNatural,
+			Expected: `((host "x") (port 8080) ("a b" (true nil 1.5e3)) ("" ()))`,
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			s, err := FromJSON([]byte(data.Pattern), data.Mapping)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			a := s.String()
+			if data.Expected != a {
+				t.Fatalf("\n%s", pretty.Compare(data.Expected, a))
+			}
+		})
+	}
+}
+
+func TestJSONTaggedRoundTrip(t *testing.T) {
+	src := `(module (func $f (param i32) (result f32) f32.const -0x1.8p3 nan:canonical) (data "\00\ff\u{1F600}") a;b c;)`
+	b, err := ToJSON(MustParse(src), JSONTagged)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	s, err := FromJSON(b, JSONTagged)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	expected := `(module (func $f (param i32) (result f32) f32.const -0x1.8p3 nan:canonical) (data "\00\ff😀") a;b c;)`
+	if expected != s.String() {
+		t.Fatalf("\n%s", pretty.Compare(expected, s.String()))
+	}
+}
+
+func TestFromJSONError(t *testing.T) {
+	testData := []struct {
+		Name     string
+		Pattern  string
+		Mapping  JSONMapping
+		Expected string
+	}{
+		{
+			Name:     "pattern 1 - unknown tag",
+			Pattern:  `[{"foo":"a"}]`,
+			Mapping:  JSONTagged,
+			Expected: `sexp: invalid tagged JSON: unknown tag "foo"`,
+		},
+		{
+			Name:     "pattern 2 - invalid symbol",
+			Pattern:  `{"sym":"a b"}`,
+			Mapping:  JSONTagged,
+			Expected: `sexp: invalid tagged JSON: "a b" is not a symbol`,
+		},
+		{
+			Name:     "pattern 3 - symbol with a comment",
+			Pattern:  `{"sym":"a;;b"}`,
+			Mapping:  JSONTagged,
+			Expected: `sexp: invalid tagged JSON: "a;;b" is not a symbol`,
+		},
+		{
+			Name:     "pattern 4 - invalid number",
+			Pattern:  `{"num":"1x"}`,
+			Mapping:  JSONTagged,
+			Expected: `sexp: invalid tagged JSON: "1x" is not a number`,
+		},
+		{
+			Name:     "pattern 5 - scalar",
+			Pattern:  `1`,
+			Mapping:  JSONTagged,
+			Expected: `sexp: invalid tagged JSON: expected an array or an object, but found 1`,
+		},
+		{
+			Name:     "pattern 6 - truncated",
+			Pattern:  `[1, 2`,
+			Mapping:  JSONNatural,
+			Expected: `sexp: invalid JSON: unexpected end of JSON input`,
+		},
+		{
+			Name:     "pattern 7 - trailing data",
+			Pattern:  `[] []`,
+			Mapping:  JSONNatural,
+			Expected: `sexp: invalid JSON: data after the top-level value`,
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			_, err := FromJSON([]byte(data.Pattern), data.Mapping)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if data.Expected != err.Error() {
+				t.Fatalf("\nExpected: %s\nActual:   %s", data.Expected, err.Error())
+			}
+		})
+	}
+}
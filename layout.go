@@ -0,0 +1,272 @@
+package sexp
+
+import (
+	"bufio"
+	"strings"
+	"unicode/utf8"
+)
+
+// This file implements a small document algebra in the style of Wadler's
+// "A prettier printer": a document is laid out on a single line if it fits in
+// the remaining width, and broken at its line breaks otherwise.
+
+type doc interface{}
+
+// docText is text. Line breaks in text are written as they are, without indentation,
+// and prevent enclosing groups from being flat.
+type docText string
+
+// docLine is a line break, or a space when its group is laid out flat.
+// A hard line is always a line break and prevents enclosing groups from being flat.
+type docLine struct {
+	hard bool
+}
+
+type docConcat []doc
+
+// docNest increases the indentation of the line breaks in its content.
+type docNest struct {
+	indent  int
+	content doc
+}
+
+// docAlign sets the indentation of the line breaks in its content to the current column.
+type docAlign struct {
+	content doc
+}
+
+// docGroup lays out its content flat if it fits, and broken otherwise.
+type docGroup struct {
+	content doc
+	broken  bool // the content contains a hard line
+}
+
+// docFill is a sequence of contents separated by lines. Unlike a group, each
+// separator is broken only if the next content does not fit on the current line.
+type docFill []doc
+
+// docBreakParent writes nothing but prevents enclosing groups from being flat.
+type docBreakParent struct{}
+
+var (
+	softLine    = docLine{}
+	hardLine    = docLine{hard: true}
+	breakParent = docBreakParent{}
+)
+
+func group(d doc) doc {
+	return &docGroup{content: d, broken: hasHardLine(d)}
+}
+
+// hasHardLine reports whether d contains a hard line, without looking into nested groups.
+func hasHardLine(d doc) bool {
+	switch d := d.(type) {
+	case docText:
+		return strings.Contains(string(d), "\n")
+	case docLine:
+		return d.hard
+	case docBreakParent:
+		return true
+	case docConcat:
+		for _, c := range d {
+			if hasHardLine(c) {
+				return true
+			}
+		}
+	case docFill:
+		for _, c := range d {
+			if hasHardLine(c) {
+				return true
+			}
+		}
+	case *docNest:
+		return hasHardLine(d.content)
+	case *docAlign:
+		return hasHardLine(d.content)
+	case *docGroup:
+		return d.broken
+	}
+	return false
+}
+
+type layoutMode int
+
+const (
+	layoutBreak layoutMode = iota
+	layoutFlat
+)
+
+type layoutCommand struct {
+	indent int
+	mode   layoutMode
+	doc    doc
+}
+
+type layoutWriter struct {
+	bw      *bufio.Writer
+	width   int
+	column  int
+	pending int // indentation not written yet, so that empty lines have no trailing spaces
+	stack   []layoutCommand
+}
+
+// render lays out d within width columns and writes it to bw.
+func render(bw *bufio.Writer, width int, d doc) error {
+	lw := &layoutWriter{
+		bw:    bw,
+		width: width,
+		stack: []layoutCommand{{indent: 0, mode: layoutBreak, doc: d}},
+	}
+	for len(lw.stack) > 0 {
+		last := len(lw.stack) - 1
+		cmd := lw.stack[last]
+		lw.stack = lw.stack[:last]
+
+		err := lw.step(cmd)
+		if err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func (lw *layoutWriter) push(indent int, mode layoutMode, d doc) {
+	lw.stack = append(lw.stack, layoutCommand{indent: indent, mode: mode, doc: d})
+}
+
+func (lw *layoutWriter) step(cmd layoutCommand) error {
+	switch d := cmd.doc.(type) {
+	case docText:
+		return lw.write(string(d))
+	case docLine:
+		if cmd.mode == layoutFlat && !d.hard {
+			return lw.write(" ")
+		}
+		_, err := lw.bw.WriteString("\n")
+		lw.column = cmd.indent
+		lw.pending = cmd.indent
+		return err
+	case docConcat:
+		for i := len(d) - 1; i >= 0; i-- {
+			lw.push(cmd.indent, cmd.mode, d[i])
+		}
+	case *docNest:
+		lw.push(cmd.indent+d.indent, cmd.mode, d.content)
+	case *docAlign:
+		lw.push(lw.column, cmd.mode, d.content)
+	case *docGroup:
+		mode := cmd.mode
+		if mode == layoutBreak && !d.broken && lw.fits(layoutCommand{indent: cmd.indent, mode: layoutFlat, doc: d.content}) {
+			mode = layoutFlat
+		}
+		lw.push(cmd.indent, mode, d.content)
+	case docFill:
+		lw.stepFill(cmd, d)
+	}
+	return nil
+}
+
+func (lw *layoutWriter) write(s string) error {
+	if s == "" {
+		return nil
+	}
+	if lw.pending > 0 {
+		_, err := lw.bw.WriteString(strings.Repeat(" ", lw.pending))
+		if err != nil {
+			return err
+		}
+		lw.pending = 0
+	}
+	_, err := lw.bw.WriteString(s)
+	if i := strings.LastIndexByte(s, '\n'); i >= 0 {
+		lw.column = utf8.RuneCountInString(s[i+1:])
+	} else {
+		lw.column += utf8.RuneCountInString(s)
+	}
+	return err
+}
+
+// stepFill lays out the first content of a fill and the separator after it,
+// and pushes the rest of the fill back.
+func (lw *layoutWriter) stepFill(cmd layoutCommand, d docFill) {
+	if len(d) == 0 {
+		return
+	}
+
+	content := d[0]
+	contentMode := layoutBreak
+	if lw.fits(layoutCommand{indent: cmd.indent, mode: layoutFlat, doc: content}) {
+		contentMode = layoutFlat
+	}
+	if len(d) == 1 {
+		lw.push(cmd.indent, contentMode, content)
+		return
+	}
+
+	separator := d[1]
+	rest := d[2:]
+	separatorMode := layoutBreak
+	if len(rest) > 0 && lw.fits(layoutCommand{indent: cmd.indent, mode: layoutFlat, doc: docConcat{content, separator, rest[0]}}) {
+		separatorMode = layoutFlat
+	}
+	lw.push(cmd.indent, cmd.mode, rest)
+	lw.push(cmd.indent, separatorMode, separator)
+	lw.push(cmd.indent, contentMode, content)
+}
+
+// fits reports whether next followed by the rest of the stack fits in the
+// remaining width up to the next line break.
+func (lw *layoutWriter) fits(next layoutCommand) bool {
+	remaining := lw.width - lw.column
+	cmds := []layoutCommand{next}
+	restIndex := len(lw.stack)
+	for remaining >= 0 {
+		if len(cmds) == 0 {
+			if restIndex == 0 {
+				return true
+			}
+			restIndex--
+			cmds = append(cmds, lw.stack[restIndex])
+			continue
+		}
+
+		last := len(cmds) - 1
+		cmd := cmds[last]
+		cmds = cmds[:last]
+
+		switch d := cmd.doc.(type) {
+		case docText:
+			if i := strings.IndexByte(string(d), '\n'); i >= 0 {
+				return remaining >= utf8.RuneCountInString(string(d)[:i])
+			}
+			remaining -= utf8.RuneCountInString(string(d))
+		case docLine:
+			if cmd.mode == layoutBreak || d.hard {
+				return true
+			}
+			remaining--
+		case docConcat:
+			for i := len(d) - 1; i >= 0; i-- {
+				cmds = append(cmds, layoutCommand{indent: cmd.indent, mode: cmd.mode, doc: d[i]})
+			}
+		case docFill:
+			for i := len(d) - 1; i >= 0; i-- {
+				cmds = append(cmds, layoutCommand{indent: cmd.indent, mode: cmd.mode, doc: d[i]})
+			}
+		case *docNest:
+			cmds = append(cmds, layoutCommand{indent: cmd.indent, mode: cmd.mode, doc: d.content})
+		case *docAlign:
+			cmds = append(cmds, layoutCommand{indent: cmd.indent, mode: cmd.mode, doc: d.content})
+		case *docGroup:
+			if d.broken && cmd.mode == layoutFlat {
+				return false
+			}
+			mode := cmd.mode
+			if d.broken {
+				mode = layoutBreak
+			}
+			cmds = append(cmds, layoutCommand{indent: cmd.indent, mode: mode, doc: d.content})
+		}
+	}
+	return false
+}
@@ -4,21 +4,84 @@ import (
 	"bufio"
 	"errors"
 	"io"
+	"strings"
 	"unicode"
 )
 
 type Lexer struct {
-	br      *bufio.Reader
-	history []*Token
-	unread  []*Token
+	br           *posReader
+	history      []*Token
+	unread       []*Token
+	err          error
+	dialect      Dialect
+	keepComments bool
 }
 
-func NewLexer(r io.Reader) *Lexer {
-	return &Lexer{
-		br:      bufio.NewReader(r),
+// LexerOption configures a Lexer.
+type LexerOption func(lex *Lexer)
+
+// WithDialect sets the comment syntax recognized by the Lexer. The default is DialectWAT.
+func WithDialect(d Dialect) LexerOption {
+	return func(lex *Lexer) {
+		lex.dialect = d
+	}
+}
+
+// KeepComments makes the Lexer emit comments as TokenTypeComment tokens instead of skipping them.
+func KeepComments() LexerOption {
+	return func(lex *Lexer) {
+		lex.keepComments = true
+	}
+}
+
+func NewLexer(r io.Reader, opts ...LexerOption) *Lexer {
+	lex := &Lexer{
+		br:      newPosReader(bufio.NewReader(r)),
 		history: []*Token{},
 		unread:  []*Token{},
+		dialect: DialectWAT,
+	}
+	for _, opt := range opts {
+		opt(lex)
+	}
+	return lex
+}
+
+// Err returns the first error other than io.EOF encountered by the Lexer.
+// NextToken returns nil both at the end of input and on error; Err tells them apart.
+func (lex *Lexer) Err() error {
+	return lex.err
+}
+
+func (lex *Lexer) setErr(err error) {
+	if lex.err == nil {
+		lex.err = err
+	}
+}
+
+func (lex *Lexer) syntaxError(pos Position, msg string) *SyntaxError {
+	e := &SyntaxError{
+		Pos: pos,
+		Msg: msg,
 	}
+	if pos.Line == lex.br.pos.Line {
+		e.Excerpt = lex.br.lineExcerpt(pos.Column)
+	}
+	return e
+}
+
+func (lex *Lexer) unexpectedToken(token *Token, expected string) *SyntaxError {
+	e := lex.syntaxError(token.Start, "")
+	e.Expected = expected
+	e.Found = describeToken(token)
+	return e
+}
+
+func (lex *Lexer) unexpectedEOF(expected string) *SyntaxError {
+	e := lex.syntaxError(lex.br.pos, "")
+	e.Expected = expected
+	e.Found = describeToken(nil)
+	return e
 }
 
 func (lex *Lexer) Unread() error {
@@ -32,6 +95,11 @@ func (lex *Lexer) Unread() error {
 	return nil
 }
 
+// forget drops the tokens kept for Unread.
+func (lex *Lexer) forget() {
+	lex.history = []*Token{}
+}
+
 func (lex *Lexer) Peek() *Token {
 	token := lex.NextToken()
 	if token == nil {
@@ -55,11 +123,43 @@ func (lex *Lexer) NextToken() *Token {
 		return token
 	}
 
+	if lex.err != nil {
+		return nil
+	}
+
+	token := lex.scan()
+	if token == nil {
+		return nil
+	}
+	lex.history = append(lex.history, token)
+	return token
+}
+
+// scan reads the next token from the input, skipping white space and,
+// unless they are kept, comments.
+func (lex *Lexer) scan() *Token {
 	var token *Token
+	var start Position
 loop:
 	for {
+		start = lex.br.pos
+		comment, ok := lex.scanComment()
+		if lex.err != nil {
+			return nil
+		}
+		if ok {
+			if !lex.keepComments {
+				continue
+			}
+			token = comment
+			break loop
+		}
+
 		r, _, err := lex.br.ReadRune()
 		if err != nil {
+			if err != io.EOF {
+				lex.setErr(err)
+			}
 			return nil
 		}
 
@@ -80,27 +180,19 @@ loop:
 				Value: string([]rune{r}),
 			}
 			break loop
-		case isNumberStartRune(r):
-			err = lex.br.UnreadRune()
-			if err != nil {
-				return nil
-			}
-			s, err := readNumber(lex.br)
-			if err != nil {
-				return nil
-			}
-			token = &Token{
-				Type:  TokenTypeNumber,
-				Value: s,
-			}
-			break loop
 		case r == '"':
 			err = lex.br.UnreadRune()
 			if err != nil {
+				lex.setErr(err)
 				return nil
 			}
 			s, err := readString(lex.br)
+			if err == io.ErrUnexpectedEOF {
+				lex.setErr(lex.syntaxError(start, "string literal not terminated"))
+				return nil
+			}
 			if err != nil {
+				lex.setErr(err)
 				return nil
 			}
 			token = &Token{
@@ -111,49 +203,114 @@ loop:
 		case isSymbolRune(r):
 			err = lex.br.UnreadRune()
 			if err != nil {
+				lex.setErr(err)
 				return nil
 			}
-			s, err := readSymbol(lex.br)
+			s, err := readSymbol(lex.br, lex.dialect)
 			if err != nil {
+				lex.setErr(err)
 				return nil
 			}
+			typ := TokenTypeSymbol
+			if isNumber(s) {
+				typ = TokenTypeNumber
+			}
 			token = &Token{
-				Type:  TokenTypeSymbol,
+				Type:  typ,
 				Value: s,
 			}
 			break loop
 		}
 	}
-	lex.history = append(lex.history, token)
+	token.Start = start
+	token.End = lex.br.pos
 	return token
 }
 
-func isSymbolRune(r rune) bool {
-	if r == '(' || r == ')' {
-		return false
+// commentFollows reports whether a comment starts on the current line, after white
+// space, in the input buffered so far.
+func (lex *Lexer) commentFollows() bool {
+	rest := strings.TrimLeft(lex.br.restOfLine(), " \t\r")
+	d := lex.dialect
+	for _, prefix := range []string{d.LineComment, d.BlockCommentStart, d.DatumComment} {
+		if prefix != "" && strings.HasPrefix(rest, prefix) {
+			return true
+		}
 	}
-	if r == '"' {
-		return false
+	return false
+}
+
+// scanComment reads a comment if the input starts with one.
+func (lex *Lexer) scanComment() (*Token, bool) {
+	d := lex.dialect
+	start := lex.br.pos
+
+	var s string
+	var err error
+	switch {
+	case d.BlockCommentStart != "" && lex.br.hasPrefix(d.BlockCommentStart):
+		s, err = readBlockComment(lex.br, d)
+		if err == io.ErrUnexpectedEOF {
+			lex.setErr(lex.syntaxError(start, "block comment not terminated"))
+			return nil, false
+		}
+	case d.DatumComment != "" && lex.br.hasPrefix(d.DatumComment):
+		s, err = lex.readDatumComment()
+	case d.LineComment != "" && lex.br.hasPrefix(d.LineComment):
+		s, err = readLineComment(lex.br)
+	default:
+		return nil, false
 	}
-	if unicode.IsSpace(r) {
-		return false
+	if err != nil {
+		lex.setErr(err)
+		return nil, false
 	}
-	return true
+
+	return &Token{
+		Type:  TokenTypeComment,
+		Value: s,
+	}, true
 }
 
-func isNumberStartRune(r rune) bool {
-	if unicode.IsNumber(r) {
-		return true
+// readDatumComment reads a datum comment prefix and the expression that follows it.
+func (lex *Lexer) readDatumComment() (string, error) {
+	from := lex.br.startRecording()
+	_, err := lex.br.consume(len(lex.dialect.DatumComment))
+	if err != nil {
+		lex.br.stopRecording(from)
+		return "", err
 	}
 
-	if r == '-' || r == '+' {
-		return true
-	}
+	depth := 0
+	for {
+		token := lex.scan()
+		if token == nil {
+			lex.br.stopRecording(from)
+			if lex.err != nil {
+				return "", lex.err
+			}
+			return "", lex.unexpectedEOF("expression")
+		}
 
-	return false
+		switch token.Type {
+		case TokenTypeComment:
+			continue
+		case TokenTypeOpenParen:
+			depth++
+		case TokenTypeCloseParen:
+			if depth == 0 {
+				lex.br.stopRecording(from)
+				return "", lex.unexpectedToken(token, "expression")
+			}
+			depth--
+		}
+		if depth == 0 {
+			return lex.br.stopRecording(from), nil
+		}
+	}
 }
 
-func isNumberRune(r rune) bool {
+func isSymbolRune(r rune) bool {
 	if r == '(' || r == ')' {
 		return false
 	}
@@ -163,13 +320,16 @@ func isNumberRune(r rune) bool {
 	if unicode.IsSpace(r) {
 		return false
 	}
-
 	return true
 }
 
-func readSymbol(br *bufio.Reader) (string, error) {
-	buf := []rune{}
+// readSymbol reads a symbol, which ends before a line comment of the dialect d.
+func readSymbol(br *posReader, d Dialect) (string, error) {
+	buf := []byte{}
 	for {
+		if len(buf) > 0 && d.LineComment != "" && br.hasPrefix(d.LineComment) {
+			break
+		}
 		r, _, err := br.ReadRune()
 		if err != nil {
 			if err == io.EOF {
@@ -184,45 +344,59 @@ func readSymbol(br *bufio.Reader) (string, error) {
 			}
 			break
 		}
-		buf = append(buf, r)
+		buf = append(buf, br.last...)
 	}
 	return string(buf), nil
 }
 
-func readNumber(br *bufio.Reader) (string, error) {
-	buf := []rune{}
-	for {
+// string scanner states
+const (
+	stringStateStart = iota
+	stringStateBody
+	stringStateEscape
+	stringStateEnd
+)
+
+// readString reads a string literal including the surrounding quotes.
+// Escape sequences are kept as they are; only the escaped rune is skipped so that
+// an escaped quote or backslash never terminates the literal.
+// The bytes of the literal are kept exactly, even where they are not valid UTF-8.
+// It returns io.ErrUnexpectedEOF if the input ends inside the literal.
+func readString(br *posReader) (string, error) {
+	buf := []byte{}
+	state := stringStateStart
+	for state != stringStateEnd {
 		r, _, err := br.ReadRune()
 		if err != nil {
-			if err == io.EOF {
-				break
+			if err == io.EOF && state != stringStateStart {
+				return "", io.ErrUnexpectedEOF
 			}
 			return "", err
 		}
-		if !isNumberRune(r) {
-			err = br.UnreadRune()
-			if err != nil {
-				return "", err
+
+		switch state {
+		case stringStateStart:
+			if r != '"' {
+				return "", errors.New(`string should start with "`)
 			}
-			break
+			state = stringStateBody
+		case stringStateBody:
+			switch r {
+			case '\\':
+				state = stringStateEscape
+			case '"':
+				state = stringStateEnd
+			}
+		case stringStateEscape:
+			state = stringStateBody
 		}
-
-		buf = append(buf, r)
+		buf = append(buf, br.last...)
 	}
 	return string(buf), nil
 }
 
-func readString(br *bufio.Reader) (string, error) {
-	buf := []rune{}
-	pr, _, err := br.ReadRune()
-	if err != nil {
-		return "", err
-	}
-	if pr != '"' {
-		return "", errors.New(`string should start with "`)
-	}
-	buf = append(buf, pr)
-
+func readLineComment(br *posReader) (string, error) {
+	buf := []byte{}
 	for {
 		r, _, err := br.ReadRune()
 		if err != nil {
@@ -231,13 +405,49 @@ func readString(br *bufio.Reader) (string, error) {
 			}
 			return "", err
 		}
-		buf = append(buf, r)
-
-		if r == '"' && pr != '\\' {
+		if r == '\n' {
+			err = br.UnreadRune()
+			if err != nil {
+				return "", err
+			}
 			break
 		}
-
-		pr = r
+		buf = append(buf, br.last...)
 	}
 	return string(buf), nil
 }
+
+func readBlockComment(br *posReader, d Dialect) (string, error) {
+	buf := []byte{}
+	depth := 0
+	for {
+		switch {
+		case br.hasPrefix(d.BlockCommentStart) && (depth == 0 || d.NestedBlockComments):
+			s, err := br.consume(len(d.BlockCommentStart))
+			if err != nil {
+				return "", err
+			}
+			buf = append(buf, s...)
+			depth++
+		case br.hasPrefix(d.BlockCommentEnd):
+			s, err := br.consume(len(d.BlockCommentEnd))
+			if err != nil {
+				return "", err
+			}
+			buf = append(buf, s...)
+			depth--
+			if depth == 0 {
+				return string(buf), nil
+			}
+		default:
+			_, _, err := br.ReadRune()
+			if err != nil {
+				if err == io.EOF {
+					return "", io.ErrUnexpectedEOF
+				}
+				return "", err
+			}
+			buf = append(buf, br.last...)
+		}
+	}
+}
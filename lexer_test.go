@@ -2,9 +2,12 @@ package sexp
 
 import (
 	"bufio"
+	"io"
 	"reflect"
 	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/kylelemons/godebug/pretty"
 )
@@ -88,6 +91,7 @@ func TestNextToken(t *testing.T) {
 				}
 				as = append(as, a)
 			}
+			as = stripTokenPositions(as)
 			if !reflect.DeepEqual(data.Expected, as) {
 				t.Fatalf("%s", pretty.Compare(data.Expected, as))
 			}
@@ -95,6 +99,369 @@ func TestNextToken(t *testing.T) {
 	}
 }
 
+func TestNextTokenPosition(t *testing.T) {
+	testData := []struct {
+		Name     string
+		Pattern  string
+		Expected []*Token
+	}{
+		{
+			Name:    "pattern 1 - single line",
+			Pattern: `(a "bc" 12)`,
+			Expected: []*Token{
+				{Type: TokenTypeOpenParen, Value: "(", Start: Position{0, 1, 1}, End: Position{1, 1, 2}},
+				{Type: TokenTypeSymbol, Value: "a", Start: Position{1, 1, 2}, End: Position{2, 1, 3}},
+				{Type: TokenTypeString, Value: `"bc"`, Start: Position{3, 1, 4}, End: Position{7, 1, 8}},
+				{Type: TokenTypeNumber, Value: "12", Start: Position{8, 1, 9}, End: Position{10, 1, 11}},
+				{Type: TokenTypeCloseParen, Value: ")", Start: Position{10, 1, 11}, End: Position{11, 1, 12}},
+			},
+		},
+		{
+			Name:    "pattern 2 - multiple lines",
+			Pattern: "(module\n  (func $f))\n",
+			Expected: []*Token{
+				{Type: TokenTypeOpenParen, Value: "(", Start: Position{0, 1, 1}, End: Position{1, 1, 2}},
+				{Type: TokenTypeSymbol, Value: "module", Start: Position{1, 1, 2}, End: Position{7, 1, 8}},
+				{Type: TokenTypeOpenParen, Value: "(", Start: Position{10, 2, 3}, End: Position{11, 2, 4}},
+				{Type: TokenTypeSymbol, Value: "func", Start: Position{11, 2, 4}, End: Position{15, 2, 8}},
+				{Type: TokenTypeSymbol, Value: "$f", Start: Position{16, 2, 9}, End: Position{18, 2, 11}},
+				{Type: TokenTypeCloseParen, Value: ")", Start: Position{18, 2, 11}, End: Position{19, 2, 12}},
+				{Type: TokenTypeCloseParen, Value: ")", Start: Position{19, 2, 12}, End: Position{20, 2, 13}},
+			},
+		},
+		{
+			Name:    "pattern 3 - multibyte",
+			Pattern: `("日本" 語)`,
+			Expected: []*Token{
+				{Type: TokenTypeOpenParen, Value: "(", Start: Position{0, 1, 1}, End: Position{1, 1, 2}},
+				{Type: TokenTypeString, Value: `"日本"`, Start: Position{1, 1, 2}, End: Position{9, 1, 6}},
+				{Type: TokenTypeSymbol, Value: "語", Start: Position{10, 1, 7}, End: Position{13, 1, 8}},
+				{Type: TokenTypeCloseParen, Value: ")", Start: Position{13, 1, 8}, End: Position{14, 1, 9}},
+			},
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			lex := NewLexer(strings.NewReader(data.Pattern))
+			as := []*Token{}
+			for {
+				a := lex.NextToken()
+				if a == nil {
+					break
+				}
+				as = append(as, a)
+			}
+			if !reflect.DeepEqual(data.Expected, as) {
+				t.Fatalf("%s", pretty.Compare(data.Expected, as))
+			}
+		})
+	}
+}
+
+func TestNextTokenComment(t *testing.T) {
+	testData := []struct {
+		Name     string
+		Pattern  string
+		Dialect  Dialect
+		Keep     bool
+		Expected []*Token
+	}{
+		{
+			Name:    "pattern 1 - wat line comment",
+			Pattern: "(a ;; comment (b)\n c)",
+			Dialect: DialectWAT,
+			Expected: []*Token{
+				{Type: TokenTypeOpenParen, Value: "("},
+				{Type: TokenTypeSymbol, Value: "a"},
+				{Type: TokenTypeSymbol, Value: "c"},
+				{Type: TokenTypeCloseParen, Value: ")"},
+			},
+		},
+		{
+			Name:    "pattern 2 - wat line comment, kept",
+			Pattern: "(a ;; comment (b)\n c)",
+			Dialect: DialectWAT,
+			Keep:    true,
+			Expected: []*Token{
+				{Type: TokenTypeOpenParen, Value: "("},
+				{Type: TokenTypeSymbol, Value: "a"},
+				{Type: TokenTypeComment, Value: ";; comment (b)"},
+				{Type: TokenTypeSymbol, Value: "c"},
+				{Type: TokenTypeCloseParen, Value: ")"},
+			},
+		},
+		{
+			Name:    "pattern 3 - wat nested block comment, kept",
+			Pattern: "(a (; x (; y ;) z ;) b)",
+			Dialect: DialectWAT,
+			Keep:    true,
+			Expected: []*Token{
+				{Type: TokenTypeOpenParen, Value: "("},
+				{Type: TokenTypeSymbol, Value: "a"},
+				{Type: TokenTypeComment, Value: "(; x (; y ;) z ;)"},
+				{Type: TokenTypeSymbol, Value: "b"},
+				{Type: TokenTypeCloseParen, Value: ")"},
+			},
+		},
+		{
+			Name:    "pattern 4 - wat single semicolon is a symbol",
+			Pattern: "; a",
+			Dialect: DialectWAT,
+			Expected: []*Token{
+				{Type: TokenTypeSymbol, Value: ";"},
+				{Type: TokenTypeSymbol, Value: "a"},
+			},
+		},
+		{
+			Name:    "pattern 5 - scheme comments",
+			Pattern: "(define ; one\n #| two |# x #;(y z) #; w 1)",
+			Dialect: DialectScheme,
+			Expected: []*Token{
+				{Type: TokenTypeOpenParen, Value: "("},
+				{Type: TokenTypeSymbol, Value: "define"},
+				{Type: TokenTypeSymbol, Value: "x"},
+				{Type: TokenTypeNumber, Value: "1"},
+				{Type: TokenTypeCloseParen, Value: ")"},
+			},
+		},
+		{
+			Name:    "pattern 6 - scheme datum comments, kept",
+			Pattern: "(x #;(y #;z (w)) #; #| c |# v 1)",
+			Dialect: DialectScheme,
+			Keep:    true,
+			Expected: []*Token{
+				{Type: TokenTypeOpenParen, Value: "("},
+				{Type: TokenTypeSymbol, Value: "x"},
+				{Type: TokenTypeComment, Value: "#;(y #;z (w))"},
+				{Type: TokenTypeComment, Value: "#; #| c |# v"},
+				{Type: TokenTypeNumber, Value: "1"},
+				{Type: TokenTypeCloseParen, Value: ")"},
+			},
+		},
+		{
+			Name:    "pattern 7 - common lisp",
+			Pattern: "(defun f () #| (; |# ;; doc\n nil)",
+			Dialect: DialectCommonLisp,
+			Expected: []*Token{
+				{Type: TokenTypeOpenParen, Value: "("},
+				{Type: TokenTypeSymbol, Value: "defun"},
+				{Type: TokenTypeSymbol, Value: "f"},
+				{Type: TokenTypeOpenParen, Value: "("},
+				{Type: TokenTypeCloseParen, Value: ")"},
+				{Type: TokenTypeSymbol, Value: "nil"},
+				{Type: TokenTypeCloseParen, Value: ")"},
+			},
+		},
+		{
+			Name:    "pattern 8 - no comments",
+			Pattern: "(;; a)",
+			Dialect: DialectNone,
+			Expected: []*Token{
+				{Type: TokenTypeOpenParen, Value: "("},
+				{Type: TokenTypeSymbol, Value: ";;"},
+				{Type: TokenTypeSymbol, Value: "a"},
+				{Type: TokenTypeCloseParen, Value: ")"},
+			},
+		},
+		{
+			Name:    "pattern 9 - wat comments end symbols, kept",
+			Pattern: "(a;;b\n c(;d;)e)",
+			Dialect: DialectWAT,
+			Keep:    true,
+			Expected: []*Token{
+				{Type: TokenTypeOpenParen, Value: "("},
+				{Type: TokenTypeSymbol, Value: "a"},
+				{Type: TokenTypeComment, Value: ";;b"},
+				{Type: TokenTypeSymbol, Value: "c"},
+				{Type: TokenTypeComment, Value: "(;d;)"},
+				{Type: TokenTypeSymbol, Value: "e"},
+				{Type: TokenTypeCloseParen, Value: ")"},
+			},
+		},
+		{
+			Name:    "pattern 10 - wat single semicolon inside a symbol",
+			Pattern: "a;b",
+			Dialect: DialectWAT,
+			Expected: []*Token{
+				{Type: TokenTypeSymbol, Value: "a;b"},
+			},
+		},
+		{
+			Name:    "pattern 11 - scheme comments end symbols, kept",
+			Pattern: "(foo;c\n bar)",
+			Dialect: DialectScheme,
+			Keep:    true,
+			Expected: []*Token{
+				{Type: TokenTypeOpenParen, Value: "("},
+				{Type: TokenTypeSymbol, Value: "foo"},
+				{Type: TokenTypeComment, Value: ";c"},
+				{Type: TokenTypeSymbol, Value: "bar"},
+				{Type: TokenTypeCloseParen, Value: ")"},
+			},
+		},
+		{
+			Name:    "pattern 12 - raw bytes that are not utf-8, kept",
+			Pattern: "(x #;\"\xfe\x80\" ;\xff\n #|\x80|# \"\xff\" y\xfe)",
+			Dialect: DialectScheme,
+			Keep:    true,
+			Expected: []*Token{
+				{Type: TokenTypeOpenParen, Value: "("},
+				{Type: TokenTypeSymbol, Value: "x"},
+				{Type: TokenTypeComment, Value: "#;\"\xfe\x80\""},
+				{Type: TokenTypeComment, Value: ";\xff"},
+				{Type: TokenTypeComment, Value: "#|\x80|#"},
+				{Type: TokenTypeString, Value: "\"\xff\""},
+				{Type: TokenTypeSymbol, Value: "y\xfe"},
+				{Type: TokenTypeCloseParen, Value: ")"},
+			},
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			opts := []LexerOption{WithDialect(data.Dialect)}
+			if data.Keep {
+				opts = append(opts, KeepComments())
+			}
+			lex := NewLexer(strings.NewReader(data.Pattern), opts...)
+			as := []*Token{}
+			for {
+				a := lex.NextToken()
+				if a == nil {
+					break
+				}
+				as = append(as, a)
+			}
+			if lex.Err() != nil {
+				t.Fatalf("unexpected error: %+v", lex.Err())
+			}
+			as = stripTokenPositions(as)
+			if !reflect.DeepEqual(data.Expected, as) {
+				t.Fatalf("%s", pretty.Compare(data.Expected, as))
+			}
+		})
+	}
+}
+
+func TestNextTokenCommentError(t *testing.T) {
+	testData := []struct {
+		Name          string
+		Pattern       string
+		Dialect       Dialect
+		ExpectedError string
+	}{
+		{
+			Name:          "pattern 1 - unterminated block comment",
+			Pattern:       "(a (; b (; c ;)",
+			Dialect:       DialectWAT,
+			ExpectedError: `1:4: block comment not terminated: "(a (; b (; c ;)"`,
+		},
+		{
+			Name:          "pattern 2 - datum comment at EOF",
+			Pattern:       "(a #;",
+			Dialect:       DialectScheme,
+			ExpectedError: `1:6: expected expression, but found EOF: "(a #;"`,
+		},
+		{
+			Name:          "pattern 3 - datum comment before close paren",
+			Pattern:       "(a #;)",
+			Dialect:       DialectScheme,
+			ExpectedError: `1:6: expected expression, but found ")": "(a #;)"`,
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			lex := NewLexer(strings.NewReader(data.Pattern), WithDialect(data.Dialect))
+			for lex.NextToken() != nil {
+			}
+			if lex.Err() == nil {
+				t.Fatalf("expected an error")
+			}
+			if data.ExpectedError != lex.Err().Error() {
+				t.Fatalf("\nExpected: %s\nActual:   %s", data.ExpectedError, lex.Err().Error())
+			}
+		})
+	}
+}
+
+func TestLexerErr(t *testing.T) {
+	lex := NewLexer(strings.NewReader(`(a "b`))
+	for lex.NextToken() != nil {
+	}
+	err := lex.Err()
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	se, ok := err.(*SyntaxError)
+	if !ok {
+		t.Fatalf("expected *SyntaxError, but got %T", err)
+	}
+	if se.Pos != (Position{3, 1, 4}) {
+		t.Fatalf("unexpected position: %s", se.Pos)
+	}
+
+	lex = NewLexer(strings.NewReader(`(a "b")`))
+	for lex.NextToken() != nil {
+	}
+	if lex.Err() != nil {
+		t.Fatalf("unexpected error: %+v", lex.Err())
+	}
+}
+
+func TestLexerPipe(t *testing.T) {
+	// the tokens must be returned as soon as they are complete, without waiting for more input.
+	r, w := io.Pipe()
+	defer w.Close()
+	go func() {
+		_, _ = w.Write([]byte("(a \"\xff\" b)"))
+	}()
+
+	done := make(chan []*Token)
+	go func() {
+		lex := NewLexer(r)
+		tokens := []*Token{}
+		for i := 0; i < 5; i++ {
+			tokens = append(tokens, lex.NextToken())
+		}
+		done <- tokens
+	}()
+
+	select {
+	case tokens := <-done:
+		expected := []*Token{
+			{Type: TokenTypeOpenParen, Value: "("},
+			{Type: TokenTypeSymbol, Value: "a"},
+			{Type: TokenTypeString, Value: "\"\xff\""},
+			{Type: TokenTypeSymbol, Value: "b"},
+			{Type: TokenTypeCloseParen, Value: ")"},
+		}
+		if diff := pretty.Compare(expected, stripTokenPositions(tokens)); diff != "" {
+			t.Fatalf("\n%s", diff)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("the lexer waits for more input")
+	}
+}
+
+func stripTokenPositions(tokens []*Token) []*Token {
+	result := make([]*Token, 0, len(tokens))
+	for _, token := range tokens {
+		result = append(result, &Token{Type: token.Type, Value: token.Value})
+	}
+	return result
+}
+
 func TestReadSymbol(t *testing.T) {
 	testData := []struct {
 		Name     string
@@ -118,7 +485,7 @@ func TestReadSymbol(t *testing.T) {
 		t.Run(data.Name, func(t *testing.T) {
 			//t.Parallel()
 
-			a, err := readSymbol(bufio.NewReader(strings.NewReader(data.Pattern)))
+			a, err := readSymbol(newPosReader(bufio.NewReader(strings.NewReader(data.Pattern))), DialectWAT)
 			if err != nil {
 				t.Fatalf("unexpected error: %+v", err)
 			}
@@ -152,7 +519,7 @@ func TestReadNumber(t *testing.T) {
 		t.Run(data.Name, func(t *testing.T) {
 			//t.Parallel()
 
-			a, err := readString(bufio.NewReader(strings.NewReader(data.Pattern)))
+			a, err := readString(newPosReader(bufio.NewReader(strings.NewReader(data.Pattern))))
 			if err != nil {
 				t.Fatalf("unexpected error: %+v", err)
 			}
@@ -163,6 +530,139 @@ func TestReadNumber(t *testing.T) {
 	}
 }
 
+func TestReadStringEscapes(t *testing.T) {
+	testData := []struct {
+		Name          string
+		Pattern       string
+		Expected      string
+		ExpectedError error
+	}{
+		{
+			Name:     "pattern 1 - escaped backslash before the closing quote",
+			Pattern:  `"a\\" b`,
+			Expected: `"a\\"`,
+		},
+		{
+			Name:     "pattern 2 - escaped backslash then escaped quote",
+			Pattern:  `"a\\\"" b`,
+			Expected: `"a\\\""`,
+		},
+		{
+			Name:     "pattern 3 - three escaped backslashes",
+			Pattern:  `"\\\\\\" "x"`,
+			Expected: `"\\\\\\"`,
+		},
+		{
+			Name:     "pattern 4 - only an escaped quote",
+			Pattern:  `"\"" "x"`,
+			Expected: `"\""`,
+		},
+		{
+			Name:     "pattern 5 - empty",
+			Pattern:  `"" "x"`,
+			Expected: `""`,
+		},
+		{
+			Name:     "pattern 6 - hex escapes",
+			Pattern:  `"\00\61\5c" x`,
+			Expected: `"\00\61\5c"`,
+		},
+		{
+			Name:     "pattern 7 - escape at the 16 byte boundary",
+			Pattern:  `"0123456789abcd\"\\" x`,
+			Expected: `"0123456789abcd\"\\"`,
+		},
+		{
+			Name:     "pattern 8 - multibyte across the 16 byte boundary",
+			Pattern:  `"0123456789abcd日本\\"`,
+			Expected: `"0123456789abcd日本\\"`,
+		},
+		{
+			Name:          "pattern 9 - EOF",
+			Pattern:       `"abc`,
+			ExpectedError: io.ErrUnexpectedEOF,
+		},
+		{
+			Name:          "pattern 10 - EOF after an escaped quote",
+			Pattern:       `"abc\"`,
+			ExpectedError: io.ErrUnexpectedEOF,
+		},
+		{
+			Name:          "pattern 11 - EOF after a backslash",
+			Pattern:       `"abc\`,
+			ExpectedError: io.ErrUnexpectedEOF,
+		},
+		{
+			Name:          "pattern 12 - EOF at the start",
+			Pattern:       ``,
+			ExpectedError: io.EOF,
+		},
+	}
+
+	readers := []struct {
+		Name string
+		New  func(s string) *bufio.Reader
+	}{
+		{
+			Name: "default",
+			New:  func(s string) *bufio.Reader { return bufio.NewReader(strings.NewReader(s)) },
+		},
+		{
+			Name: "small buffer, one byte at a time",
+			New: func(s string) *bufio.Reader {
+				return bufio.NewReaderSize(iotest.OneByteReader(strings.NewReader(s)), 16)
+			},
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		for _, reader := range readers {
+			reader := reader // capture
+			t.Run(data.Name+"/"+reader.Name, func(t *testing.T) {
+				//t.Parallel()
+
+				a, err := readString(newPosReader(reader.New(data.Pattern)))
+				if err != data.ExpectedError {
+					t.Fatalf("\nExpected error: %v\nActual error:   %v", data.ExpectedError, err)
+				}
+				if data.Expected != a {
+					t.Fatalf("\nExpected: %s\nActual:   %s", data.Expected, a)
+				}
+			})
+		}
+	}
+}
+
+func TestNextTokenEscapedBackslash(t *testing.T) {
+	lex := NewLexer(strings.NewReader(`(data "a\\" "b\\\"c") (x)`))
+	expected := []*Token{
+		{Type: TokenTypeOpenParen, Value: "("},
+		{Type: TokenTypeSymbol, Value: "data"},
+		{Type: TokenTypeString, Value: `"a\\"`},
+		{Type: TokenTypeString, Value: `"b\\\"c"`},
+		{Type: TokenTypeCloseParen, Value: ")"},
+		{Type: TokenTypeOpenParen, Value: "("},
+		{Type: TokenTypeSymbol, Value: "x"},
+		{Type: TokenTypeCloseParen, Value: ")"},
+	}
+	as := []*Token{}
+	for {
+		a := lex.NextToken()
+		if a == nil {
+			break
+		}
+		as = append(as, a)
+	}
+	if lex.Err() != nil {
+		t.Fatalf("unexpected error: %+v", lex.Err())
+	}
+	as = stripTokenPositions(as)
+	if !reflect.DeepEqual(expected, as) {
+		t.Fatalf("%s", pretty.Compare(expected, as))
+	}
+}
+
 func TestUnread(t *testing.T) {
 	lex := NewLexer(strings.NewReader("(a b c)"))
 
@@ -280,7 +780,7 @@ func TestReadString(t *testing.T) {
 		t.Run(data.Name, func(t *testing.T) {
 			//t.Parallel()
 
-			a, err := readSymbol(bufio.NewReader(strings.NewReader(data.Pattern)))
+			a, err := readSymbol(newPosReader(bufio.NewReader(strings.NewReader(data.Pattern))), DialectWAT)
 			if err != nil {
 				t.Fatalf("unexpected error: %+v", err)
 			}
@@ -0,0 +1,404 @@
+package sexp
+
+import (
+	"bytes"
+	"encoding"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MapStyle selects how maps and structs are written.
+type MapStyle int
+
+const (
+	// MapStyleAlist writes an association list: ((key value) ...)
+	MapStyleAlist MapStyle = iota
+	// MapStylePlist writes a property list with keyword keys: (:key value ...)
+	MapStylePlist
+)
+
+// Marshaler is implemented by types that write themselves as an S-expression.
+type Marshaler interface {
+	MarshalSexp() ([]byte, error)
+}
+
+// UnsupportedTypeError is returned by Marshal for values that have no S-expression form.
+type UnsupportedTypeError struct {
+	Type reflect.Type
+}
+
+func (e *UnsupportedTypeError) Error() string {
+	return "sexp: unsupported type: " + e.Type.String()
+}
+
+// MarshalerError wraps an error returned by a Marshaler or a TextMarshaler.
+type MarshalerError struct {
+	Type reflect.Type
+	Err  error
+}
+
+func (e *MarshalerError) Error() string {
+	return "sexp: error calling marshaler for type " + e.Type.String() + ": " + e.Err.Error()
+}
+
+// Marshal returns the S-expression for v.
+//
+// Values are written as follows:
+//
+//   - bool as the symbol true or false
+//   - integers and floats as numbers, with inf, -inf and nan for the special values
+//   - strings and byte slices as string literals
+//   - slices and arrays as lists
+//   - maps and structs as association lists, ((key value) ...), with map keys sorted
+//   - pointers and interfaces as the value they point to, or the symbol nil
+//
+// Types implementing Marshaler write themselves, and types implementing
+// encoding.TextMarshaler, such as time.Time, are written as string literals.
+//
+// Struct fields are named by the "sexp" key of the field tag, or by the field name.
+// The tag option omitempty omits the field if its value is empty, and the options
+// alist and plist select the style of a map or struct field. A tag of "-" omits the field.
+func Marshal(v interface{}) ([]byte, error) {
+	s, err := marshalSexp(v, MapStyleAlist)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(s.String()), nil
+}
+
+// Encoder writes S-expressions for Go values to an output stream.
+type Encoder struct {
+	w     io.Writer
+	style MapStyle
+	print *PrintOptions
+}
+
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// SetMapStyle sets the style of maps and structs whose fields do not select one.
+func (enc *Encoder) SetMapStyle(style MapStyle) {
+	enc.style = style
+}
+
+// SetPrintOptions makes the Encoder lay out expressions as Fprint does.
+// With nil, which is the default, each expression is written on a single line.
+func (enc *Encoder) SetPrintOptions(opts *PrintOptions) {
+	enc.print = opts
+}
+
+// Encode writes the S-expression for v followed by a newline.
+func (enc *Encoder) Encode(v interface{}) error {
+	s, err := marshalSexp(v, enc.style)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if enc.print != nil {
+		err = Fprint(&buf, s, enc.print)
+		if err != nil {
+			return err
+		}
+	} else {
+		buf.WriteString(s.String())
+	}
+	buf.WriteByte('\n')
+	_, err = enc.w.Write(buf.Bytes())
+	return err
+}
+
+func marshalSexp(v interface{}, style MapStyle) (*Sexp, error) {
+	e := &encodeState{style: style}
+	return e.encode(reflect.ValueOf(v))
+}
+
+type encodeState struct {
+	style MapStyle
+}
+
+var (
+	marshalerType     = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+func symbolAtom(s string) *Sexp {
+	return newAtom(&Token{Type: TokenTypeSymbol, Value: s})
+}
+
+func numberAtom(s string) *Sexp {
+	return newAtom(&Token{Type: TokenTypeNumber, Value: s})
+}
+
+func stringAtom(b []byte) *Sexp {
+	return newAtom(&Token{Type: TokenTypeString, Value: quote(b)})
+}
+
+func newList(children ...*Sexp) *Sexp {
+	if children == nil {
+		children = []*Sexp{}
+	}
+	return &Sexp{Children: children}
+}
+
+func (e *encodeState) encode(v reflect.Value) (*Sexp, error) {
+	if !v.IsValid() {
+		return symbolAtom("nil"), nil
+	}
+	if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+		return symbolAtom("nil"), nil
+	}
+
+	if v.Type().Implements(marshalerType) {
+		return e.encodeMarshaler(v)
+	}
+	if v.Type().Implements(textMarshalerType) {
+		return e.encodeTextMarshaler(v)
+	}
+	if v.CanAddr() && v.Kind() != reflect.Ptr {
+		if reflect.PtrTo(v.Type()).Implements(marshalerType) {
+			return e.encodeMarshaler(v.Addr())
+		}
+		if reflect.PtrTo(v.Type()).Implements(textMarshalerType) {
+			return e.encodeTextMarshaler(v.Addr())
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return symbolAtom(strconv.FormatBool(v.Bool())), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return numberAtom(strconv.FormatInt(v.Int(), 10)), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return numberAtom(strconv.FormatUint(v.Uint(), 10)), nil
+	case reflect.Float32, reflect.Float64:
+		return numberAtom(formatFloat(v.Float(), v.Type().Bits())), nil
+	case reflect.String:
+		return stringAtom([]byte(v.String())), nil
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return stringAtom(v.Bytes()), nil
+		}
+		return e.encodeList(v)
+	case reflect.Array:
+		return e.encodeList(v)
+	case reflect.Map:
+		return e.encodeMap(v, e.style)
+	case reflect.Struct:
+		return e.encodeStruct(v, e.style)
+	case reflect.Ptr, reflect.Interface:
+		return e.encode(v.Elem())
+	}
+	return nil, &UnsupportedTypeError{Type: v.Type()}
+}
+
+func formatFloat(f float64, bits int) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		return "nan"
+	}
+	return strconv.FormatFloat(f, 'g', -1, bits)
+}
+
+func (e *encodeState) encodeMarshaler(v reflect.Value) (*Sexp, error) {
+	b, err := v.Interface().(Marshaler).MarshalSexp()
+	if err != nil {
+		return nil, &MarshalerError{Type: v.Type(), Err: err}
+	}
+	s, err := ParseStrict(string(b))
+	if err != nil {
+		return nil, &MarshalerError{Type: v.Type(), Err: err}
+	}
+	return s, nil
+}
+
+func (e *encodeState) encodeTextMarshaler(v reflect.Value) (*Sexp, error) {
+	b, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+	if err != nil {
+		return nil, &MarshalerError{Type: v.Type(), Err: err}
+	}
+	return stringAtom(b), nil
+}
+
+func (e *encodeState) encodeList(v reflect.Value) (*Sexp, error) {
+	s := newList()
+	for i := 0; i < v.Len(); i++ {
+		c, err := e.encode(v.Index(i))
+		if err != nil {
+			return nil, err
+		}
+		s.Children = append(s.Children, c)
+	}
+	return s, nil
+}
+
+// entries writes key-value pairs in the given style.
+func entries(keys []*Sexp, values []*Sexp, style MapStyle) *Sexp {
+	s := newList()
+	for i, key := range keys {
+		if style == MapStylePlist {
+			if key.IsSymbol() {
+				key = symbolAtom(":" + key.Atom.Value)
+			}
+			s.Children = append(s.Children, key, values[i])
+			continue
+		}
+		s.Children = append(s.Children, newList(key, values[i]))
+	}
+	return s
+}
+
+// keyAtom returns a symbol for name if it reads back as the same symbol, and a string literal otherwise.
+func keyAtom(name string) *Sexp {
+	if isPlainSymbol(name) {
+		return symbolAtom(name)
+	}
+	return stringAtom([]byte(name))
+}
+
+// isPlainSymbol reports whether s is read as a symbol in any dialect and is not a keyword.
+// A semicolon could start a comment in a dialect other than the default one, so it is not allowed.
+func isPlainSymbol(s string) bool {
+	return isSymbolText(s) && s != "nil" && s[0] != ':' && s[0] != '#' && !strings.Contains(s, ";")
+}
+
+// isSymbolText reports whether s is read back as a single symbol in the default dialect.
+func isSymbolText(s string) bool {
+	if s == "" || isNumber(s) || splitsSymbol(s, DialectWAT) {
+		return false
+	}
+	for _, r := range s {
+		if !isSymbolRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitsSymbol reports whether the lexer would not read s as a single symbol in the
+// dialect d, because a comment starts in it: a line comment anywhere, as in readSymbol,
+// and any comment at the start, as in scanComment.
+func splitsSymbol(s string, d Dialect) bool {
+	if d.LineComment != "" && strings.Contains(s, d.LineComment) {
+		return true
+	}
+	for _, prefix := range []string{d.BlockCommentStart, d.DatumComment} {
+		if prefix != "" && strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *encodeState) encodeMap(v reflect.Value, style MapStyle) (*Sexp, error) {
+	if v.IsNil() {
+		return newList(), nil
+	}
+
+	type entry struct {
+		sortKey string
+		key     *Sexp
+		value   *Sexp
+	}
+	es := []entry{}
+	iter := v.MapRange()
+	for iter.Next() {
+		key, sortKey, err := e.encodeMapKey(iter.Key())
+		if err != nil {
+			return nil, err
+		}
+		value, err := e.encode(iter.Value())
+		if err != nil {
+			return nil, err
+		}
+		es = append(es, entry{sortKey: sortKey, key: key, value: value})
+	}
+	sort.Slice(es, func(i, j int) bool { return es[i].sortKey < es[j].sortKey })
+
+	keys := make([]*Sexp, len(es))
+	values := make([]*Sexp, len(es))
+	for i, en := range es {
+		keys[i] = en.key
+		values[i] = en.value
+	}
+	return entries(keys, values, style), nil
+}
+
+// encodeMapKey returns a map key as an atom and as the string to sort it by.
+func (e *encodeState) encodeMapKey(k reflect.Value) (*Sexp, string, error) {
+	if k.Kind() == reflect.String {
+		return keyAtom(k.String()), k.String(), nil
+	}
+	if k.Type().Implements(textMarshalerType) {
+		if k.Kind() == reflect.Ptr && k.IsNil() {
+			return symbolAtom("nil"), "", nil
+		}
+		b, err := k.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return nil, "", &MarshalerError{Type: k.Type(), Err: err}
+		}
+		return keyAtom(string(b)), string(b), nil
+	}
+	switch k.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		s, err := e.encode(k)
+		if err != nil {
+			return nil, "", err
+		}
+		return s, s.Atom.Value, nil
+	}
+	return nil, "", &UnsupportedTypeError{Type: k.Type()}
+}
+
+func (e *encodeState) encodeStruct(v reflect.Value, style MapStyle) (*Sexp, error) {
+	keys := []*Sexp{}
+	values := []*Sexp{}
+	for _, f := range cachedFields(v.Type()) {
+		fv, ok := fieldByIndex(v, f.index)
+		if !ok || f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+
+		var value *Sexp
+		var err error
+		switch {
+		case f.hasStyle && fv.Kind() == reflect.Map:
+			value, err = e.encodeMap(fv, f.style)
+		case f.hasStyle && fv.Kind() == reflect.Struct:
+			value, err = e.encodeStruct(fv, f.style)
+		default:
+			value, err = e.encode(fv)
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, keyAtom(f.name))
+		values = append(values, value)
+	}
+	return entries(keys, values, style), nil
+}
+
+// fieldByIndex is like reflect.Value.FieldByIndex but reports false instead of
+// panicking on a nil embedded pointer.
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
@@ -0,0 +1,261 @@
+package sexp
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+type marshalServer struct {
+	Host    string            `sexp:"host"`
+	Port    int               `sexp:"port"`
+	TLS     bool              `sexp:"tls,omitempty"`
+	Tags    []string          `sexp:"tags,omitempty"`
+	Env     map[string]string `sexp:"env,omitempty,plist"`
+	Timeout *float64          `sexp:"timeout"`
+	secret  string
+	Ignored int `sexp:"-"`
+}
+
+type marshalEmbedded struct {
+	marshalBase
+	Name string `sexp:"name"`
+}
+
+type marshalBase struct {
+	ID   int    `sexp:"id"`
+	Name string `sexp:"base-name"`
+}
+
+// marshalAmbiguous embeds fields of the same names at the same level.
+type marshalAmbiguous struct {
+	marshalLeft
+	marshalRight
+}
+
+type marshalLeft struct {
+	A string
+	X string `sexp:"B"`
+	C string `sexp:"c"`
+}
+
+type marshalRight struct {
+	A string
+	B string
+	Y string `sexp:"c"`
+}
+
+type marshalColor int
+
+func (c marshalColor) MarshalSexp() ([]byte, error) {
+	return []byte([]string{"(rgb 255 0 0)", "(rgb 0 255 0)"}[c]), nil
+}
+
+type marshalBroken struct{}
+
+func (marshalBroken) MarshalSexp() ([]byte, error) {
+	return []byte("(unbalanced"), nil
+}
+
+type marshalFailing struct{}
+
+func (marshalFailing) MarshalText() ([]byte, error) {
+	return nil, errors.New("failed")
+}
+
+func TestMarshal(t *testing.T) {
+	timeout := 1.5
+
+	testData := []struct {
+		Name     string
+		Value    interface{}
+		Expected string
+	}{
+		{
+			Name:     "pattern 1 - scalars",
+			Value:    []interface{}{true, false, -1, uint8(255), 1.5, float32(0.1), 1e21, "a\"b\n", nil},
+			Expected: `(true false -1 255 1.5 0.1 1e+21 "a\"b\n" nil)`,
+		},
+		{
+			Name:     "pattern 2 - special floats",
+			Value:    []float64{math.Inf(1), math.Inf(-1), math.NaN()},
+			Expected: `(inf -inf nan)`,
+		},
+		{
+			Name:     "pattern 3 - bytes",
+			Value:    []byte("\x00asm\x01\xff"),
+			Expected: `"\00asm\01\ff"`,
+		},
+		{
+			Name:     "pattern 4 - struct",
+			Value:    marshalServer{Host: "x", Port: 8080, secret: "s", Ignored: 1},
+			Expected: `((host "x") (port 8080) (timeout nil))`,
+		},
+		{
+			Name: "pattern 5 - struct with all the fields",
+			Value: &marshalServer{
+				Host:    "x",
+				Port:    8080,
+				TLS:     true,
+				Tags:    []string{"a", "b"},
+				Env:     map[string]string{"PATH": "/bin", "HOME": "/root"},
+				Timeout: &timeout,
+			},
+			Expected: `((host "x") (port 8080) (tls true) (tags ("a" "b")) (env (:HOME "/root" :PATH "/bin")) (timeout 1.5))`,
+		},
+		{
+			Name:     "pattern 6 - map with keys that are not symbols",
+			Value:    map[string]int{"b": 2, "a b": 1, "1": 3, "": 4},
+			Expected: `(("" 4) ("1" 3) ("a b" 1) (b 2))`,
+		},
+		{
+			Name:     "pattern 7 - map with integer keys",
+			Value:    map[int]string{2: "b", 1: "a"},
+			Expected: `((1 "a") (2 "b"))`,
+		},
+		{
+			Name:     "pattern 8 - embedded struct",
+			Value:    marshalEmbedded{marshalBase: marshalBase{ID: 1, Name: "base"}, Name: "outer"},
+			Expected: `((name "outer") (id 1) (base-name "base"))`,
+		},
+		{
+			Name:     "pattern 9 - time",
+			Value:    time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+			Expected: `"2020-01-02T03:04:05Z"`,
+		},
+		{
+			Name:     "pattern 10 - marshaler",
+			Value:    []marshalColor{0, 1},
+			Expected: `((rgb 255 0 0) (rgb 0 255 0))`,
+		},
+		{
+			Name:     "pattern 11 - empty and nil",
+			Value:    []interface{}{[]int{}, []int(nil), map[string]int(nil), (*int)(nil), [0]int{}},
+			Expected: `(() () () nil ())`,
+		},
+		{
+			Name: "pattern 12 - nil interfaces",
+			Value: struct {
+				M encoding.TextMarshaler
+				S Marshaler
+				V interface{}
+			}{},
+			Expected: `((M nil) (S nil) (V nil))`,
+		},
+		{
+			Name: "pattern 13 - fields of the same name at the same level",
+			Value: marshalAmbiguous{
+				marshalLeft:  marshalLeft{A: "a1", X: "x", C: "c1"},
+				marshalRight: marshalRight{A: "a2", B: "b", Y: "c2"},
+			},
+			Expected: `((B "x"))`,
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			b, err := Marshal(data.Value)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			a := string(b)
+			if data.Expected != a {
+				t.Fatalf("\n%s", pretty.Compare(data.Expected, a))
+			}
+
+			_, err = ParseStrict(a)
+			if err != nil {
+				t.Fatalf("the output does not parse back: %v", err)
+			}
+		})
+	}
+}
+
+func TestMarshalError(t *testing.T) {
+	testData := []struct {
+		Name     string
+		Value    interface{}
+		Expected string
+	}{
+		{
+			Name:     "pattern 1 - unsupported type",
+			Value:    []interface{}{1, make(chan int)},
+			Expected: "sexp: unsupported type: chan int",
+		},
+		{
+			Name:     "pattern 2 - invalid output of a marshaler",
+			Value:    marshalBroken{},
+			Expected: `sexp: error calling marshaler for type sexp.marshalBroken: 1:12: expected ")", but found EOF: "(unbalanced"`,
+		},
+		{
+			Name:     "pattern 3 - text marshaler failure",
+			Value:    map[string]interface{}{"a": marshalFailing{}},
+			Expected: "sexp: error calling marshaler for type sexp.marshalFailing: failed",
+		},
+		{
+			Name:     "pattern 4 - unsupported map key",
+			Value:    map[float64]int{1: 1},
+			Expected: "sexp: unsupported type: float64",
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			_, err := Marshal(data.Value)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if data.Expected != err.Error() {
+				t.Fatalf("\nExpected: %s\nActual:   %s", data.Expected, err.Error())
+			}
+		})
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewEncoder(&buf)
+	enc.SetMapStyle(MapStylePlist)
+	err := enc.Encode(marshalServer{Host: "x", Port: 8080})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	enc.SetPrintOptions(&PrintOptions{Width: 20, Indent: 2})
+	err = enc.Encode(map[string][]int{"primes": {2, 3, 5, 7, 11, 13}})
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	expected := `(:host "x" :port 8080 :timeout nil)
+(:primes (2 3 5 7
+          11 13))
+`
+	a := buf.String()
+	if expected != a {
+		t.Fatalf("\n%s", pretty.Compare(expected, a))
+	}
+}
+
+func TestQuote(t *testing.T) {
+	for _, s := range []string{"", "abc", "\"\\'", "\x00\x7f\xff\t\r\n", "日本語", "​", "a\xe6\x97b"} {
+		lit := quote([]byte(s))
+		b, err := unquote(lit)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %s", lit, err.msg)
+		}
+		if string(b) != s {
+			t.Fatalf("%s: expected %q, but got %q", lit, s, b)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+package sexp
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type NumberKind int
+
+const (
+	NumberKindInteger NumberKind = iota
+	NumberKindFloat
+	NumberKindInf
+	NumberKindNaN
+)
+
+// NaNKind tells the different spellings of NaN apart.
+type NaNKind int
+
+const (
+	NaNKindDefault    NaNKind = iota // nan
+	NaNKindPayload                   // nan:0x...
+	NaNKindCanonical                 // nan:canonical
+	NaNKindArithmetic                // nan:arithmetic
+)
+
+var errNotInteger = errors.New("not an integer")
+
+// Number is a numeric literal split into its parts.
+//
+// The accepted syntax follows the WebAssembly text format: decimal and hexadecimal
+// integers, decimal floats with an optional exponent, hexadecimal floats with an
+// optional binary exponent, inf, nan, nan:0x..., nan:canonical and nan:arithmetic,
+// all with an optional sign and with _ allowed between digits.
+type Number struct {
+	Kind     NumberKind
+	NaN      NaNKind // valid if Kind is NumberKindNaN
+	Negative bool
+	Hex      bool
+	Text     string // the literal as written
+
+	mantissa string // digits and '.', without sign, prefix and separators
+	exponent string // signed exponent digits, or "" if there is no exponent
+	payload  string // hex digits of a NaN payload, or "" if there is no payload
+}
+
+// ParseNumber parses a numeric literal.
+func ParseNumber(s string) (*Number, error) {
+	n, ok := scanNumber(s)
+	if !ok {
+		return nil, &strconv.NumError{Func: "ParseNumber", Num: s, Err: strconv.ErrSyntax}
+	}
+	return n, nil
+}
+
+func isNumber(s string) bool {
+	_, ok := scanNumber(s)
+	return ok
+}
+
+func scanNumber(s string) (*Number, bool) {
+	n := &Number{Text: s}
+	if strings.HasPrefix(s, "+") || strings.HasPrefix(s, "-") {
+		n.Negative = s[0] == '-'
+		s = s[1:]
+	}
+
+	switch {
+	case s == "inf":
+		n.Kind = NumberKindInf
+		return n, true
+	case s == "nan":
+		n.Kind = NumberKindNaN
+		return n, true
+	case s == "nan:canonical":
+		n.Kind = NumberKindNaN
+		n.NaN = NaNKindCanonical
+		return n, true
+	case s == "nan:arithmetic":
+		n.Kind = NumberKindNaN
+		n.NaN = NaNKindArithmetic
+		return n, true
+	case strings.HasPrefix(s, "nan:0x"):
+		payload, rest, ok := scanDigits(s[len("nan:0x"):], true)
+		if !ok || rest != "" {
+			return nil, false
+		}
+		n.Kind = NumberKindNaN
+		n.NaN = NaNKindPayload
+		n.Hex = true
+		n.payload = payload
+		return n, true
+	case strings.HasPrefix(s, "0x"):
+		n.Hex = true
+		s = s[len("0x"):]
+	}
+
+	intPart, rest, ok := scanDigits(s, n.Hex)
+	if !ok {
+		return nil, false
+	}
+	n.Kind = NumberKindInteger
+	n.mantissa = intPart
+
+	if strings.HasPrefix(rest, ".") {
+		n.Kind = NumberKindFloat
+		n.mantissa += "."
+		rest = rest[1:]
+		if frac, r, ok := scanDigits(rest, n.Hex); ok {
+			n.mantissa += frac
+			rest = r
+		}
+	}
+
+	expMarks := "eE"
+	if n.Hex {
+		expMarks = "pP"
+	}
+	if rest != "" && strings.IndexByte(expMarks, rest[0]) >= 0 {
+		n.Kind = NumberKindFloat
+		rest = rest[1:]
+		sign := ""
+		if strings.HasPrefix(rest, "+") || strings.HasPrefix(rest, "-") {
+			sign = rest[:1]
+			rest = rest[1:]
+		}
+		exp, r, ok := scanDigits(rest, false)
+		if !ok {
+			return nil, false
+		}
+		n.exponent = sign + exp
+		rest = r
+	}
+
+	if rest != "" {
+		return nil, false
+	}
+	return n, true
+}
+
+// scanDigits reads digits separated by optional single underscores from the
+// beginning of s. It returns the digits without the underscores and the rest of s.
+func scanDigits(s string, hex bool) (string, string, bool) {
+	isDigit := func(c byte) bool {
+		if hex {
+			return isHexDigit(c)
+		}
+		return '0' <= c && c <= '9'
+	}
+
+	buf := []byte{}
+	i := 0
+	for i < len(s) {
+		c := s[i]
+		if c == '_' && len(buf) > 0 && i+1 < len(s) && isDigit(s[i+1]) {
+			i++
+			continue
+		}
+		if !isDigit(c) {
+			break
+		}
+		buf = append(buf, c)
+		i++
+	}
+	if len(buf) == 0 {
+		return "", s, false
+	}
+	return string(buf), s[i:], true
+}
+
+func (n *Number) base() int {
+	if n.Hex {
+		return 16
+	}
+	return 10
+}
+
+func (n *Number) sign() string {
+	if n.Negative {
+		return "-"
+	}
+	return ""
+}
+
+func (n *Number) numError(fn string, err error) error {
+	return &strconv.NumError{Func: fn, Num: n.Text, Err: err}
+}
+
+// Int64 returns the value of an integer literal.
+func (n *Number) Int64() (int64, error) {
+	if n.Kind != NumberKindInteger {
+		return 0, n.numError("Int64", errNotInteger)
+	}
+	v, err := strconv.ParseInt(n.sign()+n.mantissa, n.base(), 64)
+	if err != nil {
+		return 0, n.numError("Int64", err.(*strconv.NumError).Err)
+	}
+	return v, nil
+}
+
+// Uint64 returns the value of a non-negative integer literal.
+func (n *Number) Uint64() (uint64, error) {
+	if n.Kind != NumberKindInteger {
+		return 0, n.numError("Uint64", errNotInteger)
+	}
+	v, err := strconv.ParseUint(n.mantissa, n.base(), 64)
+	if err != nil {
+		return 0, n.numError("Uint64", err.(*strconv.NumError).Err)
+	}
+	if n.Negative && v != 0 {
+		return 0, n.numError("Uint64", strconv.ErrRange)
+	}
+	return v, nil
+}
+
+// BigInt returns the value of an integer literal of any size.
+func (n *Number) BigInt() (*big.Int, error) {
+	if n.Kind != NumberKindInteger {
+		return nil, n.numError("BigInt", errNotInteger)
+	}
+	v, ok := new(big.Int).SetString(n.mantissa, n.base())
+	if !ok {
+		return nil, n.numError("BigInt", strconv.ErrSyntax)
+	}
+	if n.Negative {
+		v.Neg(v)
+	}
+	return v, nil
+}
+
+// IntBits returns the bit pattern of an integer literal as a bitSize-bit integer in two's
+// complement. As in the WebAssembly text format, both the signed and the unsigned
+// range are accepted, so -1 and 0xffffffff have the same 32-bit pattern.
+func (n *Number) IntBits(bitSize int) (uint64, error) {
+	v, err := n.BigInt()
+	if err != nil {
+		return 0, n.numError("IntBits", errNotInteger)
+	}
+	min := new(big.Int).Neg(new(big.Int).Lsh(big.NewInt(1), uint(bitSize-1)))
+	limit := new(big.Int).Lsh(big.NewInt(1), uint(bitSize))
+	if v.Cmp(min) < 0 || v.Cmp(limit) >= 0 {
+		return 0, n.numError("IntBits", strconv.ErrRange)
+	}
+	if v.Sign() < 0 {
+		v.Add(v, limit)
+	}
+	return v.Uint64(), nil
+}
+
+// Float64 returns the value of the literal rounded to the nearest float64.
+// NaN payloads are ignored.
+func (n *Number) Float64() (float64, error) {
+	switch n.Kind {
+	case NumberKindInf:
+		if n.Negative {
+			return math.Inf(-1), nil
+		}
+		return math.Inf(1), nil
+	case NumberKindNaN:
+		return math.NaN(), nil
+	}
+
+	v, err := strconv.ParseFloat(n.floatString(), 64)
+	if err != nil {
+		return 0, n.numError("Float64", err.(*strconv.NumError).Err)
+	}
+	return v, nil
+}
+
+// floatString formats the literal in the syntax accepted by strconv.ParseFloat.
+func (n *Number) floatString() string {
+	if n.Hex {
+		exp := n.exponent
+		if exp == "" {
+			exp = "0"
+		}
+		return n.sign() + "0x" + n.mantissa + "p" + exp
+	}
+	if n.exponent == "" {
+		return n.sign() + n.mantissa
+	}
+	return n.sign() + n.mantissa + "e" + n.exponent
+}
+
+// Number parses a number token.
+func (t *Token) Number() (*Number, error) {
+	if t.Type != TokenTypeNumber {
+		return nil, &SyntaxError{Pos: t.Start, Expected: "number", Found: describeToken(t)}
+	}
+	return ParseNumber(t.Value)
+}
+
+func (t *Token) Int64() (int64, error) {
+	n, err := t.Number()
+	if err != nil {
+		return 0, err
+	}
+	return n.Int64()
+}
+
+func (t *Token) Uint64() (uint64, error) {
+	n, err := t.Number()
+	if err != nil {
+		return 0, err
+	}
+	return n.Uint64()
+}
+
+func (t *Token) Float64() (float64, error) {
+	n, err := t.Number()
+	if err != nil {
+		return 0, err
+	}
+	return n.Float64()
+}
+
+func (t *Token) BigInt() (*big.Int, error) {
+	n, err := t.Number()
+	if err != nil {
+		return nil, err
+	}
+	return n.BigInt()
+}
@@ -0,0 +1,319 @@
+package sexp
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestNumberClassification(t *testing.T) {
+	testData := []struct {
+		Pattern  string
+		Expected TokenType
+	}{
+		{Pattern: "0", Expected: TokenTypeNumber},
+		{Pattern: "123", Expected: TokenTypeNumber},
+		{Pattern: "-123", Expected: TokenTypeNumber},
+		{Pattern: "+123", Expected: TokenTypeNumber},
+		{Pattern: "1_000_000", Expected: TokenTypeNumber},
+		{Pattern: "0x1234567890abcDEF", Expected: TokenTypeNumber},
+		{Pattern: "-0xff_ff", Expected: TokenTypeNumber},
+		{Pattern: "6.023e23", Expected: TokenTypeNumber},
+		{Pattern: "-0.0", Expected: TokenTypeNumber},
+		{Pattern: "1.", Expected: TokenTypeNumber},
+		{Pattern: "1e-10", Expected: TokenTypeNumber},
+		{Pattern: "1.5E+10", Expected: TokenTypeNumber},
+		{Pattern: "0x123.abcp-987", Expected: TokenTypeNumber},
+		{Pattern: "0x1p127", Expected: TokenTypeNumber},
+		{Pattern: "0x1.", Expected: TokenTypeNumber},
+		{Pattern: "inf", Expected: TokenTypeNumber},
+		{Pattern: "-inf", Expected: TokenTypeNumber},
+		{Pattern: "nan", Expected: TokenTypeNumber},
+		{Pattern: "+nan", Expected: TokenTypeNumber},
+		{Pattern: "nan:0x200000", Expected: TokenTypeNumber},
+		{Pattern: "-", Expected: TokenTypeSymbol},
+		{Pattern: "+", Expected: TokenTypeSymbol},
+		{Pattern: "->", Expected: TokenTypeSymbol},
+		{Pattern: "1+", Expected: TokenTypeSymbol},
+		{Pattern: "-foo", Expected: TokenTypeSymbol},
+		{Pattern: "1_", Expected: TokenTypeSymbol},
+		{Pattern: "1__0", Expected: TokenTypeSymbol},
+		{Pattern: "_1", Expected: TokenTypeSymbol},
+		{Pattern: "0x", Expected: TokenTypeSymbol},
+		{Pattern: "0xg", Expected: TokenTypeSymbol},
+		{Pattern: "1e", Expected: TokenTypeSymbol},
+		{Pattern: "1.5p3", Expected: TokenTypeSymbol},
+		{Pattern: ".5", Expected: TokenTypeSymbol},
+		{Pattern: "infinity", Expected: TokenTypeSymbol},
+		{Pattern: "nan:", Expected: TokenTypeSymbol},
+		{Pattern: "nan:0x", Expected: TokenTypeSymbol},
+		{Pattern: "i32.const", Expected: TokenTypeSymbol},
+		{Pattern: "$1", Expected: TokenTypeSymbol},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Pattern, func(t *testing.T) {
+			//t.Parallel()
+
+			lex := NewLexer(strings.NewReader(data.Pattern))
+			token := lex.NextToken()
+			if token == nil {
+				t.Fatalf("expected a token")
+			}
+			if token.Value != data.Pattern {
+				t.Fatalf("\nExpected: %s\nActual:   %s", data.Pattern, token.Value)
+			}
+			if data.Expected != token.Type {
+				t.Fatalf("\nExpected: %s\nActual:   %s", data.Expected, token.Type)
+			}
+		})
+	}
+}
+
+func TestNumberInt64(t *testing.T) {
+	testData := []struct {
+		Pattern       string
+		Expected      int64
+		ExpectedError error
+	}{
+		{Pattern: "0", Expected: 0},
+		{Pattern: "-123", Expected: -123},
+		{Pattern: "+1_000", Expected: 1000},
+		{Pattern: "0x7fff_ffff_ffff_ffff", Expected: math.MaxInt64},
+		{Pattern: "-0x8000000000000000", Expected: math.MinInt64},
+		{Pattern: "9223372036854775807", Expected: math.MaxInt64},
+		{Pattern: "9223372036854775808", ExpectedError: strconv.ErrRange},
+		{Pattern: "-9223372036854775809", ExpectedError: strconv.ErrRange},
+		{Pattern: "1.5", ExpectedError: errNotInteger},
+		{Pattern: "inf", ExpectedError: errNotInteger},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Pattern, func(t *testing.T) {
+			//t.Parallel()
+
+			n, err := ParseNumber(data.Pattern)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			a, err := n.Int64()
+			if data.ExpectedError != nil {
+				checkNumError(t, data.ExpectedError, err)
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if data.Expected != a {
+				t.Fatalf("\nExpected: %d\nActual:   %d", data.Expected, a)
+			}
+		})
+	}
+}
+
+func TestNumberUint64(t *testing.T) {
+	testData := []struct {
+		Pattern       string
+		Expected      uint64
+		ExpectedError error
+	}{
+		{Pattern: "0", Expected: 0},
+		{Pattern: "-0", Expected: 0},
+		{Pattern: "0xffff_ffff_ffff_ffff", Expected: math.MaxUint64},
+		{Pattern: "18446744073709551615", Expected: math.MaxUint64},
+		{Pattern: "18446744073709551616", ExpectedError: strconv.ErrRange},
+		{Pattern: "-1", ExpectedError: strconv.ErrRange},
+		{Pattern: "1e3", ExpectedError: errNotInteger},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Pattern, func(t *testing.T) {
+			//t.Parallel()
+
+			n, err := ParseNumber(data.Pattern)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			a, err := n.Uint64()
+			if data.ExpectedError != nil {
+				checkNumError(t, data.ExpectedError, err)
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if data.Expected != a {
+				t.Fatalf("\nExpected: %d\nActual:   %d", data.Expected, a)
+			}
+		})
+	}
+}
+
+func TestNumberBigInt(t *testing.T) {
+	testData := []struct {
+		Pattern  string
+		Expected string
+	}{
+		{Pattern: "0", Expected: "0"},
+		{Pattern: "-123_456", Expected: "-123456"},
+		{Pattern: "0x1_0000_0000_0000_0000", Expected: "18446744073709551616"},
+		{Pattern: "-340282366920938463463374607431768211456", Expected: "-340282366920938463463374607431768211456"},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Pattern, func(t *testing.T) {
+			//t.Parallel()
+
+			n, err := ParseNumber(data.Pattern)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			a, err := n.BigInt()
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if data.Expected != a.String() {
+				t.Fatalf("\nExpected: %s\nActual:   %s", data.Expected, a)
+			}
+		})
+	}
+}
+
+func TestNumberIntBits(t *testing.T) {
+	testData := []struct {
+		Pattern       string
+		BitSize       int
+		Expected      uint64
+		ExpectedError error
+	}{
+		{Pattern: "0", BitSize: 32, Expected: 0},
+		{Pattern: "-1", BitSize: 32, Expected: 0xffffffff},
+		{Pattern: "0xffff_ffff", BitSize: 32, Expected: 0xffffffff},
+		{Pattern: "-0x8000_0000", BitSize: 32, Expected: 0x80000000},
+		{Pattern: "-0x8000_0001", BitSize: 32, ExpectedError: strconv.ErrRange},
+		{Pattern: "0x1_0000_0000", BitSize: 32, ExpectedError: strconv.ErrRange},
+		{Pattern: "-9223372036854775808", BitSize: 64, Expected: 1 << 63},
+		{Pattern: "18446744073709551615", BitSize: 64, Expected: math.MaxUint64},
+		{Pattern: "1.0", BitSize: 64, ExpectedError: errNotInteger},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Pattern, func(t *testing.T) {
+			//t.Parallel()
+
+			n, err := ParseNumber(data.Pattern)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			a, err := n.IntBits(data.BitSize)
+			if data.ExpectedError != nil {
+				checkNumError(t, data.ExpectedError, err)
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if data.Expected != a {
+				t.Fatalf("\nExpected: %#x\nActual:   %#x", data.Expected, a)
+			}
+		})
+	}
+}
+
+func TestNumberFloat64(t *testing.T) {
+	testData := []struct {
+		Pattern       string
+		Expected      float64
+		ExpectedError error
+	}{
+		{Pattern: "0", Expected: 0},
+		{Pattern: "-0.0", Expected: math.Copysign(0, -1)},
+		{Pattern: "6.023e23", Expected: 6.023e23},
+		{Pattern: "1_000.000_1", Expected: 1000.0001},
+		{Pattern: "1.", Expected: 1},
+		{Pattern: "0xff", Expected: 255},
+		{Pattern: "0x1.8p1", Expected: 3},
+		{Pattern: "-0x1p-2", Expected: -0.25},
+		{Pattern: "0x1.", Expected: 1},
+		{Pattern: "0x1.fffffffffffffp1023", Expected: math.MaxFloat64},
+		{Pattern: "inf", Expected: math.Inf(1)},
+		{Pattern: "-inf", Expected: math.Inf(-1)},
+		{Pattern: "1e309", ExpectedError: strconv.ErrRange},
+		{Pattern: "0x1p1024", ExpectedError: strconv.ErrRange},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Pattern, func(t *testing.T) {
+			//t.Parallel()
+
+			n, err := ParseNumber(data.Pattern)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			a, err := n.Float64()
+			if data.ExpectedError != nil {
+				checkNumError(t, data.ExpectedError, err)
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if math.Float64bits(data.Expected) != math.Float64bits(a) {
+				t.Fatalf("\nExpected: %g\nActual:   %g", data.Expected, a)
+			}
+		})
+	}
+
+	n, err := ParseNumber("nan:0x1")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	a, err := n.Float64()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if !math.IsNaN(a) {
+		t.Fatalf("expected NaN, but got %g", a)
+	}
+}
+
+func TestTokenNumber(t *testing.T) {
+	s, err := Parse("(i64.const -42 x)")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	a, err := s.Children[1].Atom.Int64()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if a != -42 {
+		t.Fatalf("expected -42, but got %d", a)
+	}
+
+	_, err = s.Children[2].Atom.Int64()
+	if err == nil {
+		t.Fatalf("expected an error for a symbol")
+	}
+	expected := `1:16: expected number, but found "x"`
+	if expected != err.Error() {
+		t.Fatalf("\nExpected: %s\nActual:   %s", expected, err.Error())
+	}
+}
+
+func checkNumError(t *testing.T, expected error, err error) {
+	t.Helper()
+	ne, ok := err.(*strconv.NumError)
+	if !ok {
+		t.Fatalf("expected *strconv.NumError, but got %T (%v)", err, err)
+	}
+	if ne.Err != expected {
+		t.Fatalf("\nExpected: %v\nActual:   %v", expected, ne.Err)
+	}
+}
@@ -0,0 +1,207 @@
+package sexp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Position describes a location in the input.
+type Position struct {
+	Offset int // byte offset, starting at 0
+	Line   int // line number, starting at 1
+	Column int // column number in runes, starting at 1
+}
+
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+func startPosition() Position {
+	return Position{Offset: 0, Line: 1, Column: 1}
+}
+
+// advance returns the position reached after reading s from p.
+func (p Position) advance(s string) Position {
+	for len(s) > 0 {
+		r, size := utf8.DecodeRuneInString(s)
+		s = s[size:]
+		p.Offset += size
+		if r == '\n' {
+			p.Line++
+			p.Column = 1
+		} else {
+			p.Column++
+		}
+	}
+	return p
+}
+
+// maxLineBytes is the number of bytes of the current line that posReader keeps at
+// least for error excerpts. It keeps at most twice as many, so that a stream made of
+// a single long line is not held in memory.
+const maxLineBytes = 1024
+
+// posReader is a rune reader that keeps track of the position of the next rune
+// and of the end of the current line read so far.
+type posReader struct {
+	br       *bufio.Reader
+	pos      Position
+	prev     Position
+	line     []byte
+	lineCol  int // the column of line[0]
+	prevLine []byte
+	prevCol  int
+	lastSize int
+	last     []byte // the bytes of the last rune read, which may not be valid UTF-8
+
+	rec       []byte
+	recording int
+}
+
+func newPosReader(br *bufio.Reader) *posReader {
+	return &posReader{
+		br:      br,
+		pos:     startPosition(),
+		lineCol: 1,
+	}
+}
+
+func (r *posReader) ReadRune() (rune, int, error) {
+	c, size, err := r.br.ReadRune()
+	if err != nil {
+		return c, size, err
+	}
+
+	r.prev = r.pos
+	r.lastSize = size
+	if c == utf8.RuneError && size == 1 {
+		// an invalid byte, which is still in the buffer of br
+		_ = r.br.UnreadRune()
+		b, _ := r.br.ReadByte()
+		_ = r.br.UnreadByte()
+		_, _, _ = r.br.ReadRune()
+		r.last = append(r.last[:0], b)
+	} else {
+		var buf [utf8.UTFMax]byte
+		r.last = append(r.last[:0], buf[:utf8.EncodeRune(buf[:], c)]...)
+	}
+	r.pos.Offset += size
+	if c == '\n' {
+		r.pos.Line++
+		r.pos.Column = 1
+		r.prevLine, r.prevCol = r.line, r.lineCol
+		r.line, r.lineCol = nil, 1
+	} else {
+		r.pos.Column++
+		r.line = append(r.line, r.last...)
+		if len(r.line) > 2*maxLineBytes {
+			r.dropLineStart()
+		}
+	}
+	if r.recording > 0 {
+		r.rec = append(r.rec, r.last...)
+	}
+	return c, size, nil
+}
+
+func (r *posReader) UnreadRune() error {
+	err := r.br.UnreadRune()
+	if err != nil {
+		return err
+	}
+	if r.pos.Line != r.prev.Line {
+		r.line, r.lineCol = r.prevLine, r.prevCol
+	} else {
+		r.line = r.line[:len(r.line)-r.lastSize]
+	}
+	if r.recording > 0 {
+		r.rec = r.rec[:len(r.rec)-r.lastSize]
+	}
+	r.pos = r.prev
+	return nil
+}
+
+// dropLineStart drops the start of the line kept, up to the last maxLineBytes bytes.
+func (r *posReader) dropLineStart() {
+	n := len(r.line) - maxLineBytes
+	for n < len(r.line) && !utf8.RuneStart(r.line[n]) {
+		n++
+	}
+	r.lineCol += utf8.RuneCount(r.line[:n])
+	r.line = append(r.line[:0], r.line[n:]...)
+}
+
+// lineExcerpt returns an excerpt of the current line around the given column, or ""
+// if that part of the line is no longer kept.
+func (r *posReader) lineExcerpt(column int) string {
+	if column < r.lineCol {
+		return ""
+	}
+	s := excerpt(string(r.line), column-r.lineCol+1)
+	if r.lineCol > 1 && !strings.HasPrefix(s, "...") {
+		s = "..." + s
+	}
+	return s
+}
+
+// hasPrefix reports whether the unread input starts with s.
+// It waits for more input only while the bytes available so far match s.
+func (r *posReader) hasPrefix(s string) bool {
+	for i := 1; i <= len(s); i++ {
+		b, _ := r.br.Peek(i)
+		if len(b) < i || b[i-1] != s[i-1] {
+			return false
+		}
+	}
+	return true
+}
+
+// restOfLine returns the unread input up to the end of the current line, as far as it
+// has been read from the underlying reader, so that looking at it never waits for input.
+func (r *posReader) restOfLine() string {
+	b, _ := r.br.Peek(r.br.Buffered())
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// consume reads n bytes worth of runes.
+func (r *posReader) consume(n int) (string, error) {
+	buf := []byte{}
+	for len(buf) < n {
+		_, _, err := r.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		buf = append(buf, r.last...)
+	}
+	return string(buf), nil
+}
+
+// startRecording starts keeping the text read from r. Recordings may be nested;
+// the returned value is passed to the matching stopRecording.
+func (r *posReader) startRecording() int {
+	if r.recording == 0 {
+		r.rec = r.rec[:0]
+	}
+	r.recording++
+	return len(r.rec)
+}
+
+// stopRecording returns the text read since the matching startRecording.
+func (r *posReader) stopRecording(from int) string {
+	s := string(r.rec[from:])
+	r.recording--
+	return s
+}
@@ -0,0 +1,277 @@
+package sexp
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// IndentLeadingAtoms as a rule makes the atoms right after the head symbol the
+// distinguished arguments, as in (func $name ...).
+const IndentLeadingAtoms = -1
+
+// PrintOptions controls the layout of Fprint.
+type PrintOptions struct {
+	Width  int // the target line width
+	Indent int // the indentation of list bodies
+
+	// Rules maps a head symbol to the number of its distinguished arguments, in the
+	// manner of Emacs's lisp-indent-function. Distinguished arguments are kept on the
+	// line of the head symbol if possible, and the rest are indented by Indent as a body.
+	// The arguments of lists whose head symbol has no rule are aligned with the first one.
+	Rules map[string]int
+}
+
+var DefaultPrintOptions = &PrintOptions{
+	Width:  80,
+	Indent: 2,
+	Rules: map[string]int{
+		// WebAssembly
+		"module": IndentLeadingAtoms,
+		"func":   IndentLeadingAtoms,
+		"block":  IndentLeadingAtoms,
+		"loop":   IndentLeadingAtoms,
+		"if":     IndentLeadingAtoms,
+		"then":   0,
+		"else":   0,
+
+		// Lisp
+		"defun":    2,
+		"defmacro": 2,
+		"define":   1,
+		"lambda":   1,
+		"let":      1,
+		"let*":     1,
+		"letrec":   1,
+		"when":     1,
+		"unless":   1,
+		"progn":    0,
+		"begin":    0,
+	},
+}
+
+// Fprint writes s to w, laid out within opts.Width columns where possible.
+// If opts is nil, DefaultPrintOptions is used.
+func Fprint(w io.Writer, s *Sexp, opts *PrintOptions) error {
+	if opts == nil {
+		opts = DefaultPrintOptions
+	}
+	return render(bufio.NewWriter(w), opts.Width, opts.layout(s))
+}
+
+// Sprint returns s laid out as by Fprint.
+func Sprint(s *Sexp, opts *PrintOptions) string {
+	var buf bytes.Buffer
+	_ = Fprint(&buf, s, opts)
+	return buf.String()
+}
+
+func (opts *PrintOptions) layout(s *Sexp) doc {
+	d := opts.layoutExpr(s)
+	if len(s.LeadingComments) == 0 && len(s.TrailingComments) == 0 {
+		return d
+	}
+
+	result := docConcat{}
+	for i, c := range s.LeadingComments {
+		result = append(result, docText(c.Value), hardLine)
+		next := s.Start
+		if i+1 < len(s.LeadingComments) {
+			next = s.LeadingComments[i+1].Start
+		}
+		if hasBlankLine(c.End, next) {
+			result = append(result, hardLine)
+		}
+	}
+	result = append(result, d)
+	for _, c := range s.TrailingComments {
+		result = append(result, docText(" "), docText(c.Value))
+	}
+	if len(s.TrailingComments) > 0 {
+		// nothing may follow a comment on the same line
+		result = append(result, breakParent)
+	}
+	return result
+}
+
+func (opts *PrintOptions) layoutExpr(s *Sexp) doc {
+	if s.IsAtom() {
+		return docText(s.Atom.Value)
+	}
+	if s.Len() == 0 && len(s.InnerComments) == 0 {
+		return docText("()")
+	}
+
+	name, ok := s.Head().Symbol()
+	if !ok {
+		return opts.layoutData(s)
+	}
+
+	// keep comments around the head symbol where they are
+	if len(s.Head().TrailingComments) > 0 || s.Len() > 1 && len(s.Nth(1).LeadingComments) > 0 {
+		return opts.layoutBody(s, 0)
+	}
+
+	n, ok := opts.Rules[name]
+	if !ok {
+		return opts.layoutCall(s)
+	}
+	if n == IndentLeadingAtoms {
+		n = 0
+		for n < len(s.Tail()) && s.Tail()[n].IsAtom() {
+			n++
+		}
+	}
+	return opts.layoutBody(s, n)
+}
+
+// layoutData lays out a list that does not start with a symbol, aligning all the elements.
+//
+//	((a 1)
+//	 (b 2))
+func (opts *PrintOptions) layoutData(s *Sexp) doc {
+	return &docAlign{content: group(docConcat{
+		docText("("),
+		&docAlign{content: opts.join(s.Children, s.InnerComments)},
+		opts.closeParen(s),
+	})}
+}
+
+// layoutCall lays out a list starting with a symbol, aligning the arguments with the first one.
+//
+//	(assert_return (invoke "add" (i32.const 1) (i32.const 1))
+//	               (i32.const 2))
+func (opts *PrintOptions) layoutCall(s *Sexp) doc {
+	if s.Len() == 1 && len(s.InnerComments) == 0 {
+		return docConcat{docText("("), opts.layout(s.Head()), docText(")")}
+	}
+	return &docAlign{content: group(docConcat{
+		docText("("),
+		opts.layout(s.Head()),
+		docText(" "),
+		&docAlign{content: opts.join(s.Tail(), s.InnerComments)},
+		opts.closeParen(s),
+	})}
+}
+
+// layoutBody lays out a list with n distinguished arguments followed by a body.
+//
+//	(defun square (x)
+//	  (* x x))
+func (opts *PrintOptions) layoutBody(s *Sexp, n int) doc {
+	args := s.Tail()
+	if n > len(args) {
+		n = len(args)
+	}
+
+	// the first distinguished argument always stays on the line of the head symbol.
+	head := docConcat{opts.layout(s.Head())}
+	for i, arg := range args[:n] {
+		if i == 0 {
+			head = append(head, docText(" "), opts.layout(arg))
+			continue
+		}
+		head = append(head, &docNest{indent: 2 * opts.Indent, content: docConcat{opts.separator(args[i-1], arg), opts.layout(arg)}})
+	}
+
+	d := docConcat{docText("("), group(head)}
+	if len(args) > n || len(s.InnerComments) > 0 {
+		d = append(d, &docNest{indent: opts.Indent, content: docConcat{softLine, opts.join(args[n:], s.InnerComments)}})
+	}
+	d = append(d, opts.closeParen(s))
+	return &docAlign{content: group(d)}
+}
+
+// closeParen puts the close paren of s on a line of its own if it would otherwise follow a comment.
+func (opts *PrintOptions) closeParen(s *Sexp) doc {
+	last := s.Nth(s.Len() - 1)
+	if len(s.InnerComments) > 0 || last != nil && len(last.TrailingComments) > 0 {
+		return docConcat{hardLine, docText(")")}
+	}
+	return docText(")")
+}
+
+// separator returns the line between two consecutive elements, keeping a blank line
+// between them if there was one in the input.
+func (opts *PrintOptions) separator(prev, next *Sexp) doc {
+	if hasBlankLine(lastPosition(prev), firstPosition(next)) {
+		return docConcat{hardLine, hardLine}
+	}
+	return softLine
+}
+
+// join separates elements and the comments after them by lines. Runs of atoms
+// without comments are filled so that they are broken only where they do not fit.
+func (opts *PrintOptions) join(elems []*Sexp, comments []*Token) doc {
+	d := docConcat{}
+	atoms := docFill{}
+	flush := func() {
+		switch len(atoms) {
+		case 0:
+		case 1:
+			d = append(d, atoms[0])
+		default:
+			d = append(d, atoms)
+		}
+		atoms = docFill{}
+	}
+
+	for i, e := range elems {
+		if i > 0 {
+			blank := hasBlankLine(lastPosition(elems[i-1]), firstPosition(e))
+			if len(atoms) > 0 && !blank && isPlainAtom(e) {
+				atoms = append(atoms, softLine, opts.layout(e))
+				continue
+			}
+			flush()
+			d = append(d, opts.separator(elems[i-1], e))
+		}
+		if isPlainAtom(e) {
+			atoms = append(atoms, opts.layout(e))
+			continue
+		}
+		d = append(d, opts.layout(e))
+	}
+	flush()
+
+	prev := Position{}
+	if len(elems) > 0 {
+		prev = lastPosition(elems[len(elems)-1])
+	}
+	for i, c := range comments {
+		if i > 0 || len(elems) > 0 {
+			d = append(d, hardLine)
+			if hasBlankLine(prev, c.Start) {
+				d = append(d, hardLine)
+			}
+		}
+		d = append(d, docText(c.Value))
+		prev = c.End
+	}
+	return d
+}
+
+func isPlainAtom(s *Sexp) bool {
+	return s.IsAtom() && len(s.LeadingComments) == 0 && len(s.TrailingComments) == 0
+}
+
+// firstPosition returns the start of s including the comments before it.
+func firstPosition(s *Sexp) Position {
+	if len(s.LeadingComments) > 0 {
+		return s.LeadingComments[0].Start
+	}
+	return s.Start
+}
+
+// lastPosition returns the end of s including the comments after it.
+func lastPosition(s *Sexp) Position {
+	if len(s.TrailingComments) > 0 {
+		return s.TrailingComments[len(s.TrailingComments)-1].End
+	}
+	return s.End
+}
+
+// hasBlankLine reports whether there is an empty line between two positions in the input.
+func hasBlankLine(end, start Position) bool {
+	return end.IsValid() && start.IsValid() && start.Line > end.Line+1
+}
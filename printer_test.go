@@ -0,0 +1,208 @@
+package sexp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestSprint(t *testing.T) {
+	opts := &PrintOptions{Width: 40, Indent: 2, Rules: DefaultPrintOptions.Rules}
+
+	testData := []struct {
+		Name     string
+		Pattern  string
+		Options  *PrintOptions
+		Expected string
+	}{
+		{
+			Name:     "pattern 1 - atom",
+			Pattern:  `a`,
+			Options:  opts,
+			Expected: `a`,
+		},
+		{
+			Name:     "pattern 2 - fits",
+			Pattern:  `(a (b  c)   "d")`,
+			Options:  opts,
+			Expected: `(a (b c) "d")`,
+		},
+		{
+			Name:    "pattern 3 - call",
+			Pattern: `(assert_return (invoke "add" (i32.const 1) (i32.const 1)) (i32.const 2))`,
+			Options: opts,
+			Expected: `(assert_return (invoke "add"
+                       (i32.const 1)
+                       (i32.const 1))
+               (i32.const 2))`,
+		},
+		{
+			Name:    "pattern 4 - wat module",
+			Pattern: `(module $m (func $add (param $a i32) (param $b i32) (result i32) local.get $a local.get $b i32.add) (memory 1) (export "add" (func $add)))`,
+			Options: opts,
+			Expected: `(module $m
+  (func $add
+    (param $a i32)
+    (param $b i32)
+    (result i32)
+    local.get $a local.get $b i32.add)
+  (memory 1)
+  (export "add" (func $add)))`,
+		},
+		{
+			Name:    "pattern 5 - defun",
+			Pattern: `(defun fact (n) (if (<= n 1) 1 (* n (fact (- n 1)))))`,
+			Options: opts,
+			Expected: `(defun fact (n)
+  (if (<= n 1) 1 (* n (fact (- n 1)))))`,
+		},
+		{
+			Name:    "pattern 6 - let",
+			Pattern: `(let ((x 1) (y 2) (long-variable-name 3)) (+ x y long-variable-name))`,
+			Options: opts,
+			Expected: `(let ((x 1)
+      (y 2)
+      (long-variable-name 3))
+  (+ x y long-variable-name))`,
+		},
+		{
+			Name:    "pattern 7 - data",
+			Pattern: `((alpha 1) (beta 2) (gamma 3) (delta 4) (epsilon 5))`,
+			Options: opts,
+			Expected: `((alpha 1)
+ (beta 2)
+ (gamma 3)
+ (delta 4)
+ (epsilon 5))`,
+		},
+		{
+			Name:    "pattern 8 - fill atoms",
+			Pattern: `(data "aaaaaaaaaa" "bbbbbbbbbb" "cccccccccc" "dddddddddd")`,
+			Options: opts,
+			Expected: `(data "aaaaaaaaaa" "bbbbbbbbbb"
+      "cccccccccc" "dddddddddd")`,
+		},
+		{
+			Name:    "pattern 9 - indent 4",
+			Pattern: `(module (func $f (result i32) (i32.const 42)))`,
+			Options: &PrintOptions{Width: 20, Indent: 4, Rules: DefaultPrintOptions.Rules},
+			Expected: `(module
+    (func $f
+        (result i32)
+        (i32.const 42)))`,
+		},
+		{
+			Name:    "pattern 10 - custom rule",
+			Pattern: `(with-open-file (s "path") (read s) (close s))`,
+			Options: &PrintOptions{Width: 30, Indent: 2, Rules: map[string]int{"with-open-file": 1}},
+			Expected: `(with-open-file (s "path")
+  (read s)
+  (close s))`,
+		},
+		{
+			Name:     "pattern 11 - empty list",
+			Pattern:  `(a () (b))`,
+			Options:  nil,
+			Expected: `(a () (b))`,
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			a := Sprint(MustParse(data.Pattern), data.Options)
+			if data.Expected != a {
+				t.Fatalf("\n%s", pretty.Compare(data.Expected, a))
+			}
+		})
+	}
+}
+
+func TestSprintRoundTrip(t *testing.T) {
+	src := `(module (type $t (func (param i32 i32) (result i32))) (func $add (type $t) (param $a i32) (param $b i32) (result i32) (i32.add (local.get $a) (local.get $b))) (export "add" (func $add)))`
+	for _, width := range []int{0, 10, 40, 80, 200} {
+		a := Sprint(MustParse(src), &PrintOptions{Width: width, Indent: 2, Rules: DefaultPrintOptions.Rules})
+		if MustParse(a).String() != src {
+			t.Fatalf("width %d: the printed expression does not parse back:\n%s", width, a)
+		}
+	}
+}
+
+func TestSprintComments(t *testing.T) {
+	opts := &PrintOptions{Width: 40, Indent: 2, Rules: DefaultPrintOptions.Rules}
+
+	testData := []struct {
+		Name     string
+		Pattern  string
+		Expected string
+	}{
+		{
+			Name:    "pattern 1 - leading and trailing",
+			Pattern: ";; leading\n(a b) ;; trailing",
+			Expected: `;; leading
+(a b) ;; trailing`,
+		},
+		{
+			Name: "pattern 2 - comments in a body",
+			Pattern: `(module ;; the module
+  ;; a function
+  (func $f (param i32) ;; the parameter
+    (drop (local.get 0)))
+  ;; the end
+)`,
+			Expected: `(module ;; the module
+  ;; a function
+  (func $f
+    (param i32) ;; the parameter
+    (drop (local.get 0)))
+  ;; the end
+)`,
+		},
+		{
+			Name:    "pattern 3 - trailing comment on the last element",
+			Pattern: "(a b ;; c\n)",
+			Expected: `(a b ;; c
+)`,
+		},
+		{
+			Name:    "pattern 4 - blank lines",
+			Pattern: "(module\n\n\n  (memory 1)\n\n  ;; f\n\n  (func $f))",
+			Expected: `(module
+  (memory 1)
+
+  ;; f
+
+  (func $f))`,
+		},
+		{
+			Name:    "pattern 5 - block comment",
+			Pattern: "(a (; b ;) c)",
+			Expected: `(a (; b ;)
+  c)`,
+		},
+		{
+			Name:     "pattern 6 - only comments",
+			Pattern:  "( ;; a\n)",
+			Expected: "(;; a\n)",
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			s, err := NewDecoder(strings.NewReader(data.Pattern), KeepComments()).Decode()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			a := Sprint(s, opts)
+			if data.Expected != a {
+				t.Fatalf("\n%s", pretty.Compare(data.Expected, a))
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package sexp
+
+import (
+	"io"
+	"reflect"
+)
+
+// RawSexp is the original text of an expression. Unmarshal stores the bytes of an
+// expression in a RawSexp as they are in the input, so that decoding it can be
+// deferred, and Marshal writes it as it is.
+type RawSexp []byte
+
+var rawSexpType = reflect.TypeOf(RawSexp(nil))
+
+// MarshalSexp returns m, or nil if m is empty.
+func (m RawSexp) MarshalSexp() ([]byte, error) {
+	if len(m) == 0 {
+		return []byte("nil"), nil
+	}
+	return m, nil
+}
+
+// Sexp parses m.
+func (m RawSexp) Sexp() (*Sexp, error) {
+	return ParseStrict(string(m))
+}
+
+// decodeRaw stores the text of s in v. Without the source, s is written as by String.
+func (d *decodeState) decodeRaw(s *Sexp, v reflect.Value) {
+	var b []byte
+	start, end := s.Start.Offset-d.base, s.End.Offset-d.base
+	if d.src != nil && s.Start.IsValid() && start >= 0 && end <= len(d.src) {
+		b = append(b, d.src[start:end]...)
+	} else {
+		b = []byte(s.String())
+	}
+	v.SetBytes(b)
+}
+
+// sourceBuffer keeps the input read through it from the given offset on, so that
+// the Decoder can return the original text of the expression just decoded.
+type sourceBuffer struct {
+	r      io.Reader
+	buf    []byte
+	offset int // the input offset of buf[0]
+}
+
+func (b *sourceBuffer) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.buf = append(b.buf, p[:n]...)
+	return n, err
+}
+
+// text returns the input between two offsets, which must not have been discarded.
+func (b *sourceBuffer) text(start, end int) []byte {
+	return b.buf[start-b.offset : end-b.offset]
+}
+
+// discard drops the input before the given offset.
+func (b *sourceBuffer) discard(offset int) {
+	n := offset - b.offset
+	if n <= 0 {
+		return
+	}
+	b.buf = append(b.buf[:0], b.buf[n:]...)
+	b.offset = offset
+}
@@ -0,0 +1,88 @@
+package sexp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestUnmarshalRawSexp(t *testing.T) {
+	var v struct {
+		Name string  `sexp:"name"`
+		Body RawSexp `sexp:"body"`
+	}
+	src := `((name "f") (body (func $f   ;; keep this
+    (nop))))`
+	err := Unmarshal([]byte(src), &v)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	expected := "(func $f   ;; keep this\n    (nop))"
+	if expected != string(v.Body) {
+		t.Fatalf("\n%s", pretty.Compare(expected, string(v.Body)))
+	}
+
+	b, err := Marshal(v)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	expected = `((name "f") (body (func $f (nop))))`
+	if expected != string(b) {
+		t.Fatalf("\n%s", pretty.Compare(expected, string(b)))
+	}
+}
+
+func TestDecoderRawSexp(t *testing.T) {
+	src := `(module (func))
+(assert_return (invoke "f") (i32.const 1))
+(assert_trap  (invoke "g")  "unreachable")
+`
+	dec := NewDecoder(strings.NewReader(src))
+	heads := []string{}
+	for dec.More() {
+		var raw RawSexp
+		err := dec.DecodeValue(&raw)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+		s, err := raw.Sexp()
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+		head, _ := s.Head().Symbol()
+		heads = append(heads, head)
+
+		if head == "assert_trap" {
+			var a []RawSexp
+			err = Unmarshal(raw, &a)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if string(a[1]) != `(invoke "g")` || string(a[2]) != `"unreachable"` {
+				t.Fatalf("unexpected elements: %q", a)
+			}
+		}
+	}
+
+	expected := []string{"module", "assert_return", "assert_trap"}
+	if diff := pretty.Compare(expected, heads); diff != "" {
+		t.Fatalf("\n%s", diff)
+	}
+}
+
+func TestDecoderSourceMemory(t *testing.T) {
+	const n = 1000
+	r := strings.NewReader(strings.Repeat("(assert_return (invoke \"add\" (i32.const 1) (i32.const 1)) (i32.const 2))\n", n))
+	dec := NewDecoder(r)
+	for dec.More() {
+		var raw RawSexp
+		err := dec.DecodeValue(&raw)
+		if err != nil {
+			t.Fatalf("unexpected error: %+v", err)
+		}
+		if len(dec.src.buf) > 2*4096 {
+			t.Fatalf("expected the decoded input to be dropped, but %d bytes are kept", len(dec.src.buf))
+		}
+	}
+}
@@ -2,19 +2,58 @@ package sexp
 
 import (
 	"fmt"
+	"io"
 	"strings"
-
-	"github.com/pkg/errors"
 )
 
 type Sexp struct {
 	Atom     *Token
 	Children []*Sexp
+	Start    Position // position of the atom or the open paren
+	End      Position // position just after the atom or the close paren
+
+	// Comments are attached only if the Lexer keeps them.
+	LeadingComments  []*Token // comments before the expression
+	TrailingComments []*Token // comments after the expression on the same line
+	InnerComments    []*Token // comments before the close paren of a list, on lines of their own
 }
 
+// Parse parses the first expression in str. Anything after it is ignored.
 func Parse(str string) (*Sexp, error) {
 	l := NewLexer(strings.NewReader(str))
-	return parse(l)
+	s, err := parseOne(l)
+	if err != nil {
+		return nil, withExcerpt(err, str)
+	}
+	return s, nil
+}
+
+// ParseStrict is like Parse but fails if str contains anything other than
+// white space after the first expression.
+func ParseStrict(str string) (*Sexp, error) {
+	l := NewLexer(strings.NewReader(str))
+	s, err := parseOne(l)
+	if err == nil {
+		err = expectEOF(l)
+	}
+	if err != nil {
+		return nil, withExcerpt(err, str)
+	}
+	return s, nil
+}
+
+// ParseAll parses all the top-level expressions in str.
+func ParseAll(str string) ([]*Sexp, error) {
+	ss, err := parseAll(NewLexer(strings.NewReader(str)))
+	if err != nil {
+		return nil, withExcerpt(err, str)
+	}
+	return ss, nil
+}
+
+// ParseAllReader parses all the top-level expressions read from r.
+func ParseAllReader(r io.Reader) ([]*Sexp, error) {
+	return parseAll(NewLexer(r))
 }
 
 func MustParse(str string) *Sexp {
@@ -25,54 +64,162 @@ func MustParse(str string) *Sexp {
 	return s
 }
 
+func parseOne(l *Lexer) (*Sexp, error) {
+	s, err := parse(l)
+	if err == io.EOF {
+		return nil, l.unexpectedEOF("expression")
+	}
+	return s, err
+}
+
+func parseAll(l *Lexer) ([]*Sexp, error) {
+	ss := []*Sexp{}
+	for {
+		s, err := parse(l)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		s.TrailingComments = append(s.TrailingComments, readTrailingComments(l, s.End.Line)...)
+		ss = append(ss, s)
+	}
+	return ss, nil
+}
+
+// readComments reads the comments before the next token.
+func readComments(l *Lexer) []*Token {
+	var comments []*Token
+	for {
+		token := l.NextToken()
+		if token == nil {
+			return comments
+		}
+		if token.Type != TokenTypeComment {
+			_ = l.Unread()
+			return comments
+		}
+		comments = append(comments, token)
+	}
+}
+
+// readTrailingComments reads the comments that start on the given line.
+func readTrailingComments(l *Lexer, line int) []*Token {
+	var comments []*Token
+	for {
+		token := l.NextToken()
+		if token == nil {
+			return comments
+		}
+		if token.Type != TokenTypeComment || token.Start.Line != line {
+			_ = l.Unread()
+			return comments
+		}
+		comments = append(comments, token)
+	}
+}
+
+func nextNonComment(l *Lexer) *Token {
+	for {
+		token := l.NextToken()
+		if token == nil || token.Type != TokenTypeComment {
+			return token
+		}
+	}
+}
+
+func expectEOF(l *Lexer) error {
+	token := nextNonComment(l)
+	if token != nil {
+		return l.unexpectedToken(token, "EOF")
+	}
+	return l.Err()
+}
+
+// parse reads a single expression from l, with the comments before it.
+// It returns io.EOF if the input ends before the expression starts.
 func parse(l *Lexer) (*Sexp, error) {
+	comments := readComments(l)
+	s, err := parseExpr(l)
+	if err != nil {
+		return nil, err
+	}
+	s.LeadingComments = comments
+	return s, nil
+}
+
+func parseExpr(l *Lexer) (*Sexp, error) {
 	token := l.NextToken()
 	if token == nil {
-		return nil, nil
+		if l.Err() != nil {
+			return nil, l.Err()
+		}
+		return nil, io.EOF
 	}
 	switch token.Type {
 	case TokenTypeSymbol, TokenTypeString, TokenTypeNumber:
-		return &Sexp{Atom: token}, nil
+		return newAtom(token), nil
 	}
 
 	if token.Type != TokenTypeOpenParen {
-		return nil, errors.Errorf("expected open paren, but found %s", token.Type.String())
+		return nil, l.unexpectedToken(token, "expression")
+	}
+	list := &Sexp{
+		Children: []*Sexp{},
+		Start:    token.Start,
 	}
 
-	children := []*Sexp{}
-	closed := false
-
-loop:
+	var comments []*Token
+	var last *Sexp
 	for {
 		token = l.NextToken()
 		if token == nil {
-			break
+			if l.Err() != nil {
+				return nil, l.Err()
+			}
+			return nil, l.unexpectedEOF(`")"`)
 		}
 
+		var child *Sexp
 		switch token.Type {
 		case TokenTypeOpenParen:
 			err := l.Unread()
 			if err != nil {
 				return nil, err
 			}
-			s, err := parse(l)
+			child, err = parseExpr(l)
 			if err != nil {
 				return nil, err
 			}
-			children = append(children, s)
 		case TokenTypeSymbol, TokenTypeString, TokenTypeNumber:
-			children = append(children, &Sexp{Atom: token})
+			child = newAtom(token)
 		case TokenTypeCloseParen:
-			closed = true
-			break loop
+			list.InnerComments = comments
+			list.End = token.End
+			return list, nil
+		case TokenTypeComment:
+			if last != nil && comments == nil && token.Start.Line == last.End.Line {
+				last.TrailingComments = append(last.TrailingComments, token)
+			} else {
+				comments = append(comments, token)
+			}
+			continue
 		}
-	}
 
-	if !closed {
-		return nil, nil
+		child.LeadingComments = comments
+		comments = nil
+		list.Children = append(list.Children, child)
+		last = child
 	}
+}
 
-	return &Sexp{Children: children}, nil
+func newAtom(token *Token) *Sexp {
+	return &Sexp{
+		Atom:  token,
+		Start: token.Start,
+		End:   token.End,
+	}
 }
 
 func (s *Sexp) String() string {
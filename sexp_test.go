@@ -2,6 +2,7 @@ package sexp
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/kylelemons/godebug/pretty"
@@ -60,21 +61,6 @@ func TestParse(t *testing.T) {
 				},
 			},
 		},
-		{
-			Name:     "pattern 5 - no parens",
-			Pattern:  "",
-			Expected: nil,
-		},
-		{
-			Name:     "pattern 6 - incomplete",
-			Pattern:  "(a",
-			Expected: nil,
-		},
-		{
-			Name:     "pattern 7 - incomplete, nested",
-			Pattern:  "(a (b) (",
-			Expected: nil,
-		},
 		{
 			Name:    "pattern - wast 1",
 			Pattern: `(assert_return (invoke "add" (i32.const 1) (i32.const 1)) (i32.const 2))`,
@@ -158,6 +144,7 @@ func TestParse(t *testing.T) {
 			if err != nil {
 				t.Fatalf("unexpected error: %+v", err)
 			}
+			a = stripPositions(a)
 			if !reflect.DeepEqual(data.Expected, a) {
 				t.Fatalf("\n%s", pretty.Compare(data.Expected, a))
 			}
@@ -165,6 +152,282 @@ func TestParse(t *testing.T) {
 	}
 }
 
+func TestParseError(t *testing.T) {
+	testData := []struct {
+		Name     string
+		Pattern  string
+		Expected *SyntaxError
+	}{
+		{
+			Name:    "pattern 1 - no parens",
+			Pattern: "",
+			Expected: &SyntaxError{
+				Pos:      Position{0, 1, 1},
+				Expected: "expression",
+				Found:    "EOF",
+			},
+		},
+		{
+			Name:    "pattern 2 - incomplete",
+			Pattern: "(a",
+			Expected: &SyntaxError{
+				Pos:      Position{2, 1, 3},
+				Expected: `")"`,
+				Found:    "EOF",
+				Excerpt:  "(a",
+			},
+		},
+		{
+			Name:    "pattern 3 - incomplete, nested",
+			Pattern: "(a (b) (",
+			Expected: &SyntaxError{
+				Pos:      Position{8, 1, 9},
+				Expected: `")"`,
+				Found:    "EOF",
+				Excerpt:  "(a (b) (",
+			},
+		},
+		{
+			Name:    "pattern 4 - close paren",
+			Pattern: "\n  ) (a)",
+			Expected: &SyntaxError{
+				Pos:      Position{3, 2, 3},
+				Expected: "expression",
+				Found:    `")"`,
+				Excerpt:  "  ) (a)",
+			},
+		},
+		{
+			Name:    "pattern 5 - unterminated string",
+			Pattern: `(a "bc)`,
+			Expected: &SyntaxError{
+				Pos:     Position{3, 1, 4},
+				Msg:     "string literal not terminated",
+				Excerpt: `(a "bc)`,
+			},
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			a, err := Parse(data.Pattern)
+			if err == nil {
+				t.Fatalf("expected an error, but got %s", a)
+			}
+			if !reflect.DeepEqual(data.Expected, err) {
+				t.Fatalf("\n%s", pretty.Compare(data.Expected, err))
+			}
+		})
+	}
+}
+
+func TestSyntaxErrorString(t *testing.T) {
+	_, err := Parse("(module\n  (func $f (param i32)\n")
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	expected := `3:1: expected ")", but found EOF`
+	if expected != err.Error() {
+		t.Fatalf("\nExpected: %s\nActual:   %s", expected, err.Error())
+	}
+
+	_, err = Parse(`(a "b`)
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	expected = `1:4: string literal not terminated: "(a \"b"`
+	if expected != err.Error() {
+		t.Fatalf("\nExpected: %s\nActual:   %s", expected, err.Error())
+	}
+}
+
+func TestParseAll(t *testing.T) {
+	testData := []struct {
+		Name     string
+		Pattern  string
+		Expected []string
+	}{
+		{
+			Name:     "pattern 1 - empty",
+			Pattern:  "",
+			Expected: []string{},
+		},
+		{
+			Name:     "pattern 2 - white space only",
+			Pattern:  " \n\t ",
+			Expected: []string{},
+		},
+		{
+			Name:     "pattern 3 - single",
+			Pattern:  "(a b)",
+			Expected: []string{"(a b)"},
+		},
+		{
+			Name: "pattern 4 - wast",
+			Pattern: `(module (func (export "add") (param i32 i32) (result i32)))
+(assert_return (invoke "add" (i32.const 1) (i32.const 1)) (i32.const 2))
+(assert_trap (invoke "div_s" (i32.const 1) (i32.const 0)) "integer divide by zero")
+`,
+			Expected: []string{
+				`(module (func (export "add") (param i32 i32) (result i32)))`,
+				`(assert_return (invoke "add" (i32.const 1) (i32.const 1)) (i32.const 2))`,
+				`(assert_trap (invoke "div_s" (i32.const 1) (i32.const 0)) "integer divide by zero")`,
+			},
+		},
+		{
+			Name:     "pattern 5 - atoms",
+			Pattern:  `a "b" 1 (c)`,
+			Expected: []string{"a", `"b"`, "1", "(c)"},
+		},
+		{
+			Name:     "pattern 6 - comments",
+			Pattern:  "(a ;; x\n b) (; c ;) (d (; e ;))\n;; f",
+			Expected: []string{"(a b)", "(d)"},
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			ss, err := ParseAll(data.Pattern)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			a := []string{}
+			for _, s := range ss {
+				a = append(a, s.String())
+			}
+			if !reflect.DeepEqual(data.Expected, a) {
+				t.Fatalf("\n%s", pretty.Compare(data.Expected, a))
+			}
+
+			ss, err = ParseAllReader(strings.NewReader(data.Pattern))
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if len(data.Expected) != len(ss) {
+				t.Fatalf("expected %d expressions, but got %d", len(data.Expected), len(ss))
+			}
+		})
+	}
+}
+
+func TestParseAllError(t *testing.T) {
+	_, err := ParseAll("(a)\n(b\n")
+	if err == nil {
+		t.Fatalf("expected an error")
+	}
+	expected := `3:1: expected ")", but found EOF`
+	if expected != err.Error() {
+		t.Fatalf("\nExpected: %s\nActual:   %s", expected, err.Error())
+	}
+}
+
+func TestParseStrict(t *testing.T) {
+	testData := []struct {
+		Name          string
+		Pattern       string
+		ExpectedError string
+	}{
+		{
+			Name:    "pattern 1 - single",
+			Pattern: "(a b)",
+		},
+		{
+			Name:    "pattern 2 - trailing white space",
+			Pattern: "(a b)  \n",
+		},
+		{
+			Name:          "pattern 3 - trailing atom",
+			Pattern:       "(a b) c",
+			ExpectedError: `1:7: expected EOF, but found "c": "(a b) c"`,
+		},
+		{
+			Name:          "pattern 4 - trailing close paren",
+			Pattern:       "(a b))",
+			ExpectedError: `1:6: expected EOF, but found ")": "(a b))"`,
+		},
+		{
+			Name:          "pattern 5 - trailing broken string",
+			Pattern:       `(a b) "c`,
+			ExpectedError: `1:7: string literal not terminated: "(a b) \"c"`,
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			_, err := ParseStrict(data.Pattern)
+			if data.ExpectedError == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %+v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if data.ExpectedError != err.Error() {
+				t.Fatalf("\nExpected: %s\nActual:   %s", data.ExpectedError, err.Error())
+			}
+		})
+	}
+}
+
+func TestParsePosition(t *testing.T) {
+	s, err := Parse("(a\n  (b \"c\"))")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	testData := []struct {
+		Name          string
+		Sexp          *Sexp
+		ExpectedStart Position
+		ExpectedEnd   Position
+	}{
+		{Name: "root", Sexp: s, ExpectedStart: Position{0, 1, 1}, ExpectedEnd: Position{13, 2, 11}},
+		{Name: "atom a", Sexp: s.Children[0], ExpectedStart: Position{1, 1, 2}, ExpectedEnd: Position{2, 1, 3}},
+		{Name: "list b", Sexp: s.Children[1], ExpectedStart: Position{5, 2, 3}, ExpectedEnd: Position{12, 2, 10}},
+		{Name: "atom c", Sexp: s.Children[1].Children[1], ExpectedStart: Position{8, 2, 6}, ExpectedEnd: Position{11, 2, 9}},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			if data.ExpectedStart != data.Sexp.Start {
+				t.Fatalf("\nExpected start: %s\nActual start:   %s", data.ExpectedStart, data.Sexp.Start)
+			}
+			if data.ExpectedEnd != data.Sexp.End {
+				t.Fatalf("\nExpected end: %s\nActual end:   %s", data.ExpectedEnd, data.Sexp.End)
+			}
+		})
+	}
+}
+
+func stripPositions(s *Sexp) *Sexp {
+	if s == nil {
+		return nil
+	}
+	if s.Atom != nil {
+		return &Sexp{Atom: &Token{Type: s.Atom.Type, Value: s.Atom.Value}}
+	}
+	children := make([]*Sexp, 0, len(s.Children))
+	for _, c := range s.Children {
+		children = append(children, stripPositions(c))
+	}
+	return &Sexp{Children: children}
+}
+
 func TestSexpString(t *testing.T) {
 	testData := []struct {
 		Name     string
@@ -304,3 +567,31 @@ func TestSexpString(t *testing.T) {
 		})
 	}
 }
+
+func TestParseComments(t *testing.T) {
+	src := ";; a\n(b ;; c\n  ;; d\n  e\n  ;; f\n) ;; g"
+	ss, err := parseAll(NewLexer(strings.NewReader(src), KeepComments()))
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	values := func(tokens []*Token) []string {
+		vs := []string{}
+		for _, t := range tokens {
+			vs = append(vs, t.Value)
+		}
+		return vs
+	}
+
+	s := ss[0]
+	a := [][]string{
+		values(s.LeadingComments),
+		values(s.TrailingComments),
+		values(s.InnerComments),
+		values(s.Nth(0).TrailingComments),
+		values(s.Nth(1).LeadingComments),
+	}
+	expected := [][]string{{";; a"}, {";; g"}, {";; f"}, {";; c"}, {";; d"}}
+	if !reflect.DeepEqual(expected, a) {
+		t.Fatalf("\n%s", pretty.Compare(expected, a))
+	}
+}
@@ -3,4 +3,6 @@ package sexp
 type Token struct {
 	Type  TokenType
 	Value string
+	Start Position // position of the first rune of the token
+	End   Position // position just after the last rune of the token
 }
@@ -9,4 +9,5 @@ const (
 	TokenTypeSymbol
 	TokenTypeNumber
 	TokenTypeString
+	TokenTypeComment
 )
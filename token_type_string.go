@@ -13,11 +13,12 @@ func _() {
 	_ = x[TokenTypeSymbol-2]
 	_ = x[TokenTypeNumber-3]
 	_ = x[TokenTypeString-4]
+	_ = x[TokenTypeComment-5]
 }
 
-const _TokenType_name = "TokenTypeOpenParenTokenTypeCloseParenTokenTypeSymbolTokenTypeNumberTokenTypeString"
+const _TokenType_name = "TokenTypeOpenParenTokenTypeCloseParenTokenTypeSymbolTokenTypeNumberTokenTypeStringTokenTypeComment"
 
-var _TokenType_index = [...]uint8{0, 18, 37, 52, 67, 82}
+var _TokenType_index = [...]uint8{0, 18, 37, 52, 67, 82, 98}
 
 func (i TokenType) String() string {
 	if i < 0 || i >= TokenType(len(_TokenType_index)-1) {
@@ -0,0 +1,563 @@
+package sexp
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Unmarshaler is implemented by types that read themselves from an S-expression.
+type Unmarshaler interface {
+	UnmarshalSexp(s *Sexp) error
+}
+
+// UnmarshalTypeError describes an S-expression that does not fit the Go type it is read into.
+type UnmarshalTypeError struct {
+	Value  string // a description of the expression, such as "number 300"
+	Type   reflect.Type
+	Pos    Position
+	Struct string // the name of the struct containing the field, if any
+	Field  string // the name of the field, if any
+}
+
+func (e *UnmarshalTypeError) Error() string {
+	if e.Struct != "" || e.Field != "" {
+		return fmt.Sprintf("%s: cannot unmarshal %s into Go struct field %s.%s of type %s", e.Pos, e.Value, e.Struct, e.Field, e.Type)
+	}
+	return fmt.Sprintf("%s: cannot unmarshal %s into Go value of type %s", e.Pos, e.Value, e.Type)
+}
+
+// UnknownFieldError describes a key that matches no struct field, which is an error
+// after Decoder.DisallowUnknownFields.
+type UnknownFieldError struct {
+	Field string // the name of the key
+	Type  reflect.Type
+	Pos   Position
+}
+
+func (e *UnknownFieldError) Error() string {
+	return fmt.Sprintf("%s: unknown field %q in Go value of type %s", e.Pos, e.Field, e.Type)
+}
+
+// InvalidUnmarshalError describes an invalid argument passed to Unmarshal.
+type InvalidUnmarshalError struct {
+	Type reflect.Type
+}
+
+func (e *InvalidUnmarshalError) Error() string {
+	if e.Type == nil {
+		return "sexp: Unmarshal(nil)"
+	}
+	if e.Type.Kind() != reflect.Ptr {
+		return "sexp: Unmarshal(non-pointer " + e.Type.String() + ")"
+	}
+	return "sexp: Unmarshal(nil " + e.Type.String() + ")"
+}
+
+// Unmarshal reads the single S-expression in data into the value pointed to by v.
+//
+// It is the inverse of Marshal. In addition, maps and structs are read from
+//
+//   - association lists with an optional head symbol: (server (host "x") (port 8080))
+//   - property lists: (:host "x" :port 8080), with keywords, symbols, strings or
+//     numbers as keys
+//
+// Keys are matched to struct fields by name, preferring an exact match but also
+// accepting a case-insensitive one. Numbers are converted with the Number model and
+// must fit the Go type exactly. Symbols are accepted for strings, and the symbol nil
+// for pointers, interfaces, slices and maps. Into an empty interface, lists are read as
+// []interface{}, strings and other symbols as string, integers as int64, other numbers
+// as float64 and true and false as bool.
+//
+// Types implementing Unmarshaler read themselves, and types implementing
+// encoding.TextUnmarshaler, such as time.Time, are read from strings and symbols.
+// A RawSexp receives the text of the expression.
+func Unmarshal(data []byte, v interface{}) error {
+	src := string(data)
+	s, err := ParseStrict(src)
+	if err != nil {
+		return err
+	}
+	d := &decodeState{src: data}
+	return withExcerpt(d.unmarshal(s, v), src)
+}
+
+// DisallowUnknownFields makes DecodeValue return an error for keys that match no
+// field of the struct they are read into, an *UnknownFieldError.
+func (dec *Decoder) DisallowUnknownFields() {
+	dec.disallowUnknownFields = true
+}
+
+// DecodeValue reads the next top-level expression into the value pointed to by v,
+// as Unmarshal does. It returns io.EOF when there are no more expressions in the input.
+func (dec *Decoder) DecodeValue(v interface{}) error {
+	s, err := dec.parse()
+	if err != nil {
+		return err
+	}
+	d := &decodeState{
+		disallowUnknownFields: dec.disallowUnknownFields,
+		src:                   dec.src.text(s.Start.Offset, s.End.Offset),
+		base:                  s.Start.Offset,
+	}
+	err = d.unmarshal(s, v)
+	dec.src.discard(s.End.Offset)
+	return err
+}
+
+func (d *decodeState) unmarshal(s *Sexp, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return &InvalidUnmarshalError{Type: reflect.TypeOf(v)}
+	}
+	return d.decode(s, rv.Elem())
+}
+
+type decodeState struct {
+	disallowUnknownFields bool
+
+	// the input, for RawSexp
+	src  []byte
+	base int // the input offset of src[0]
+
+	// the struct field being decoded, for error messages
+	structName string
+	fieldName  string
+}
+
+var (
+	unmarshalerType     = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// describe returns a description of s for error messages.
+func describe(s *Sexp) string {
+	switch {
+	case s.IsList():
+		return "list"
+	case s.IsNumber():
+		return "number " + s.Atom.Value
+	case s.IsString():
+		return "string " + s.Atom.Value
+	}
+	return "symbol " + s.Atom.Value
+}
+
+func (d *decodeState) typeError(s *Sexp, t reflect.Type) error {
+	return &UnmarshalTypeError{
+		Value:  describe(s),
+		Type:   t,
+		Pos:    s.Start,
+		Struct: d.structName,
+		Field:  d.fieldName,
+	}
+}
+
+func isNil(s *Sexp) bool {
+	return s.IsSymbol("nil")
+}
+
+func (d *decodeState) decode(s *Sexp, v reflect.Value) error {
+	if v.Type() == rawSexpType {
+		d.decodeRaw(s, v)
+		return nil
+	}
+
+	if isNil(s) {
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Interface, reflect.Slice, reflect.Map:
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+	}
+
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		if v.Type().Implements(unmarshalerType) {
+			return v.Interface().(Unmarshaler).UnmarshalSexp(s)
+		}
+		return d.decode(s, v.Elem())
+	}
+
+	if v.CanAddr() {
+		pv := v.Addr()
+		if pv.Type().Implements(unmarshalerType) {
+			return pv.Interface().(Unmarshaler).UnmarshalSexp(s)
+		}
+		if pv.Type().Implements(textUnmarshalerType) {
+			return d.decodeText(s, v, pv.Interface().(encoding.TextUnmarshaler))
+		}
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		switch {
+		case s.IsSymbol("true", "#t"):
+			v.SetBool(true)
+		case s.IsSymbol("false", "#f"):
+			v.SetBool(false)
+		default:
+			return d.typeError(s, v.Type())
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := s.Number()
+		if !ok {
+			return d.typeError(s, v.Type())
+		}
+		i, err := n.Int64()
+		if err != nil || v.OverflowInt(i) {
+			return d.typeError(s, v.Type())
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, ok := s.Number()
+		if !ok {
+			return d.typeError(s, v.Type())
+		}
+		u, err := n.Uint64()
+		if err != nil || v.OverflowUint(u) {
+			return d.typeError(s, v.Type())
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		n, ok := s.Number()
+		if !ok {
+			return d.typeError(s, v.Type())
+		}
+		f, err := n.Float64()
+		if err != nil || v.OverflowFloat(f) {
+			return d.typeError(s, v.Type())
+		}
+		v.SetFloat(f)
+	case reflect.String:
+		b, err := d.text(s, v.Type())
+		if err != nil {
+			return err
+		}
+		v.SetString(string(b))
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 && s.IsString() {
+			b, err := s.Atom.Bytes()
+			if err != nil {
+				return err
+			}
+			v.SetBytes(b)
+			return nil
+		}
+		return d.decodeSlice(s, v)
+	case reflect.Array:
+		return d.decodeArray(s, v)
+	case reflect.Map:
+		return d.decodeMap(s, v)
+	case reflect.Struct:
+		return d.decodeStruct(s, v)
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return d.typeError(s, v.Type())
+		}
+		x, err := d.decodeInterface(s)
+		if err != nil {
+			return err
+		}
+		if x == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(x))
+		}
+	default:
+		return d.typeError(s, v.Type())
+	}
+	return nil
+}
+
+// text returns the decoded value of a string or the name of a symbol.
+func (d *decodeState) text(s *Sexp, t reflect.Type) ([]byte, error) {
+	switch {
+	case s.IsString():
+		return s.Atom.Bytes()
+	case s.IsSymbol():
+		return []byte(s.Atom.Value), nil
+	}
+	return nil, d.typeError(s, t)
+}
+
+func (d *decodeState) decodeText(s *Sexp, v reflect.Value, u encoding.TextUnmarshaler) error {
+	b, err := d.text(s, v.Type())
+	if err != nil {
+		return err
+	}
+	return u.UnmarshalText(b)
+}
+
+func (d *decodeState) decodeSlice(s *Sexp, v reflect.Value) error {
+	if !s.IsList() {
+		return d.typeError(s, v.Type())
+	}
+	slice := reflect.MakeSlice(v.Type(), len(s.Children), len(s.Children))
+	for i, c := range s.Children {
+		err := d.decode(c, slice.Index(i))
+		if err != nil {
+			return err
+		}
+	}
+	v.Set(slice)
+	return nil
+}
+
+// decodeArray reads a list into an array. Extra elements are ignored and
+// missing elements are set to the zero value.
+func (d *decodeState) decodeArray(s *Sexp, v reflect.Value) error {
+	if !s.IsList() {
+		return d.typeError(s, v.Type())
+	}
+	for i := 0; i < v.Len(); i++ {
+		if i >= len(s.Children) {
+			v.Index(i).Set(reflect.Zero(v.Type().Elem()))
+			continue
+		}
+		err := d.decode(s.Children[i], v.Index(i))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entry is a key-value pair of an association list or a property list.
+type entry struct {
+	key   *Sexp
+	value *Sexp
+}
+
+// keyName returns the name of a symbol or string key, without the colon of a keyword.
+func keyName(key *Sexp) (string, bool) {
+	if name, ok := key.Symbol(); ok {
+		return strings.TrimPrefix(name, ":"), true
+	}
+	if !key.IsString() {
+		return "", false
+	}
+	b, err := key.Atom.Bytes()
+	if err != nil {
+		return "", false
+	}
+	return string(b), true
+}
+
+func isKeyword(s *Sexp) bool {
+	name, ok := s.Symbol()
+	return ok && strings.HasPrefix(name, ":")
+}
+
+// isPlist reports whether elems are a property list rather than an association list.
+// Only a symbol can be the head of an association list, so a list starting with a
+// keyword, a string or a number is a property list. One starting with another symbol
+// is a property list if it has an even length and no (key value) entries.
+func isPlist(elems []*Sexp) bool {
+	if len(elems) == 0 {
+		return false
+	}
+	first := elems[0]
+	switch {
+	case first.IsList():
+		return false
+	case isKeyword(first), !first.IsSymbol():
+		return true
+	}
+	if len(elems)%2 != 0 {
+		return false
+	}
+	for _, e := range elems {
+		if e.Len() == 2 {
+			return false
+		}
+	}
+	return true
+}
+
+// entries returns the key-value pairs of an association list or a property list.
+func (d *decodeState) entries(s *Sexp, t reflect.Type) ([]entry, error) {
+	if !s.IsList() {
+		return nil, d.typeError(s, t)
+	}
+
+	elems := s.Children
+	es := []entry{}
+	if isPlist(elems) {
+		if len(elems)%2 != 0 {
+			last := elems[len(elems)-1]
+			return nil, &SyntaxError{Pos: last.End, Expected: "value", Found: `")"`}
+		}
+		for i := 0; i < len(elems); i += 2 {
+			es = append(es, entry{key: elems[i], value: elems[i+1]})
+		}
+		return es, nil
+	}
+
+	if len(elems) > 0 && elems[0].IsSymbol() {
+		// skip the head symbol as in (server (host "x") (port 8080))
+		elems = elems[1:]
+	}
+	for _, e := range elems {
+		if e.Len() != 2 {
+			return nil, &SyntaxError{Pos: e.Start, Expected: "(key value)", Found: describe(e)}
+		}
+		es = append(es, entry{key: e.Children[0], value: e.Children[1]})
+	}
+	return es, nil
+}
+
+func (d *decodeState) decodeMap(s *Sexp, v reflect.Value) error {
+	t := v.Type()
+	switch t.Key().Kind() {
+	case reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+	default:
+		if !reflect.PtrTo(t.Key()).Implements(textUnmarshalerType) {
+			return d.typeError(s, t)
+		}
+	}
+
+	es, err := d.entries(s, t)
+	if err != nil {
+		return err
+	}
+	if v.IsNil() {
+		v.Set(reflect.MakeMap(t))
+	}
+	for _, e := range es {
+		key := reflect.New(t.Key()).Elem()
+		if name, ok := keyName(e.key); ok && t.Key().Kind() == reflect.String {
+			key.SetString(name)
+		} else if ok && reflect.PtrTo(t.Key()).Implements(textUnmarshalerType) {
+			err = key.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(name))
+		} else {
+			err = d.decode(e.key, key)
+		}
+		if err != nil {
+			return err
+		}
+
+		value := reflect.New(t.Elem()).Elem()
+		err = d.decode(e.value, value)
+		if err != nil {
+			return err
+		}
+		v.SetMapIndex(key, value)
+	}
+	return nil
+}
+
+func (d *decodeState) decodeStruct(s *Sexp, v reflect.Value) error {
+	t := v.Type()
+	es, err := d.entries(s, t)
+	if err != nil {
+		return err
+	}
+
+	fields := cachedFields(t)
+	for _, e := range es {
+		name, ok := keyName(e.key)
+		if !ok {
+			return d.typeError(e.key, reflect.TypeOf(""))
+		}
+
+		f := findField(fields, name)
+		if f == nil {
+			if d.disallowUnknownFields {
+				return &UnknownFieldError{Field: name, Type: t, Pos: e.key.Start}
+			}
+			continue
+		}
+
+		fv, err := fieldByIndexAlloc(v, f.index)
+		if err != nil {
+			return err
+		}
+		structName, fieldName := d.structName, d.fieldName
+		d.structName, d.fieldName = t.Name(), f.name
+		err = d.decode(e.value, fv)
+		d.structName, d.fieldName = structName, fieldName
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findField returns the field of the given name, preferring an exact match.
+func findField(fields []field, name string) *field {
+	for i := range fields {
+		if fields[i].name == name {
+			return &fields[i]
+		}
+	}
+	for i := range fields {
+		if strings.EqualFold(fields[i].name, name) {
+			return &fields[i]
+		}
+	}
+	return nil
+}
+
+// fieldByIndexAlloc is like reflect.Value.FieldByIndex but allocates nil embedded pointers.
+func fieldByIndexAlloc(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, fmt.Errorf("sexp: cannot set embedded pointer to unexported struct: %v", v.Type().Elem())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, nil
+}
+
+func (d *decodeState) decodeInterface(s *Sexp) (interface{}, error) {
+	switch {
+	case s.IsList():
+		xs := make([]interface{}, len(s.Children))
+		for i, c := range s.Children {
+			x, err := d.decodeInterface(c)
+			if err != nil {
+				return nil, err
+			}
+			xs[i] = x
+		}
+		return xs, nil
+	case s.IsString():
+		b, err := s.Atom.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case s.IsNumber():
+		n, err := s.Atom.Number()
+		if err != nil {
+			return nil, err
+		}
+		if i, err := n.Int64(); err == nil {
+			return i, nil
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return nil, &UnmarshalTypeError{Value: describe(s), Type: reflect.TypeOf(f), Pos: s.Start}
+		}
+		return f, nil
+	case s.IsSymbol("true"):
+		return true, nil
+	case s.IsSymbol("false"):
+		return false, nil
+	case isNil(s):
+		return nil, nil
+	}
+	return s.Atom.Value, nil
+}
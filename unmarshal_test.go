@@ -0,0 +1,306 @@
+package sexp
+
+import (
+	"errors"
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+type unmarshalServer struct {
+	Host    string            `sexp:"host"`
+	Port    uint16            `sexp:"port"`
+	TLS     bool              `sexp:"tls"`
+	Tags    []string          `sexp:"tags"`
+	Env     map[string]string `sexp:"env"`
+	Timeout *float64          `sexp:"timeout"`
+	Started time.Time         `sexp:"started"`
+	Color   *unmarshalColor   `sexp:"color"`
+	MaxConn int
+}
+
+type unmarshalColor struct {
+	R, G, B uint8
+}
+
+func (c *unmarshalColor) UnmarshalSexp(s *Sexp) error {
+	if !s.HasHead("rgb") || s.Len() != 4 {
+		return &UnmarshalTypeError{Value: describe(s), Type: reflect.TypeOf(c), Pos: s.Start}
+	}
+	for i, p := range []*uint8{&c.R, &c.G, &c.B} {
+		err := Unmarshal([]byte(s.Nth(i+1).String()), p)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestUnmarshal(t *testing.T) {
+	timeout := 2.5
+
+	testData := []struct {
+		Name     string
+		Pattern  string
+		Expected unmarshalServer
+	}{
+		{
+			Name:    "pattern 1 - alist with a head symbol",
+			Pattern: `(server (host "x") (port 8080))`,
+			Expected: unmarshalServer{
+				Host: "x",
+				Port: 8080,
+			},
+		},
+		{
+			Name:    "pattern 2 - plist",
+			Pattern: `(:host "x" :port 0x1f90 :tls true :tags ("a" b) :maxconn 1_000)`,
+			Expected: unmarshalServer{
+				Host:    "x",
+				Port:    8080,
+				TLS:     true,
+				Tags:    []string{"a", "b"},
+				MaxConn: 1000,
+			},
+		},
+		{
+			Name:    "pattern 3 - nested values",
+			Pattern: `((env ((HOME "/root") ("PATH" "/bin"))) (timeout 2.5) (started "2020-01-02T03:04:05Z") (color (rgb 1 2 3)) (unknown 1))`,
+			Expected: unmarshalServer{
+				Env:     map[string]string{"HOME": "/root", "PATH": "/bin"},
+				Timeout: &timeout,
+				Started: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+				Color:   &unmarshalColor{1, 2, 3},
+			},
+		},
+		{
+			Name:     "pattern 4 - nil",
+			Pattern:  `((tags nil) (env nil) (timeout nil) (color nil))`,
+			Expected: unmarshalServer{},
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			var a unmarshalServer
+			err := Unmarshal([]byte(data.Pattern), &a)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if diff := pretty.Compare(data.Expected, a); diff != "" {
+				t.Fatalf("\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUnmarshalInterface(t *testing.T) {
+	var a interface{}
+	err := Unmarshal([]byte(`(a "b" 1 -0x10 1.5 inf true nil ())`), &a)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	expected := []interface{}{"a", "b", int64(1), int64(-16), 1.5, math.Inf(1), true, nil, []interface{}{}}
+	if !reflect.DeepEqual(expected, a) {
+		t.Fatalf("\n%s", pretty.Compare(expected, a))
+	}
+}
+
+func TestUnmarshalRoundTrip(t *testing.T) {
+	timeout := 1.5
+	v := marshalServer{
+		Host:    "x",
+		Port:    8080,
+		TLS:     true,
+		Tags:    []string{"a", "b"},
+		Env:     map[string]string{"PATH": "/bin"},
+		Timeout: &timeout,
+	}
+	b, err := Marshal(v)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	var a marshalServer
+	err = Unmarshal(b, &a)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if diff := pretty.Compare(v, a); diff != "" {
+		t.Fatalf("\n%s", diff)
+	}
+}
+
+type plistMaps struct {
+	Strings map[string]int    `sexp:"m,omitempty,plist"`
+	Ints    map[int]string    `sexp:"i,omitempty,plist"`
+	Lists   map[string][]int  `sexp:"l,omitempty,plist"`
+	Nested  map[string]plistA `sexp:"n,omitempty,plist"`
+}
+
+type plistA struct {
+	A int `sexp:"a"`
+}
+
+func TestUnmarshalPlistRoundTrip(t *testing.T) {
+	testData := []struct {
+		Name     string
+		Value    plistMaps
+		Expected string
+	}{
+		{
+			Name:     "pattern 1 - quoted string keys",
+			Value:    plistMaps{Strings: map[string]int{"b c": 1, "d": 2}},
+			Expected: `((m ("b c" 1 :d 2)))`,
+		},
+		{
+			Name:     "pattern 2 - integer keys",
+			Value:    plistMaps{Ints: map[int]string{1: "a"}},
+			Expected: `((i (1 "a")))`,
+		},
+		{
+			Name:     "pattern 3 - list values",
+			Value:    plistMaps{Lists: map[string][]int{"x y": {1, 2}, "z": {3, 4}}},
+			Expected: `((l ("x y" (1 2) :z (3 4))))`,
+		},
+		{
+			Name:     "pattern 4 - struct values",
+			Value:    plistMaps{Nested: map[string]plistA{"k": {A: 1}}},
+			Expected: `((n (:k ((a 1)))))`,
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			b, err := Marshal(data.Value)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if string(b) != data.Expected {
+				t.Fatalf("\nExpected: %s\nActual:   %s", data.Expected, b)
+			}
+
+			var a plistMaps
+			err = Unmarshal(b, &a)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if diff := pretty.Compare(data.Value, a); diff != "" {
+				t.Fatalf("\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUnmarshalError(t *testing.T) {
+	testData := []struct {
+		Name     string
+		Pattern  string
+		Value    interface{}
+		Expected string
+	}{
+		{
+			Name:     "pattern 1 - overflow",
+			Pattern:  "(server\n  (port 65536))",
+			Value:    &unmarshalServer{},
+			Expected: "2:9: cannot unmarshal number 65536 into Go struct field unmarshalServer.port of type uint16",
+		},
+		{
+			Name:     "pattern 2 - wrong type",
+			Pattern:  `((tags ("a" 1)))`,
+			Value:    &unmarshalServer{},
+			Expected: "1:13: cannot unmarshal number 1 into Go struct field unmarshalServer.tags of type string",
+		},
+		{
+			Name:     "pattern 3 - not an integer",
+			Pattern:  `1.5`,
+			Value:    new(int),
+			Expected: "1:1: cannot unmarshal number 1.5 into Go value of type int",
+		},
+		{
+			Name:     "pattern 4 - malformed entry",
+			Pattern:  `((host "x" "y"))`,
+			Value:    &unmarshalServer{},
+			Expected: `1:2: expected (key value), but found list: "((host \"x\" \"y\"))"`,
+		},
+		{
+			Name:     "pattern 5 - plist without a value",
+			Pattern:  `(:host "x" :port)`,
+			Value:    &unmarshalServer{},
+			Expected: `1:17: expected value, but found ")": "(:host \"x\" :port)"`,
+		},
+		{
+			Name:     "pattern 6 - syntax error",
+			Pattern:  `(host "x"`,
+			Value:    &unmarshalServer{},
+			Expected: `1:10: expected ")", but found EOF: "(host \"x\""`,
+		},
+		{
+			Name:     "pattern 7 - non-pointer",
+			Pattern:  `1`,
+			Value:    1,
+			Expected: "sexp: Unmarshal(non-pointer int)",
+		},
+		{
+			Name:     "pattern 8 - unmarshaler",
+			Pattern:  `((color (hsv 1 2 3)))`,
+			Value:    &unmarshalServer{},
+			Expected: "1:9: cannot unmarshal list into Go value of type *sexp.unmarshalColor",
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			err := Unmarshal([]byte(data.Pattern), data.Value)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if data.Expected != err.Error() {
+				t.Fatalf("\nExpected: %s\nActual:   %s", data.Expected, err.Error())
+			}
+		})
+	}
+}
+
+func TestDecoderDecodeValue(t *testing.T) {
+	dec := NewDecoder(strings.NewReader(`(:host "a") (:host "b" :extra 1)`))
+	dec.DisallowUnknownFields()
+
+	var a unmarshalServer
+	err := dec.DecodeValue(&a)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if a.Host != "a" {
+		t.Fatalf("unexpected host: %s", a.Host)
+	}
+
+	err = dec.DecodeValue(&a)
+	expected := `1:24: unknown field "extra" in Go value of type sexp.unmarshalServer`
+	if err == nil || err.Error() != expected {
+		t.Fatalf("\nExpected: %s\nActual:   %v", expected, err)
+	}
+	var fe *UnknownFieldError
+	if !errors.As(err, &fe) || fe.Field != "extra" || fe.Pos != (Position{23, 1, 24}) {
+		t.Fatalf("unexpected error: %#v", err)
+	}
+
+	err = dec.DecodeValue(&a)
+	if err != io.EOF {
+		t.Fatalf("expected io.EOF, but got %v", err)
+	}
+}
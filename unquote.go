@@ -0,0 +1,196 @@
+package sexp
+
+import (
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+)
+
+// escapeError reports a malformed escape sequence at a byte offset in a string literal.
+type escapeError struct {
+	offset int
+	msg    string
+}
+
+// unquote decodes a string literal including its surrounding quotes.
+// It supports \n, \t, \r, \\, \", \', two-digit hex byte escapes such as \41,
+// and code point escapes such as \u{1F600}.
+func unquote(lit string) ([]byte, *escapeError) {
+	if len(lit) < 2 || lit[0] != '"' || lit[len(lit)-1] != '"' {
+		return nil, &escapeError{offset: 0, msg: "string literal should be enclosed in double quotes"}
+	}
+
+	buf := make([]byte, 0, len(lit)-2)
+	i := 1
+	end := len(lit) - 1
+	for i < end {
+		c := lit[i]
+		if c != '\\' {
+			buf = append(buf, c)
+			i++
+			continue
+		}
+
+		if i+1 >= end {
+			return nil, &escapeError{offset: i, msg: "incomplete escape sequence"}
+		}
+		e := lit[i+1]
+		switch {
+		case e == 'n':
+			buf = append(buf, '\n')
+			i += 2
+		case e == 't':
+			buf = append(buf, '\t')
+			i += 2
+		case e == 'r':
+			buf = append(buf, '\r')
+			i += 2
+		case e == '\\' || e == '"' || e == '\'':
+			buf = append(buf, e)
+			i += 2
+		case e == 'u':
+			r, n, err := unquoteCodePoint(lit[i:end])
+			if err != "" {
+				return nil, &escapeError{offset: i, msg: err}
+			}
+			var tmp [utf8.UTFMax]byte
+			buf = append(buf, tmp[:utf8.EncodeRune(tmp[:], r)]...)
+			i += n
+		case isHexDigit(e):
+			if i+2 >= end || !isHexDigit(lit[i+2]) {
+				return nil, &escapeError{offset: i, msg: "hex escape sequence should have two digits"}
+			}
+			buf = append(buf, hexValue(e)<<4|hexValue(lit[i+2]))
+			i += 3
+		default:
+			return nil, &escapeError{offset: i, msg: fmt.Sprintf("unknown escape sequence %q", lit[i:i+2])}
+		}
+	}
+	return buf, nil
+}
+
+// quote returns a string literal for b that unquote decodes back to b.
+func quote(b []byte) string {
+	const hex = "0123456789abcdef"
+	buf := []byte{'"'}
+	for len(b) > 0 {
+		r, size := utf8.DecodeRune(b)
+		switch {
+		case r == '"' || r == '\\':
+			buf = append(buf, '\\', byte(r))
+		case r == '\n':
+			buf = append(buf, '\\', 'n')
+		case r == '\t':
+			buf = append(buf, '\\', 't')
+		case r == '\r':
+			buf = append(buf, '\\', 'r')
+		case r == utf8.RuneError && size == 1 || !strconv.IsPrint(r):
+			for _, c := range b[:size] {
+				buf = append(buf, '\\', hex[c>>4], hex[c&0xf])
+			}
+		default:
+			buf = append(buf, b[:size]...)
+		}
+		b = b[size:]
+	}
+	buf = append(buf, '"')
+	return string(buf)
+}
+
+// unquoteCodePoint decodes an escape such as \u{1F600} at the beginning of s.
+func unquoteCodePoint(s string) (rune, int, string) {
+	if len(s) < 3 || s[2] != '{' {
+		return 0, 0, `code point escape sequence should be written as \u{...}`
+	}
+
+	var r rune
+	i := 3
+	for ; i < len(s) && s[i] != '}'; i++ {
+		if !isHexDigit(s[i]) {
+			return 0, 0, "invalid hex digit in code point escape sequence"
+		}
+		r = r<<4 | rune(hexValue(s[i]))
+		if r > utf8.MaxRune {
+			return 0, 0, "code point out of range"
+		}
+	}
+	if i >= len(s) {
+		return 0, 0, "code point escape sequence not terminated"
+	}
+	if i == 3 {
+		return 0, 0, "empty code point escape sequence"
+	}
+	if !utf8.ValidRune(r) {
+		return 0, 0, "invalid code point"
+	}
+	return r, i + 1, ""
+}
+
+func isHexDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func hexValue(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+// Bytes returns the bytes denoted by a string token, with escape sequences decoded.
+// The result is not necessarily valid UTF-8.
+func (t *Token) Bytes() ([]byte, error) {
+	if t.Type != TokenTypeString {
+		return nil, &SyntaxError{Pos: t.Start, Expected: "string", Found: describeToken(t)}
+	}
+
+	b, e := unquote(t.Value)
+	if e != nil {
+		return nil, &SyntaxError{
+			Pos: t.Start.advance(t.Value[:e.offset]),
+			Msg: e.msg,
+		}
+	}
+	return b, nil
+}
+
+// StringValue returns the string denoted by a string token, with escape sequences decoded.
+// It fails if the result is not valid UTF-8; use Bytes for binary data.
+func (t *Token) StringValue() (string, error) {
+	b, err := t.Bytes()
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(b) {
+		return "", &SyntaxError{Pos: t.Start, Msg: "string is not valid UTF-8"}
+	}
+	return string(b), nil
+}
+
+// ConcatStrings returns the bytes denoted by string atoms concatenated, as in the
+// (module binary "\00asm" ...) form of WebAssembly scripts. Escape sequences denote
+// bytes exactly, and the result is not decoded as UTF-8. An error reports the
+// position of an element that is not a string or of a malformed escape sequence.
+func ConcatStrings(ss []*Sexp) ([]byte, error) {
+	buf := []byte{}
+	for _, s := range ss {
+		switch {
+		case s == nil:
+			return nil, &SyntaxError{Expected: "string", Found: "nil"}
+		case s.IsList():
+			return nil, &SyntaxError{Pos: s.Start, Expected: "string", Found: "list"}
+		case !s.IsString():
+			return nil, &SyntaxError{Pos: s.Start, Expected: "string", Found: describeToken(s.Atom)}
+		}
+		b, err := s.Atom.Bytes()
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, b...)
+	}
+	return buf, nil
+}
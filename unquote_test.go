@@ -0,0 +1,274 @@
+package sexp
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTokenBytes(t *testing.T) {
+	testData := []struct {
+		Name     string
+		Pattern  string
+		Expected []byte
+	}{
+		{
+			Name:     "pattern 1 - plain",
+			Pattern:  `"hello"`,
+			Expected: []byte("hello"),
+		},
+		{
+			Name:     "pattern 2 - empty",
+			Pattern:  `""`,
+			Expected: []byte{},
+		},
+		{
+			Name:     "pattern 3 - quotes",
+			Pattern:  `"he said \"hello\" and \'bye\'"`,
+			Expected: []byte(`he said "hello" and 'bye'`),
+		},
+		{
+			Name:     "pattern 4 - control characters",
+			Pattern:  `"a\nb\tc\rd\\e"`,
+			Expected: []byte("a\nb\tc\rd\\e"),
+		},
+		{
+			Name:     "pattern 5 - hex bytes",
+			Pattern:  `"\00\61\73\6d\01\00\00\00"`,
+			Expected: []byte("\x00asm\x01\x00\x00\x00"),
+		},
+		{
+			Name:     "pattern 6 - hex bytes, not utf-8",
+			Pattern:  `"\ff\FE"`,
+			Expected: []byte{0xff, 0xfe},
+		},
+		{
+			Name:     "pattern 7 - code points",
+			Pattern:  `"\u{41}\u{1F600}\u{00e9}"`,
+			Expected: []byte("A\U0001F600é"),
+		},
+		{
+			Name:     "pattern 8 - multibyte",
+			Pattern:  `"日本語"`,
+			Expected: []byte("日本語"),
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			token := &Token{Type: TokenTypeString, Value: data.Pattern}
+			a, err := token.Bytes()
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if !bytes.Equal(data.Expected, a) {
+				t.Fatalf("\nExpected: %q\nActual:   %q", data.Expected, a)
+			}
+		})
+	}
+}
+
+func TestTokenBytesError(t *testing.T) {
+	testData := []struct {
+		Name          string
+		Pattern       string
+		ExpectedError string
+	}{
+		{
+			Name:          "pattern 1 - unknown escape",
+			Pattern:       `("ab\qc")`,
+			ExpectedError: `1:5: unknown escape sequence "\\q"`,
+		},
+		{
+			Name:          "pattern 2 - one hex digit",
+			Pattern:       `("\4")`,
+			ExpectedError: "1:3: hex escape sequence should have two digits",
+		},
+		{
+			Name:          "pattern 3 - bad hex digit",
+			Pattern:       `("\4g")`,
+			ExpectedError: "1:3: hex escape sequence should have two digits",
+		},
+		{
+			Name:          "pattern 4 - code point without braces",
+			Pattern:       `("\u41")`,
+			ExpectedError: `1:3: code point escape sequence should be written as \u{...}`,
+		},
+		{
+			Name:          "pattern 5 - code point out of range",
+			Pattern:       `("\u{110000}")`,
+			ExpectedError: "1:3: code point out of range",
+		},
+		{
+			Name:          "pattern 6 - surrogate",
+			Pattern:       `("\u{d800}")`,
+			ExpectedError: "1:3: invalid code point",
+		},
+		{
+			Name:          "pattern 7 - unterminated code point",
+			Pattern:       `("\u{41")`,
+			ExpectedError: "1:3: code point escape sequence not terminated",
+		},
+		{
+			Name:          "pattern 8 - empty code point",
+			Pattern:       `("\u{}")`,
+			ExpectedError: "1:3: empty code point escape sequence",
+		},
+		{
+			Name:          "pattern 9 - position on a later line",
+			Pattern:       "(a\n  \"x\ny\\z\")",
+			ExpectedError: `3:2: unknown escape sequence "\\z"`,
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			s, err := Parse(data.Pattern)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			atom := s.Children[len(s.Children)-1].Atom
+			_, err = atom.Bytes()
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if data.ExpectedError != err.Error() {
+				t.Fatalf("\nExpected: %s\nActual:   %s", data.ExpectedError, err.Error())
+			}
+		})
+	}
+}
+
+func TestTokenStringValue(t *testing.T) {
+	token := &Token{Type: TokenTypeString, Value: `"caf\u{e9} \c3\a9"`}
+	a, err := token.StringValue()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if a != "café é" {
+		t.Fatalf("unexpected value: %q", a)
+	}
+
+	token = &Token{Type: TokenTypeString, Value: `"\ff"`}
+	_, err = token.StringValue()
+	if err == nil {
+		t.Fatalf("expected an error for invalid UTF-8")
+	}
+
+	token = &Token{Type: TokenTypeSymbol, Value: "abc"}
+	_, err = token.StringValue()
+	if err == nil {
+		t.Fatalf("expected an error for a symbol")
+	}
+}
+
+func TestConcatStrings(t *testing.T) {
+	testData := []struct {
+		Name          string
+		Pattern       string
+		Expected      []byte
+		ExpectedError string
+	}{
+		{
+			Name:     "pattern 1 - module header",
+			Pattern:  `(module binary "\00asm" "\01\00\00\00")`,
+			Expected: []byte("\x00asm\x01\x00\x00\x00"),
+		},
+		{
+			Name:     "pattern 2 - bytes that are not utf-8",
+			Pattern:  `(module binary "\c3" "\a9\ff" "")`,
+			Expected: []byte{0xc3, 0xa9, 0xff},
+		},
+		{
+			Name:     "pattern 3 - no strings",
+			Pattern:  `(module binary)`,
+			Expected: []byte{},
+		},
+		{
+			Name:          "pattern 4 - malformed escape in a later string",
+			Pattern:       "(module binary \"\\00asm\"\n  \"\\01\\0\")",
+			ExpectedError: "2:7: hex escape sequence should have two digits",
+		},
+		{
+			Name:          "pattern 5 - not a string",
+			Pattern:       `(module binary "\00asm" 1)`,
+			ExpectedError: `1:25: expected string, but found "1"`,
+		},
+		{
+			Name:          "pattern 6 - list",
+			Pattern:       `(module binary "\00asm" (a))`,
+			ExpectedError: `1:25: expected string, but found list`,
+		},
+		{
+			Name:     "pattern 7 - raw bytes that are not utf-8",
+			Pattern:  "(module binary \"\x00a\xff\" \"\xfe\x80\xc3\")",
+			Expected: []byte{0x00, 'a', 0xff, 0xfe, 0x80, 0xc3},
+		},
+		{
+			Name:          "pattern 8 - symbol",
+			Pattern:       `(module binary "\00asm" asm)`,
+			ExpectedError: `1:25: expected string, but found "asm"`,
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			s, err := Parse(data.Pattern)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			a, err := ConcatStrings(s.Children[2:])
+			if data.ExpectedError != "" {
+				if err == nil || data.ExpectedError != err.Error() {
+					t.Fatalf("\nExpected: %s\nActual:   %v", data.ExpectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if !bytes.Equal(data.Expected, a) {
+				t.Fatalf("\nExpected: %q\nActual:   %q", data.Expected, a)
+			}
+		})
+	}
+}
+
+func TestConcatStringsInvalidElements(t *testing.T) {
+	testData := []struct {
+		Name          string
+		Elements      []*Sexp
+		ExpectedError string
+	}{
+		{
+			Name:          "pattern 1 - nil",
+			Elements:      []*Sexp{nil},
+			ExpectedError: "-: expected string, but found nil",
+		},
+		{
+			Name:          "pattern 2 - empty list",
+			Elements:      []*Sexp{{}},
+			ExpectedError: "-: expected string, but found list",
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			_, err := ConcatStrings(data.Elements)
+			if err == nil || data.ExpectedError != err.Error() {
+				t.Fatalf("\nExpected: %s\nActual:   %v", data.ExpectedError, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,498 @@
+package wast
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/bearmini/sexp"
+)
+
+// exportTypes are the result types of the functions and the types of the globals a
+// module exports, as far as they are known.
+type exportTypes struct {
+	funcs   map[string][]ValueType
+	globals map[string]ValueType
+}
+
+func newExportTypes() *exportTypes {
+	return &exportTypes{funcs: map[string][]ValueType{}, globals: map[string]ValueType{}}
+}
+
+// results returns the types of the results of an action. It returns false if they
+// are not known.
+func (t *exportTypes) results(a *Action) ([]ValueType, bool) {
+	if t == nil {
+		return nil, false
+	}
+	if a.Kind == ActionKindGet {
+		typ, ok := t.globals[a.Field]
+		return []ValueType{typ}, ok
+	}
+	results, ok := t.funcs[a.Field]
+	return results, ok
+}
+
+// moduleExportTypes returns the export types of a module from the file written for it:
+// the binary format, or the text format of a text or quoted module. It returns nil if
+// they cannot be read.
+func moduleExportTypes(m *Module, data []byte, moduleType string) *exportTypes {
+	if moduleType == "binary" {
+		t, err := readExportTypes(data)
+		if err != nil {
+			return nil
+		}
+		return t
+	}
+
+	fields := m.Form.Tail()
+	if m.Kind == ModuleKindQuote {
+		ss, err := sexp.ParseAll(string(data))
+		if err != nil {
+			return nil
+		}
+		fields = ss
+		if len(ss) == 1 && ss[0].HasHead("module") {
+			fields = ss[0].Tail()
+		}
+	}
+	for _, f := range fields {
+		if f.IsSymbol("binary", "quote") {
+			return nil
+		}
+	}
+	return textExportTypes(fields)
+}
+
+var textValueTypes = map[string]ValueType{
+	"i32":       ValueTypeI32,
+	"i64":       ValueTypeI64,
+	"f32":       ValueTypeF32,
+	"f64":       ValueTypeF64,
+	"funcref":   ValueTypeFuncRef,
+	"externref": ValueTypeExternRef,
+}
+
+// indexSpace maps the indices and $names of the definitions of a kind in a text module
+// to their types: the results of a function type or of a function, or the type of a
+// global. A nil entry is a definition of unknown type.
+type indexSpace struct {
+	types map[string][]ValueType
+	n     int
+}
+
+func newIndexSpace() *indexSpace {
+	return &indexSpace{types: map[string][]ValueType{}}
+}
+
+// define adds the definition f.
+func (s *indexSpace) define(f *sexp.Sexp, types []ValueType) {
+	s.types[strconv.Itoa(s.n)] = types
+	if id, ok := f.Nth(1).Symbol(); ok && strings.HasPrefix(id, "$") {
+		s.types[id] = types
+	}
+	s.n++
+}
+
+// lookup returns the types of the definition x, an index or a $name.
+func (s *indexSpace) lookup(x *sexp.Sexp) []ValueType {
+	if !x.IsAtom() {
+		return nil
+	}
+	return s.types[x.Atom.Value]
+}
+
+// textExportTypes reads the export types of a module from its fields in the text format.
+// Exports whose types it cannot tell are left out.
+func textExportTypes(fields []*sexp.Sexp) *exportTypes {
+	types, funcs, globals := newIndexSpace(), newIndexSpace(), newIndexSpace()
+	for _, f := range fields {
+		if f.HasHead("type") {
+			def := f.Nth(1)
+			if def.IsSymbol() {
+				def = f.Nth(2)
+			}
+			types.define(f, resultTypes(def.Tail()))
+		}
+	}
+
+	t := newExportTypes()
+	for _, f := range fields {
+		var results []ValueType
+		switch {
+		case f.HasHead("import") && f.Nth(3).HasHead("func"):
+			funcs.define(f.Nth(3), typeUse(types, f.Nth(3).Tail()))
+		case f.HasHead("import") && f.Nth(3).HasHead("global"):
+			globals.define(f.Nth(3), globalType(f.Nth(3).Tail()))
+		case f.HasHead("func"):
+			results = typeUse(types, f.Tail())
+			funcs.define(f, results)
+		case f.HasHead("global"):
+			results = globalType(f.Tail())
+			globals.define(f, results)
+		}
+		if results == nil {
+			continue
+		}
+		for _, e := range f.Tail() {
+			if name, ok := e.Nth(1).Str(); ok && e.HasHead("export") {
+				t.add(name, f.HasHead("func"), results)
+			}
+		}
+	}
+
+	for _, f := range fields {
+		name, ok := f.Nth(1).Str()
+		desc := f.Nth(2)
+		if !ok || !f.HasHead("export") {
+			continue
+		}
+		switch {
+		case desc.HasHead("func") && funcs.lookup(desc.Nth(1)) != nil:
+			t.add(name, true, funcs.lookup(desc.Nth(1)))
+		case desc.HasHead("global") && globals.lookup(desc.Nth(1)) != nil:
+			t.add(name, false, globals.lookup(desc.Nth(1)))
+		}
+	}
+	return t
+}
+
+// add records the results of an exported function, or the type of an exported global.
+func (t *exportTypes) add(name string, isFunc bool, types []ValueType) {
+	if isFunc {
+		t.funcs[name] = types
+	} else {
+		t.globals[name] = types[0]
+	}
+}
+
+// typeUse returns the results of the type use in the elements of a function, or nil
+// if they are not known. A (type x) reference gives the results unless they are
+// written inline.
+func typeUse(types *indexSpace, elems []*sexp.Sexp) []ValueType {
+	header := headerElems(elems)
+	for _, e := range header {
+		if e.HasHead("result") {
+			return resultTypes(header)
+		}
+	}
+	for _, e := range header {
+		if e.HasHead("type") {
+			return types.lookup(e.Nth(1))
+		}
+	}
+	return resultTypes(header)
+}
+
+// resultTypes returns the types of the (result ...) elements before the locals and the
+// instructions of a function, or nil if one of them is unknown.
+func resultTypes(elems []*sexp.Sexp) []ValueType {
+	results := []ValueType{}
+	for _, e := range headerElems(elems) {
+		if !e.HasHead("result") {
+			continue
+		}
+		for _, v := range e.Tail() {
+			name, _ := v.Symbol()
+			typ, ok := textValueTypes[name]
+			if !ok {
+				return nil
+			}
+			results = append(results, typ)
+		}
+	}
+	return results
+}
+
+// headerElems returns the elements of a function up to the end of its type use.
+func headerElems(elems []*sexp.Sexp) []*sexp.Sexp {
+	for i, e := range elems {
+		if i == 0 && e.IsSymbol() && strings.HasPrefix(e.Atom.Value, "$") {
+			continue
+		}
+		if !e.HasHead("export", "import", "type", "param", "result") {
+			return elems[:i]
+		}
+	}
+	return elems
+}
+
+// globalType returns the value type of a global from its elements, or nil if it is not known.
+func globalType(elems []*sexp.Sexp) []ValueType {
+	for i, e := range elems {
+		if i == 0 && e.IsSymbol() && strings.HasPrefix(e.Atom.Value, "$") || e.HasHead("export", "import") {
+			continue
+		}
+		if e.HasHead("mut") {
+			e = e.Nth(1)
+		}
+		name, _ := e.Symbol()
+		typ, ok := textValueTypes[name]
+		if !ok {
+			return nil
+		}
+		return []ValueType{typ}
+	}
+	return nil
+}
+
+var binaryValueTypes = map[byte]ValueType{
+	0x7f: ValueTypeI32,
+	0x7e: ValueTypeI64,
+	0x7d: ValueTypeF32,
+	0x7c: ValueTypeF64,
+	0x70: ValueTypeFuncRef,
+	0x6f: ValueTypeExternRef,
+}
+
+var errUnexpectedEnd = errors.New("unexpected end of module")
+
+// binaryReader reads the binary format of a module.
+type binaryReader struct {
+	b []byte
+}
+
+func (r *binaryReader) byte() (byte, error) {
+	if len(r.b) == 0 {
+		return 0, errUnexpectedEnd
+	}
+	c := r.b[0]
+	r.b = r.b[1:]
+	return c, nil
+}
+
+func (r *binaryReader) bytes(n uint32) ([]byte, error) {
+	if uint32(len(r.b)) < n {
+		return nil, errUnexpectedEnd
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b, nil
+}
+
+// u32 reads an unsigned LEB128 number. It also skips signed numbers.
+func (r *binaryReader) u32() (uint32, error) {
+	var v uint32
+	for shift := uint(0); ; shift += 7 {
+		c, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		if shift < 32 {
+			v |= uint32(c&0x7f) << shift
+		}
+		if c&0x80 == 0 {
+			return v, nil
+		}
+	}
+}
+
+func (r *binaryReader) name() (string, error) {
+	n, err := r.u32()
+	if err != nil {
+		return "", err
+	}
+	b, err := r.bytes(n)
+	return string(b), err
+}
+
+func (r *binaryReader) limits() error {
+	flags, err := r.byte()
+	if err != nil {
+		return err
+	}
+	_, err = r.u32()
+	if err == nil && flags&1 != 0 {
+		_, err = r.u32()
+	}
+	return err
+}
+
+// constExpr skips a constant expression.
+func (r *binaryReader) constExpr() error {
+	for {
+		op, err := r.byte()
+		if err != nil {
+			return err
+		}
+		switch op {
+		case 0x0b: // end
+			return nil
+		case 0x41, 0x42, 0x23, 0xd0, 0xd2: // i32.const, i64.const, global.get, ref.null, ref.func
+			_, err = r.u32()
+		case 0x43: // f32.const
+			_, err = r.bytes(4)
+		case 0x44: // f64.const
+			_, err = r.bytes(8)
+		case 0x6a, 0x6b, 0x6c, 0x7c, 0x7d, 0x7e: // extended constant arithmetic
+		default:
+			return fmt.Errorf("unsupported instruction %#x in a constant expression", op)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readExportTypes reads the types of the exports of a module from its type, import,
+// function, global and export sections.
+func readExportTypes(b []byte) (*exportTypes, error) {
+	if len(b) < 8 || string(b[:4]) != "\x00asm" {
+		return nil, errors.New("not a binary module")
+	}
+	r := &binaryReader{b: b[8:]}
+
+	var types [][]byte // the results of the function types
+	var funcs []uint32 // the type indices of the functions
+	var globals []byte // the types of the globals
+	t := newExportTypes()
+	for len(r.b) > 0 {
+		id, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		size, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		contents, err := r.bytes(size)
+		if err != nil {
+			return nil, err
+		}
+
+		s := &binaryReader{b: contents}
+		var n uint32
+		switch id {
+		case 1, 2, 3, 6, 7:
+			n, err = s.u32()
+		}
+		for i := uint32(0); i < n && err == nil; i++ {
+			switch id {
+			case 1: // type
+				types, err = s.funcType(types)
+			case 2: // import
+				funcs, globals, err = s.importDesc(funcs, globals)
+			case 3: // function
+				var x uint32
+				x, err = s.u32()
+				funcs = append(funcs, x)
+			case 6: // global
+				var typ byte
+				typ, err = s.byte()
+				if err == nil {
+					globals = append(globals, typ)
+					_, err = s.byte()
+				}
+				if err == nil {
+					err = s.constExpr()
+				}
+			case 7: // export
+				err = s.export(t, types, funcs, globals)
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+func (r *binaryReader) funcType(types [][]byte) ([][]byte, error) {
+	form, err := r.byte()
+	if err != nil {
+		return nil, err
+	}
+	if form != 0x60 {
+		return nil, fmt.Errorf("unsupported type form %#x", form)
+	}
+	n, err := r.u32()
+	if err == nil {
+		_, err = r.bytes(n)
+	}
+	if err != nil {
+		return nil, err
+	}
+	n, err = r.u32()
+	if err != nil {
+		return nil, err
+	}
+	results, err := r.bytes(n)
+	return append(types, results), err
+}
+
+func (r *binaryReader) importDesc(funcs []uint32, globals []byte) ([]uint32, []byte, error) {
+	_, err := r.name()
+	if err == nil {
+		_, err = r.name()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	kind, err := r.byte()
+	if err != nil {
+		return nil, nil, err
+	}
+	switch kind {
+	case 0: // func
+		var x uint32
+		x, err = r.u32()
+		funcs = append(funcs, x)
+	case 1: // table
+		_, err = r.byte()
+		if err == nil {
+			err = r.limits()
+		}
+	case 2: // memory
+		err = r.limits()
+	case 3: // global
+		var typ byte
+		typ, err = r.byte()
+		globals = append(globals, typ)
+		if err == nil {
+			_, err = r.byte()
+		}
+	default:
+		err = fmt.Errorf("unsupported import kind %#x", kind)
+	}
+	return funcs, globals, err
+}
+
+// export records the types of an export. Exports of unknown value types are left out.
+func (r *binaryReader) export(t *exportTypes, types [][]byte, funcs []uint32, globals []byte) error {
+	name, err := r.name()
+	if err != nil {
+		return err
+	}
+	kind, err := r.byte()
+	if err != nil {
+		return err
+	}
+	x, err := r.u32()
+	if err != nil {
+		return err
+	}
+	switch kind {
+	case 0: // func
+		if x >= uint32(len(funcs)) || funcs[x] >= uint32(len(types)) {
+			return fmt.Errorf("export %q refers to an undefined function", name)
+		}
+		results := []ValueType{}
+		for _, code := range types[funcs[x]] {
+			typ, ok := binaryValueTypes[code]
+			if !ok {
+				return nil
+			}
+			results = append(results, typ)
+		}
+		t.funcs[name] = results
+	case 3: // global
+		if x >= uint32(len(globals)) {
+			return fmt.Errorf("export %q refers to an undefined global", name)
+		}
+		if typ, ok := binaryValueTypes[globals[x]]; ok {
+			t.globals[name] = typ
+		}
+	}
+	return nil
+}
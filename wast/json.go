@@ -0,0 +1,293 @@
+package wast
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/bearmini/sexp"
+)
+
+// JSONOptions controls the conversion of a script to the JSON command manifest of
+// the reference toolchain's wast2json.
+type JSONOptions struct {
+	// SourceFilename is the name of the script, as recorded in the manifest.
+	SourceFilename string
+
+	// ModuleBase is the base name of the module files, as in i32 for i32.0.wasm.
+	// If it is empty, the module files are named after SourceFilename.
+	ModuleBase string
+
+	// Compile converts a text module to the binary format. If it is nil, text modules
+	// are written as .wat files instead, with "module_type": "text" in assertions.
+	// A module of assert_malformed that fails to compile is written as a .wat file;
+	// for any other command, the error is returned.
+	Compile func(m *Module) ([]byte, error)
+}
+
+// ModuleFile is a module written next to the manifest.
+type ModuleFile struct {
+	Name string
+	Data []byte
+}
+
+// ToJSON converts a script to a wast2json command manifest. The modules the
+// manifest refers to are returned as files, in the order of the commands.
+//
+// The result types that action, assert_trap and assert_exhaustion commands expect
+// are read from the exports of the module the action refers to, in the file written
+// for it: in the binary format, or in the text format. Commands whose result types
+// cannot be told from the module are written without "expected".
+func ToJSON(script *Script, opts *JSONOptions) ([]byte, []ModuleFile, error) {
+	c := &jsonConverter{opts: opts, base: opts.ModuleBase, named: map[string]*exportTypes{}}
+	if c.base == "" {
+		c.base = strings.TrimSuffix(filepath.Base(opts.SourceFilename), filepath.Ext(opts.SourceFilename))
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(`{"source_filename": `)
+	buf.WriteString(jsonString(opts.SourceFilename))
+	buf.WriteString(",\n \"commands\": [")
+	for i, cmd := range script.Commands {
+		o, err := c.command(cmd)
+		if err != nil {
+			return nil, nil, err
+		}
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		buf.WriteString("\n  ")
+		buf.WriteString(o.String())
+	}
+	buf.WriteString("]}\n")
+	return buf.Bytes(), c.files, nil
+}
+
+type jsonConverter struct {
+	opts  *JSONOptions
+	base  string
+	files []ModuleFile
+
+	// the export types of the most recent module and of the modules with a $name
+	current *exportTypes
+	named   map[string]*exportTypes
+}
+
+// object is a JSON object that keeps the order of its keys.
+type object []keyValue
+
+type keyValue struct {
+	key   string
+	value string // JSON text
+}
+
+func (o object) String() string {
+	ss := make([]string, len(o))
+	for i, kv := range o {
+		ss[i] = jsonString(kv.key) + ": " + kv.value
+	}
+	return "{" + strings.Join(ss, ", ") + "}"
+}
+
+func (o *object) add(key, value string) {
+	*o = append(*o, keyValue{key: key, value: value})
+}
+
+func jsonString(s string) string {
+	b, _ := json.Marshal(s) // never fails for a string
+	return string(b)
+}
+
+func jsonArray(values []string) string {
+	return "[" + strings.Join(values, ", ") + "]"
+}
+
+func (c *jsonConverter) command(cmd Command) (object, error) {
+	o := object{}
+	switch cmd := cmd.(type) {
+	case *Module:
+		o.add("type", jsonString("module"))
+		o.add("line", strconv.Itoa(cmd.Line()))
+		if cmd.Name != "" {
+			o.add("name", jsonString(cmd.Name))
+		}
+		filename, err := c.moduleCommand(cmd)
+		if err != nil {
+			return nil, err
+		}
+		o.add("filename", jsonString(filename))
+	case *Register:
+		o.add("type", jsonString("register"))
+		o.add("line", strconv.Itoa(cmd.Line()))
+		if cmd.Module != "" {
+			o.add("name", jsonString(cmd.Module))
+		}
+		o.add("as", jsonString(cmd.As))
+	case *ActionCommand:
+		o.add("type", jsonString("action"))
+		o.add("line", strconv.Itoa(cmd.Line()))
+		o.add("action", jsonAction(cmd.Action))
+		c.addResultTypes(&o, cmd.Action)
+	case *AssertReturn:
+		o.add("type", jsonString("assert_return"))
+		o.add("line", strconv.Itoa(cmd.Line()))
+		o.add("action", jsonAction(cmd.Action))
+		o.add("expected", jsonValues(cmd.Expected))
+	case *AssertTrap:
+		if cmd.Module != nil {
+			return c.moduleAssertion("assert_uninstantiable", cmd.Line(), cmd.Module, cmd.Message)
+		}
+		o.add("type", jsonString("assert_trap"))
+		o.add("line", strconv.Itoa(cmd.Line()))
+		o.add("action", jsonAction(cmd.Action))
+		o.add("text", jsonString(cmd.Message))
+		c.addResultTypes(&o, cmd.Action)
+	case *AssertExhaustion:
+		o.add("type", jsonString("assert_exhaustion"))
+		o.add("line", strconv.Itoa(cmd.Line()))
+		o.add("action", jsonAction(cmd.Action))
+		o.add("text", jsonString(cmd.Message))
+		c.addResultTypes(&o, cmd.Action)
+	case *AssertMalformed:
+		return c.moduleAssertion("assert_malformed", cmd.Line(), cmd.Module, cmd.Message)
+	case *AssertInvalid:
+		return c.moduleAssertion("assert_invalid", cmd.Line(), cmd.Module, cmd.Message)
+	case *AssertUnlinkable:
+		return c.moduleAssertion("assert_unlinkable", cmd.Line(), cmd.Module, cmd.Message)
+	default:
+		return nil, fmt.Errorf("wast: unknown command %T", cmd)
+	}
+	return o, nil
+}
+
+// moduleCommand adds the file of a module command and makes it the current module.
+func (c *jsonConverter) moduleCommand(m *Module) (string, error) {
+	filename, data, moduleType, err := c.module(m, "module")
+	if err != nil {
+		return "", err
+	}
+	types := moduleExportTypes(m, data, moduleType)
+	c.current = types
+	if m.Name != "" {
+		c.named[m.Name] = types
+	}
+	return filename, nil
+}
+
+// addResultTypes adds the types of the results of an action as "expected", if they are known.
+func (c *jsonConverter) addResultTypes(o *object, a *Action) {
+	types := c.current
+	if a.Module != "" {
+		types = c.named[a.Module]
+	}
+	results, ok := types.results(a)
+	if !ok {
+		return
+	}
+	ss := make([]string, len(results))
+	for i, t := range results {
+		ss[i] = object{{key: "type", value: jsonString(t.String())}}.String()
+	}
+	o.add("expected", jsonArray(ss))
+}
+
+func (c *jsonConverter) moduleAssertion(typ string, line int, m *Module, text string) (object, error) {
+	filename, _, moduleType, err := c.module(m, typ)
+	if err != nil {
+		return nil, err
+	}
+	o := object{}
+	o.add("type", jsonString(typ))
+	o.add("line", strconv.Itoa(line))
+	o.add("filename", jsonString(filename))
+	o.add("text", jsonString(text))
+	o.add("module_type", jsonString(moduleType))
+	return o, nil
+}
+
+// module adds the file of a module and returns its name, its contents and its module
+// type, "binary" or "text". The command the module belongs to is given by its type.
+func (c *jsonConverter) module(m *Module, command string) (string, []byte, string, error) {
+	data, moduleType, err := c.moduleData(m, command)
+	if err != nil {
+		return "", nil, "", err
+	}
+	ext := ".wasm"
+	if moduleType == "text" {
+		ext = ".wat"
+	}
+	filename := fmt.Sprintf("%s.%d%s", c.base, len(c.files), ext)
+	c.files = append(c.files, ModuleFile{Name: filename, Data: data})
+	return filename, data, moduleType, nil
+}
+
+func (c *jsonConverter) moduleData(m *Module, command string) ([]byte, string, error) {
+	switch m.Kind {
+	case ModuleKindBinary:
+		b, err := m.Bytes()
+		return b, "binary", err
+	case ModuleKindQuote:
+		b, err := m.Bytes()
+		return b, "text", err
+	}
+
+	text := []byte(sexp.Sprint(m.Form, nil) + "\n")
+	if c.opts.Compile == nil {
+		return text, "text", nil
+	}
+	b, err := c.opts.Compile(m)
+	switch {
+	case err != nil && command == "assert_malformed":
+		// The module is expected not to compile.
+		return text, "text", nil
+	case err != nil:
+		return nil, "", fmt.Errorf("%s: %v", m.Pos, err)
+	}
+	return b, "binary", nil
+}
+
+func jsonAction(a *Action) string {
+	o := object{}
+	switch a.Kind {
+	case ActionKindInvoke:
+		o.add("type", jsonString("invoke"))
+	case ActionKindGet:
+		o.add("type", jsonString("get"))
+	}
+	if a.Module != "" {
+		o.add("module", jsonString(a.Module))
+	}
+	o.add("field", jsonString(a.Field))
+	if a.Kind == ActionKindInvoke {
+		o.add("args", jsonValues(a.Args))
+	}
+	return o.String()
+}
+
+func jsonValues(vs []Value) string {
+	ss := make([]string, len(vs))
+	for i, v := range vs {
+		ss[i] = jsonValue(v)
+	}
+	return jsonArray(ss)
+}
+
+func jsonValue(v Value) string {
+	o := object{}
+	o.add("type", jsonString(v.Type.String()))
+	switch {
+	case v.NaN == sexp.NaNKindCanonical:
+		o.add("value", jsonString("nan:canonical"))
+	case v.NaN == sexp.NaNKindArithmetic:
+		o.add("value", jsonString("nan:arithmetic"))
+	case v.Null:
+		o.add("value", jsonString("null"))
+	case v.Any:
+	default:
+		o.add("value", jsonString(strconv.FormatUint(v.Bits, 10)))
+	}
+	return o.String()
+}
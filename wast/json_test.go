@@ -0,0 +1,248 @@
+package wast
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/pretty"
+)
+
+// jsonTestScript is a script whose actions refer to functions and globals its modules export.
+const jsonTestScript = `(module $m
+  (func (export "add") (param i32 i32) (result i32)
+    (i32.add (local.get 0) (local.get 1)))
+  (func (export "div") (param i64 i64) (result i64)
+    (i64.div_s (local.get 0) (local.get 1)))
+  (func $loop (export "loop") (call $loop))
+  (global (export "g") f32 (f32.const 0)))
+(register "m" $m)
+(module binary "\00asm\01\00\00\00" "\01\05\01\60\00\01\7c" "\03\02\01\00" "\07\05\01\01f\00\00"
+  "\0a\0d\01\0b\00\44\00\00\00\00\00\00\f0\3f\0b")
+(invoke "f")
+(module quote "(func (export \"q\") (result i32) (i32.const 1))")
+(assert_return (invoke "q") (i32.const 1))
+(assert_return (invoke $m "add" (i32.const 0xffffffff) (i32.const 1)) (i32.const 0))
+(get $m "g")
+(assert_trap (invoke $m "div" (i64.const 1) (i64.const 0)) "integer divide by zero")
+(assert_trap (module (func $f unreachable) (start $f)) "unreachable")
+(assert_exhaustion (invoke $m "loop") "call stack exhausted")
+(assert_malformed (module quote "(func") "unexpected end")
+(assert_invalid (module (func (result i32))) "type mismatch")
+(assert_unlinkable (module (import "m" "f" (func))) "unknown import")
+`
+
+func TestToJSON(t *testing.T) {
+	script, err := Parse(jsonTestScript)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, files, err := ToJSON(script, &JSONOptions{SourceFilename: "dir/test.wast"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var manifest interface{}
+	err = json.Unmarshal(b, &manifest)
+	if err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, b)
+	}
+
+	expected := map[string]interface{}{
+		"source_filename": "dir/test.wast",
+		"commands": []interface{}{
+			map[string]interface{}{"type": "module", "line": 1.0, "name": "$m", "filename": "test.0.wat"},
+			map[string]interface{}{"type": "register", "line": 8.0, "name": "$m", "as": "m"},
+			map[string]interface{}{"type": "module", "line": 9.0, "filename": "test.1.wasm"},
+			map[string]interface{}{"type": "action", "line": 11.0, "action": map[string]interface{}{
+				"type": "invoke", "field": "f", "args": []interface{}{},
+			}, "expected": []interface{}{
+				map[string]interface{}{"type": "f64"},
+			}},
+			map[string]interface{}{"type": "module", "line": 12.0, "filename": "test.2.wat"},
+			map[string]interface{}{"type": "assert_return", "line": 13.0, "action": map[string]interface{}{
+				"type": "invoke", "field": "q", "args": []interface{}{},
+			}, "expected": []interface{}{
+				map[string]interface{}{"type": "i32", "value": "1"},
+			}},
+			map[string]interface{}{"type": "assert_return", "line": 14.0, "action": map[string]interface{}{
+				"type": "invoke", "module": "$m", "field": "add", "args": []interface{}{
+					map[string]interface{}{"type": "i32", "value": "4294967295"},
+					map[string]interface{}{"type": "i32", "value": "1"},
+				},
+			}, "expected": []interface{}{
+				map[string]interface{}{"type": "i32", "value": "0"},
+			}},
+			map[string]interface{}{"type": "action", "line": 15.0, "action": map[string]interface{}{
+				"type": "get", "module": "$m", "field": "g",
+			}, "expected": []interface{}{
+				map[string]interface{}{"type": "f32"},
+			}},
+			map[string]interface{}{"type": "assert_trap", "line": 16.0, "action": map[string]interface{}{
+				"type": "invoke", "module": "$m", "field": "div", "args": []interface{}{
+					map[string]interface{}{"type": "i64", "value": "1"},
+					map[string]interface{}{"type": "i64", "value": "0"},
+				},
+			}, "text": "integer divide by zero", "expected": []interface{}{
+				map[string]interface{}{"type": "i64"},
+			}},
+			map[string]interface{}{"type": "assert_uninstantiable", "line": 17.0, "filename": "test.3.wat", "text": "unreachable", "module_type": "text"},
+			map[string]interface{}{"type": "assert_exhaustion", "line": 18.0, "action": map[string]interface{}{
+				"type": "invoke", "module": "$m", "field": "loop", "args": []interface{}{},
+			}, "text": "call stack exhausted", "expected": []interface{}{}},
+			map[string]interface{}{"type": "assert_malformed", "line": 19.0, "filename": "test.4.wat", "text": "unexpected end", "module_type": "text"},
+			map[string]interface{}{"type": "assert_invalid", "line": 20.0, "filename": "test.5.wat", "text": "type mismatch", "module_type": "text"},
+			map[string]interface{}{"type": "assert_unlinkable", "line": 21.0, "filename": "test.6.wat", "text": "unknown import", "module_type": "text"},
+		},
+	}
+	if diff := pretty.Compare(expected, manifest); diff != "" {
+		t.Fatalf("\n%s", diff)
+	}
+
+	names := []string{}
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	expectedNames := []string{"test.0.wat", "test.1.wasm", "test.2.wat", "test.3.wat", "test.4.wat", "test.5.wat", "test.6.wat"}
+	if diff := pretty.Compare(expectedNames, names); diff != "" {
+		t.Fatalf("\n%s", diff)
+	}
+	if string(files[1].Data[:8]) != "\x00asm\x01\x00\x00\x00" {
+		t.Fatalf("\nExpected: %q\nActual:   %q", "\x00asm\x01\x00\x00\x00", files[1].Data[:8])
+	}
+	if string(files[4].Data) != "(func" {
+		t.Fatalf("\nExpected: %q\nActual:   %q", "(func", files[4].Data)
+	}
+}
+
+func TestToJSONCompile(t *testing.T) {
+	script, err := Parse(`(module $m (func (export "f") (result i32) (i32.const 1)))
+(invoke "f")
+(assert_malformed (module (func (i32.const))) "type mismatch")
+(assert_malformed (module quote "(func") "unexpected end")`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	compile := func(m *Module) ([]byte, error) {
+		if m.Line() == 3 {
+			return nil, errors.New("type mismatch")
+		}
+		// exports "f" returning i32
+		return []byte("\x00asm\x01\x00\x00\x00\x01\x05\x01\x60\x00\x01\x7f\x03\x02\x01\x00" +
+			"\x07\x05\x01\x01f\x00\x00\x0a\x06\x01\x04\x00\x41\x01\x0b"), nil
+	}
+	b, files, err := ToJSON(script, &JSONOptions{SourceFilename: "test.wast", ModuleBase: "out", Compile: compile})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := `{"source_filename": "test.wast",
+ "commands": [
+  {"type": "module", "line": 1, "name": "$m", "filename": "out.0.wasm"}, 
+  {"type": "action", "line": 2, "action": {"type": "invoke", "field": "f", "args": []}, "expected": [{"type": "i32"}]}, 
+  {"type": "assert_malformed", "line": 3, "filename": "out.1.wat", "text": "type mismatch", "module_type": "text"}, 
+  {"type": "assert_malformed", "line": 4, "filename": "out.2.wat", "text": "unexpected end", "module_type": "text"}]}
+`
+	if string(b) != expected {
+		t.Fatalf("\nExpected: %s\nActual:   %s", expected, b)
+	}
+	if len(files) != 3 || string(files[0].Data[:4]) != "\x00asm" || string(files[1].Data) != "(module (func (i32.const)))\n" {
+		t.Fatalf("unexpected files: %q", files)
+	}
+}
+
+func TestToJSONError(t *testing.T) {
+	compile := func(m *Module) ([]byte, error) {
+		return nil, errors.New("cannot compile")
+	}
+
+	testData := []struct {
+		Name     string
+		Pattern  string
+		Compile  func(m *Module) ([]byte, error)
+		Expected string
+	}{
+		{
+			Name:     "pattern 1 - compile error of a module",
+			Pattern:  `(module (func))`,
+			Compile:  compile,
+			Expected: "1:1: cannot compile",
+		},
+		{
+			Name:     "pattern 2 - compile error of assert_invalid",
+			Pattern:  `(assert_invalid (module (func (result i32))) "type mismatch")`,
+			Compile:  compile,
+			Expected: "1:17: cannot compile",
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			script, err := Parse(data.Pattern)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			_, _, err = ToJSON(script, &JSONOptions{SourceFilename: "test.wast", Compile: data.Compile})
+			if err == nil || err.Error() != data.Expected {
+				t.Fatalf("\nExpected: %s\nActual:   %v", data.Expected, err)
+			}
+		})
+	}
+}
+
+func TestToJSONUnknownTypes(t *testing.T) {
+	testData := []struct {
+		Name     string
+		Pattern  string
+		Expected string
+	}{
+		{
+			Name:     "pattern 1 - no function export",
+			Pattern:  `(module (func (export "f"))) (invoke "g")`,
+			Expected: `{"type": "action", "line": 1, "action": {"type": "invoke", "field": "g", "args": []}}`,
+		},
+		{
+			Name:     "pattern 2 - no global export",
+			Pattern:  `(module (func (export "f"))) (assert_trap (get "f") "unreachable")`,
+			Expected: `{"type": "assert_trap", "line": 1, "action": {"type": "get", "field": "f"}, "text": "unreachable"}`,
+		},
+		{
+			Name:     "pattern 3 - unknown module",
+			Pattern:  `(module $m) (assert_exhaustion (invoke $n "f") "call stack exhausted")`,
+			Expected: `{"type": "assert_exhaustion", "line": 1, "action": {"type": "invoke", "module": "$n", "field": "f", "args": []}, "text": "call stack exhausted"}`,
+		},
+		{
+			Name:     "pattern 4 - no module",
+			Pattern:  `(invoke "f")`,
+			Expected: `{"type": "action", "line": 1, "action": {"type": "invoke", "field": "f", "args": []}}`,
+		},
+		{
+			Name:     "pattern 5 - binary module that cannot be read",
+			Pattern:  `(module binary "\00asm\01\00\00\00\07") (invoke "f")`,
+			Expected: `{"type": "action", "line": 1, "action": {"type": "invoke", "field": "f", "args": []}}`,
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			script, err := Parse(data.Pattern)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			b, _, err := ToJSON(script, &JSONOptions{SourceFilename: "test.wast"})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !strings.Contains(string(b), data.Expected+"]}") {
+				t.Fatalf("\nExpected: %s\nActual:   %s", data.Expected, b)
+			}
+		})
+	}
+}
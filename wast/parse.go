@@ -0,0 +1,281 @@
+package wast
+
+import (
+	"fmt"
+
+	"github.com/bearmini/sexp"
+)
+
+// Parse parses a WebAssembly script.
+func Parse(src string) (*Script, error) {
+	forms, err := sexp.ParseAll(src)
+	if err != nil {
+		return nil, err
+	}
+
+	script := &Script{Commands: []Command{}}
+	for _, form := range forms {
+		c, err := parseCommand(form)
+		if err != nil {
+			return nil, err
+		}
+		script.Commands = append(script.Commands, c)
+	}
+	return script, nil
+}
+
+func errorf(s *sexp.Sexp, format string, args ...interface{}) error {
+	return &sexp.SyntaxError{Pos: s.Start, Msg: fmt.Sprintf(format, args...)}
+}
+
+// expected reports that s is not what was expected.
+func expected(s *sexp.Sexp, what string) error {
+	return &sexp.SyntaxError{Pos: s.Start, Expected: what, Found: describe(s)}
+}
+
+// missing reports that the list s ends where something was expected.
+func missing(s *sexp.Sexp, what string) error {
+	pos := s.End
+	pos.Offset--
+	pos.Column-- // the close paren
+	return &sexp.SyntaxError{Pos: pos, Expected: what, Found: `")"`}
+}
+
+func describe(s *sexp.Sexp) string {
+	if s.IsAtom() {
+		return fmt.Sprintf("%q", s.Atom.Value)
+	}
+	if name, ok := s.Head().Symbol(); ok {
+		return fmt.Sprintf("(%s ...)", name)
+	}
+	return "list"
+}
+
+// name returns the $name at the i-th element of s, if any.
+func name(s *sexp.Sexp, i int) (string, bool) {
+	n, ok := s.Nth(i).Symbol()
+	if !ok || len(n) < 2 || n[0] != '$' {
+		return "", false
+	}
+	return n, true
+}
+
+// str returns the decoded string at the i-th element of s.
+func str(s *sexp.Sexp, i int, what string) (string, error) {
+	e := s.Nth(i)
+	if e == nil {
+		return "", missing(s, what)
+	}
+	if !e.IsString() {
+		return "", expected(e, what)
+	}
+	v, err := e.Atom.StringValue()
+	if err != nil {
+		return "", err
+	}
+	return v, nil
+}
+
+func parseCommand(s *sexp.Sexp) (Command, error) {
+	head, ok := s.Head().Symbol()
+	if !ok {
+		return nil, expected(s, "command")
+	}
+
+	switch head {
+	case "module":
+		return parseModule(s)
+	case "register":
+		return parseRegister(s)
+	case "invoke", "get":
+		a, err := parseAction(s)
+		if err != nil {
+			return nil, err
+		}
+		return &ActionCommand{Pos: s.Start, Action: a}, nil
+	case "assert_return":
+		return parseAssertReturn(s)
+	case "assert_trap":
+		return parseAssertTrap(s)
+	case "assert_exhaustion":
+		a, msg, err := parseActionAssertion(s)
+		if err != nil {
+			return nil, err
+		}
+		return &AssertExhaustion{Pos: s.Start, Action: a, Message: msg}, nil
+	case "assert_malformed", "assert_invalid", "assert_unlinkable":
+		m, msg, err := parseModuleAssertion(s)
+		if err != nil {
+			return nil, err
+		}
+		switch head {
+		case "assert_malformed":
+			return &AssertMalformed{Pos: s.Start, Module: m, Message: msg}, nil
+		case "assert_invalid":
+			return &AssertInvalid{Pos: s.Start, Module: m, Message: msg}, nil
+		}
+		return &AssertUnlinkable{Pos: s.Start, Module: m, Message: msg}, nil
+	}
+	return nil, errorf(s.Head(), "unknown command %s", head)
+}
+
+func parseModule(s *sexp.Sexp) (*Module, error) {
+	if !s.HasHead("module") {
+		return nil, expected(s, "(module ...)")
+	}
+
+	m := &Module{Pos: s.Start, Kind: ModuleKindText, Form: s}
+	i := 1
+	if n, ok := name(s, i); ok {
+		m.Name = n
+		i++
+	}
+
+	switch {
+	case s.Nth(i).IsSymbol("binary"):
+		m.Kind = ModuleKindBinary
+	case s.Nth(i).IsSymbol("quote"):
+		m.Kind = ModuleKindQuote
+	default:
+		return m, nil
+	}
+
+	m.Strings = []*sexp.Sexp{}
+	for _, e := range s.Children[i+1:] {
+		if !e.IsString() {
+			return nil, expected(e, "string")
+		}
+		m.Strings = append(m.Strings, e)
+	}
+	return m, nil
+}
+
+func parseRegister(s *sexp.Sexp) (*Register, error) {
+	as, err := str(s, 1, "module name")
+	if err != nil {
+		return nil, err
+	}
+	r := &Register{Pos: s.Start, As: as}
+	if n, ok := name(s, 2); ok {
+		r.Module = n
+	}
+	if s.Len() > 2 && r.Module == "" || s.Len() > 3 {
+		return nil, errorf(s, "register should have a name and an optional module")
+	}
+	return r, nil
+}
+
+func parseAction(s *sexp.Sexp) (*Action, error) {
+	a := &Action{Pos: s.Start}
+	switch {
+	case s.HasHead("invoke"):
+		a.Kind = ActionKindInvoke
+	case s.HasHead("get"):
+		a.Kind = ActionKindGet
+	default:
+		return nil, expected(s, "action")
+	}
+
+	i := 1
+	if n, ok := name(s, i); ok {
+		a.Module = n
+		i++
+	}
+	field, err := str(s, i, "export name")
+	if err != nil {
+		return nil, err
+	}
+	a.Field = field
+	i++
+
+	if a.Kind == ActionKindGet {
+		if s.Len() > i {
+			return nil, errorf(s.Nth(i), "get should have no arguments")
+		}
+		return a, nil
+	}
+
+	a.Args = []Value{}
+	for _, e := range s.Children[i:] {
+		v, err := parseValue(e, false)
+		if err != nil {
+			return nil, err
+		}
+		a.Args = append(a.Args, v)
+	}
+	return a, nil
+}
+
+func parseAssertReturn(s *sexp.Sexp) (*AssertReturn, error) {
+	if s.Len() < 2 {
+		return nil, missing(s, "action")
+	}
+	a, err := parseAction(s.Nth(1))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &AssertReturn{Pos: s.Start, Action: a, Expected: []Value{}}
+	for _, e := range s.Children[2:] {
+		v, err := parseValue(e, true)
+		if err != nil {
+			return nil, err
+		}
+		c.Expected = append(c.Expected, v)
+	}
+	return c, nil
+}
+
+func parseAssertTrap(s *sexp.Sexp) (*AssertTrap, error) {
+	if s.Nth(1).HasHead("module") {
+		m, msg, err := parseModuleAssertion(s)
+		if err != nil {
+			return nil, err
+		}
+		return &AssertTrap{Pos: s.Start, Module: m, Message: msg}, nil
+	}
+
+	a, msg, err := parseActionAssertion(s)
+	if err != nil {
+		return nil, err
+	}
+	return &AssertTrap{Pos: s.Start, Action: a, Message: msg}, nil
+}
+
+// parseActionAssertion parses an assertion of the form (assert_xxx action "message").
+func parseActionAssertion(s *sexp.Sexp) (*Action, string, error) {
+	if s.Len() < 2 {
+		return nil, "", missing(s, "action")
+	}
+	a, err := parseAction(s.Nth(1))
+	if err != nil {
+		return nil, "", err
+	}
+	msg, err := str(s, 2, "failure message")
+	if err != nil {
+		return nil, "", err
+	}
+	if s.Len() > 3 {
+		return nil, "", errorf(s.Nth(3), "unexpected argument")
+	}
+	return a, msg, nil
+}
+
+// parseModuleAssertion parses an assertion of the form (assert_xxx module "message").
+func parseModuleAssertion(s *sexp.Sexp) (*Module, string, error) {
+	if s.Len() < 2 {
+		return nil, "", missing(s, "module")
+	}
+	m, err := parseModule(s.Nth(1))
+	if err != nil {
+		return nil, "", err
+	}
+	msg, err := str(s, 2, "failure message")
+	if err != nil {
+		return nil, "", err
+	}
+	if s.Len() > 3 {
+		return nil, "", errorf(s.Nth(3), "unexpected argument")
+	}
+	return m, msg, nil
+}
@@ -0,0 +1,221 @@
+package wast
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/bearmini/sexp"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+const testScript = `(module $m
+  (func (export "add") (param i32 i32) (result i32)
+    (i32.add (local.get 0) (local.get 1))))
+(register "m" $m)
+(module binary "\00asm" "\01\00\00\00")
+(module quote "(func)")
+(invoke "add" (i32.const 1) (i32.const -1))
+(assert_return (invoke $m "add" (i32.const 0xffffffff) (i32.const 1)) (i32.const 0))
+(assert_return (get "g") (f32.const nan:canonical))
+(assert_return (invoke "f" (f64.const -0x1p-1) (ref.null extern)) (f64.const nan:arithmetic) (ref.extern))
+(assert_trap (invoke "div" (i64.const 1) (i64.const 0)) "integer divide by zero")
+(assert_trap (module (func $f unreachable) (start $f)) "unreachable")
+(assert_exhaustion (invoke "loop") "call stack exhausted")
+(assert_malformed (module quote "(func") "unexpected end")
+(assert_invalid (module (func (result i32))) "type mismatch")
+(assert_unlinkable (module (import "m" "f" (func))) "unknown import")
+`
+
+// summarize returns the commands without the module forms and positions that make them hard to compare.
+func summarize(script *Script) []interface{} {
+	cs := []interface{}{}
+	for _, c := range script.Commands {
+		switch c := c.(type) {
+		case *Module:
+			cs = append(cs, []interface{}{"module", c.Line(), c.Name, c.Kind, len(c.Strings)})
+		case *Register:
+			cs = append(cs, []interface{}{"register", c.Line(), c.As, c.Module})
+		case *ActionCommand:
+			cs = append(cs, []interface{}{"action", c.Line(), summarizeAction(c.Action)})
+		case *AssertReturn:
+			cs = append(cs, []interface{}{"assert_return", c.Line(), summarizeAction(c.Action), values(c.Expected)})
+		case *AssertTrap:
+			if c.Module != nil {
+				cs = append(cs, []interface{}{"assert_trap", c.Line(), "module", c.Message})
+			} else {
+				cs = append(cs, []interface{}{"assert_trap", c.Line(), summarizeAction(c.Action), c.Message})
+			}
+		case *AssertExhaustion:
+			cs = append(cs, []interface{}{"assert_exhaustion", c.Line(), summarizeAction(c.Action), c.Message})
+		case *AssertMalformed:
+			cs = append(cs, []interface{}{"assert_malformed", c.Line(), c.Module.Kind, c.Message})
+		case *AssertInvalid:
+			cs = append(cs, []interface{}{"assert_invalid", c.Line(), c.Module.Kind, c.Message})
+		case *AssertUnlinkable:
+			cs = append(cs, []interface{}{"assert_unlinkable", c.Line(), c.Module.Kind, c.Message})
+		}
+	}
+	return cs
+}
+
+func summarizeAction(a *Action) []interface{} {
+	return []interface{}{a.Kind, a.Module, a.Field, values(a.Args)}
+}
+
+func values(vs []Value) []string {
+	ss := []string{}
+	for _, v := range vs {
+		ss = append(ss, v.String())
+	}
+	return ss
+}
+
+func TestParse(t *testing.T) {
+	script, err := Parse(testScript)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	expected := []interface{}{
+		[]interface{}{"module", 1, "$m", ModuleKindText, 0},
+		[]interface{}{"register", 4, "m", "$m"},
+		[]interface{}{"module", 5, "", ModuleKindBinary, 2},
+		[]interface{}{"module", 6, "", ModuleKindQuote, 1},
+		[]interface{}{"action", 7, []interface{}{ActionKindInvoke, "", "add", []string{"i32:1", "i32:-1"}}},
+		[]interface{}{"assert_return", 8, []interface{}{ActionKindInvoke, "$m", "add", []string{"i32:-1", "i32:1"}}, []string{"i32:0"}},
+		[]interface{}{"assert_return", 9, []interface{}{ActionKindGet, "", "g", []string{}}, []string{"f32:nan:canonical"}},
+		[]interface{}{"assert_return", 10, []interface{}{ActionKindInvoke, "", "f", []string{"f64:-0.5", "externref:null"}}, []string{"f64:nan:arithmetic", "externref"}},
+		[]interface{}{"assert_trap", 11, []interface{}{ActionKindInvoke, "", "div", []string{"i64:1", "i64:0"}}, "integer divide by zero"},
+		[]interface{}{"assert_trap", 12, "module", "unreachable"},
+		[]interface{}{"assert_exhaustion", 13, []interface{}{ActionKindInvoke, "", "loop", []string{}}, "call stack exhausted"},
+		[]interface{}{"assert_malformed", 14, ModuleKindQuote, "unexpected end"},
+		[]interface{}{"assert_invalid", 15, ModuleKindText, "type mismatch"},
+		[]interface{}{"assert_unlinkable", 16, ModuleKindText, "unknown import"},
+	}
+	a := summarize(script)
+	if !reflect.DeepEqual(expected, a) {
+		t.Fatalf("\n%s", pretty.Compare(expected, a))
+	}
+}
+
+func TestParseValue(t *testing.T) {
+	testData := []struct {
+		Pattern  string
+		Result   bool
+		Expected Value
+	}{
+		{Pattern: "(i32.const -0x8000_0000)", Expected: Value{Type: ValueTypeI32, Bits: 0x80000000}},
+		{Pattern: "(i64.const -1)", Expected: Value{Type: ValueTypeI64, Bits: math.MaxUint64}},
+		{Pattern: "(f32.const -nan:0x1)", Expected: Value{Type: ValueTypeF32, Bits: 0xff800001}},
+		{Pattern: "(f64.const inf)", Expected: Value{Type: ValueTypeF64, Bits: 0x7ff0000000000000}},
+		{Pattern: "(f32.const nan:canonical)", Result: true, Expected: Value{Type: ValueTypeF32, Bits: 0x7fc00000, NaN: sexp.NaNKindCanonical}},
+		{Pattern: "(ref.null func)", Expected: Value{Type: ValueTypeFuncRef, Null: true}},
+		{Pattern: "(ref.extern 3)", Expected: Value{Type: ValueTypeExternRef, Bits: 3}},
+		{Pattern: "(ref.func)", Result: true, Expected: Value{Type: ValueTypeFuncRef, Any: true}},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Pattern, func(t *testing.T) {
+			//t.Parallel()
+
+			a, err := parseValue(sexp.MustParse(data.Pattern), data.Result)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if data.Expected != a {
+				t.Fatalf("\n%s", pretty.Compare(data.Expected, a))
+			}
+		})
+	}
+}
+
+func TestParseError(t *testing.T) {
+	testData := []struct {
+		Name     string
+		Pattern  string
+		Expected string
+	}{
+		{
+			Name:     "pattern 1 - unknown command",
+			Pattern:  "(module)\n(assert_foo)",
+			Expected: "2:2: unknown command assert_foo",
+		},
+		{
+			Name:     "pattern 2 - out of range",
+			Pattern:  `(invoke "f" (i32.const 0x1_0000_0000))`,
+			Expected: "1:24: invalid i32.const: 0x1_0000_0000",
+		},
+		{
+			Name:     "pattern 3 - result pattern in arguments",
+			Pattern:  `(invoke "f" (f32.const nan:canonical))`,
+			Expected: "1:24: nan:canonical is only allowed in expected results",
+		},
+		{
+			Name:     "pattern 4 - missing message",
+			Pattern:  `(assert_trap (invoke "f"))`,
+			Expected: `1:26: expected failure message, but found ")"`,
+		},
+		{
+			Name:     "pattern 5 - not a string",
+			Pattern:  `(module binary "\00asm" 1)`,
+			Expected: `1:25: expected string, but found "1"`,
+		},
+		{
+			Name:     "pattern 6 - syntax error",
+			Pattern:  `(module`,
+			Expected: `1:8: expected ")", but found EOF: "(module"`,
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			_, err := Parse(data.Pattern)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if data.Expected != err.Error() {
+				t.Fatalf("\nExpected: %s\nActual:   %s", data.Expected, err.Error())
+			}
+		})
+	}
+}
+
+func TestModuleBytes(t *testing.T) {
+	script, err := Parse("(module binary \"\\00asm\" \"\\01\\00\\00\\00\")\n(module quote \"(func)\" \" (memory 1)\")\n(module binary\n  \"\\00as\\m\")\n(module)")
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	b, err := script.Commands[0].(*Module).Bytes()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if string(b) != "\x00asm\x01\x00\x00\x00" {
+		t.Fatalf("\nExpected: %q\nActual:   %q", "\x00asm\x01\x00\x00\x00", b)
+	}
+
+	b, err = script.Commands[1].(*Module).Bytes()
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if string(b) != "(func) (memory 1)" {
+		t.Fatalf("\nExpected: %q\nActual:   %q", "(func) (memory 1)", b)
+	}
+
+	_, err = script.Commands[2].(*Module).Bytes()
+	expectedErr := `4:9: unknown escape sequence "\\m"`
+	if err == nil || expectedErr != err.Error() {
+		t.Fatalf("\nExpected: %s\nActual:   %v", expectedErr, err)
+	}
+
+	_, err = script.Commands[3].(*Module).Bytes()
+	expectedErr = "5:1: module is not binary or quoted"
+	if err == nil || expectedErr != err.Error() {
+		t.Fatalf("\nExpected: %s\nActual:   %v", expectedErr, err)
+	}
+}
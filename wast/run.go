@@ -0,0 +1,331 @@
+package wast
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/bearmini/sexp"
+)
+
+// Instance is an engine's handle to an instantiated module.
+type Instance interface{}
+
+// Engine runs modules for a Runner.
+//
+// Errors returned for traps and call stack exhaustion should contain the message of
+// the specification, such as "integer divide by zero". An engine that cannot run a
+// command, for example because it only accepts binary modules, returns ErrUnsupported
+// and the command is skipped.
+type Engine interface {
+	// Instantiate decodes, validates and instantiates a module of any kind.
+	Instantiate(m *Module) (Instance, error)
+
+	// Register makes the exports of an instance available for import under a name.
+	Register(inst Instance, as string) error
+
+	// Invoke calls an exported function.
+	Invoke(inst Instance, field string, args []Value) ([]Value, error)
+
+	// Get returns the value of an exported global.
+	Get(inst Instance, field string) (Value, error)
+}
+
+// ErrUnsupported is returned by an engine for a command it cannot run.
+var ErrUnsupported = errors.New("unsupported")
+
+type Status int
+
+const (
+	StatusPass Status = iota
+	StatusFail
+	StatusSkip
+)
+
+var statusNames = []string{"pass", "fail", "skip"}
+
+func (s Status) String() string {
+	if int(s) < len(statusNames) {
+		return statusNames[s]
+	}
+	return fmt.Sprintf("Status(%d)", int(s))
+}
+
+// Result is the outcome of a command.
+type Result struct {
+	Command Command
+	Status  Status
+	Err     error // why the command failed or was skipped
+}
+
+func (r *Result) String() string {
+	s := fmt.Sprintf("%d: %s: %s", r.Command.Line(), commandName(r.Command), r.Status)
+	if r.Err != nil {
+		s += ": " + r.Err.Error()
+	}
+	return s
+}
+
+// Report is the outcome of a script, command by command.
+type Report struct {
+	Results []*Result
+}
+
+// Count returns the number of commands with a status.
+func (r *Report) Count(s Status) int {
+	n := 0
+	for _, res := range r.Results {
+		if res.Status == s {
+			n++
+		}
+	}
+	return n
+}
+
+// OK reports whether no command failed.
+func (r *Report) OK() bool {
+	return r.Count(StatusFail) == 0
+}
+
+// WriteTo writes a line for each command that did not pass, then a summary line.
+func (r *Report) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	for _, res := range r.Results {
+		if res.Status != StatusPass {
+			b.WriteString(res.String())
+			b.WriteByte('\n')
+		}
+	}
+	fmt.Fprintf(&b, "%d passed, %d failed, %d skipped\n", r.Count(StatusPass), r.Count(StatusFail), r.Count(StatusSkip))
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func commandName(c Command) string {
+	switch c := c.(type) {
+	case *Module:
+		return "module"
+	case *Register:
+		return "register"
+	case *ActionCommand:
+		if c.Action.Kind == ActionKindGet {
+			return "get"
+		}
+		return "invoke"
+	case *AssertReturn:
+		return "assert_return"
+	case *AssertTrap:
+		return "assert_trap"
+	case *AssertExhaustion:
+		return "assert_exhaustion"
+	case *AssertMalformed:
+		return "assert_malformed"
+	case *AssertInvalid:
+		return "assert_invalid"
+	case *AssertUnlinkable:
+		return "assert_unlinkable"
+	}
+	return fmt.Sprintf("%T", c)
+}
+
+// Runner runs the commands of scripts against an engine. The modules a script
+// defines stay available to the scripts run after it.
+type Runner struct {
+	engine  Engine
+	current Instance
+	named   map[string]Instance
+}
+
+// NewRunner returns a runner for an engine.
+func NewRunner(e Engine) *Runner {
+	return &Runner{engine: e, named: map[string]Instance{}}
+}
+
+// Run runs every command of a script, whether or not the previous ones passed.
+func (r *Runner) Run(script *Script) *Report {
+	report := &Report{Results: []*Result{}}
+	for _, c := range script.Commands {
+		res := &Result{Command: c, Status: StatusPass}
+		err := r.run(c)
+		switch {
+		case errors.Is(err, ErrUnsupported):
+			res.Status = StatusSkip
+			res.Err = err
+		case err != nil:
+			res.Status = StatusFail
+			res.Err = err
+		}
+		report.Results = append(report.Results, res)
+	}
+	return report
+}
+
+func (r *Runner) run(c Command) error {
+	switch c := c.(type) {
+	case *Module:
+		// A module that fails to instantiate leaves no current module behind, so that
+		// the commands meant for it fail rather than run against the previous one.
+		r.current = nil
+		inst, err := r.engine.Instantiate(c)
+		if err != nil {
+			return err
+		}
+		r.current = inst
+		if c.Name != "" {
+			r.named[c.Name] = inst
+		}
+		return nil
+	case *Register:
+		inst, err := r.instance(c.Module)
+		if err != nil {
+			return err
+		}
+		return r.engine.Register(inst, c.As)
+	case *ActionCommand:
+		_, err := r.action(c.Action)
+		return err
+	case *AssertReturn:
+		return r.assertReturn(c)
+	case *AssertTrap:
+		if c.Module != nil {
+			_, err := r.engine.Instantiate(c.Module)
+			return expectError(err, c.Message, "trap")
+		}
+		results, err := r.action(c.Action)
+		if err == nil {
+			return fmt.Errorf("expected trap %q, got %s", c.Message, formatValues(results))
+		}
+		return expectError(err, c.Message, "trap")
+	case *AssertExhaustion:
+		results, err := r.action(c.Action)
+		if err == nil {
+			return fmt.Errorf("expected exhaustion %q, got %s", c.Message, formatValues(results))
+		}
+		return expectError(err, c.Message, "exhaustion")
+	case *AssertMalformed:
+		return r.assertModuleError(c.Module, "malformed")
+	case *AssertInvalid:
+		return r.assertModuleError(c.Module, "invalid")
+	case *AssertUnlinkable:
+		return r.assertModuleError(c.Module, "unlinkable")
+	}
+	return fmt.Errorf("unknown command %T", c)
+}
+
+// instance returns the instance of the module with a $name, or the current one for "".
+func (r *Runner) instance(name string) (Instance, error) {
+	if name == "" {
+		if r.current == nil {
+			return nil, errors.New("no module")
+		}
+		return r.current, nil
+	}
+	inst, ok := r.named[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown module %s", name)
+	}
+	return inst, nil
+}
+
+func (r *Runner) action(a *Action) ([]Value, error) {
+	inst, err := r.instance(a.Module)
+	if err != nil {
+		return nil, err
+	}
+	if a.Kind == ActionKindGet {
+		v, err := r.engine.Get(inst, a.Field)
+		if err != nil {
+			return nil, err
+		}
+		return []Value{v}, nil
+	}
+	return r.engine.Invoke(inst, a.Field, a.Args)
+}
+
+func (r *Runner) assertReturn(c *AssertReturn) error {
+	results, err := r.action(c.Action)
+	if err != nil {
+		return err
+	}
+	if len(results) != len(c.Expected) {
+		return fmt.Errorf("expected %s, got %s", formatValues(c.Expected), formatValues(results))
+	}
+	for i, v := range results {
+		if !Match(c.Expected[i], v) {
+			return fmt.Errorf("expected %s, got %s", formatValues(c.Expected), formatValues(results))
+		}
+	}
+	return nil
+}
+
+// assertModuleError checks that a module fails to decode, validate or link. The
+// messages of engines differ too much at this stage for them to be compared.
+func (r *Runner) assertModuleError(m *Module, what string) error {
+	_, err := r.engine.Instantiate(m)
+	if err == nil {
+		return fmt.Errorf("expected %s module, but it was instantiated", what)
+	}
+	if errors.Is(err, ErrUnsupported) {
+		return err
+	}
+	return nil
+}
+
+// expectError checks that err contains the expected message.
+func expectError(err error, message, what string) error {
+	if err == nil {
+		return fmt.Errorf("expected %s %q, but there was none", what, message)
+	}
+	if errors.Is(err, ErrUnsupported) {
+		return err
+	}
+	if !strings.Contains(err.Error(), message) {
+		return fmt.Errorf("expected %s %q, got %q", what, message, err.Error())
+	}
+	return nil
+}
+
+func formatValues(vs []Value) string {
+	if len(vs) == 0 {
+		return "no results"
+	}
+	ss := make([]string, len(vs))
+	for i, v := range vs {
+		ss[i] = v.String()
+	}
+	return strings.Join(ss, " ")
+}
+
+// Match reports whether an actual value matches an expected result. Floats are
+// compared bit for bit, except that nan:canonical and nan:arithmetic match any NaN
+// of their class; a reference without an index matches any non-null reference.
+func Match(expected, actual Value) bool {
+	if expected.Type != actual.Type {
+		return false
+	}
+
+	switch expected.NaN {
+	case sexp.NaNKindCanonical:
+		if expected.Type == ValueTypeF32 {
+			return uint32(actual.Bits)&0x7fffffff == 0x7fc00000
+		}
+		return actual.Bits&0x7fffffffffffffff == 0x7ff8000000000000
+	case sexp.NaNKindArithmetic:
+		if expected.Type == ValueTypeF32 {
+			return uint32(actual.Bits)&0x7fc00000 == 0x7fc00000
+		}
+		return actual.Bits&0x7ff8000000000000 == 0x7ff8000000000000
+	}
+
+	switch {
+	case expected.Null || actual.Null:
+		return expected.Null == actual.Null
+	case expected.Any:
+		return true
+	}
+	if expected.Type == ValueTypeI32 || expected.Type == ValueTypeF32 {
+		return uint32(expected.Bits) == uint32(actual.Bits)
+	}
+	return expected.Bits == actual.Bits
+}
@@ -0,0 +1,194 @@
+package wast
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/bearmini/sexp"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+// fakeEngine instantiates every module as a map of canned exports. A module whose
+// first field is (fail "message") fails to instantiate with that message.
+type fakeEngine struct {
+	registered []string
+}
+
+type fakeInstance struct {
+	name string
+}
+
+var fakeExports = map[string]func(args []Value) ([]Value, error){
+	"add": func(args []Value) ([]Value, error) {
+		return []Value{{Type: ValueTypeI32, Bits: uint64(uint32(args[0].Bits + args[1].Bits))}}, nil
+	},
+	"div": func(args []Value) ([]Value, error) {
+		if args[1].Bits == 0 {
+			return nil, errors.New("wasm trap: integer divide by zero")
+		}
+		return []Value{{Type: ValueTypeI32, Bits: args[0].Bits / args[1].Bits}}, nil
+	},
+	"nan": func(args []Value) ([]Value, error) {
+		return []Value{{Type: ValueTypeF32, Bits: uint64(math.Float32bits(float32(math.NaN())))}}, nil
+	},
+	"loop": func(args []Value) ([]Value, error) {
+		return nil, errors.New("call stack exhausted")
+	},
+}
+
+func (e *fakeEngine) Instantiate(m *Module) (Instance, error) {
+	if m.Kind == ModuleKindQuote {
+		return nil, fmt.Errorf("text modules: %w", ErrUnsupported)
+	}
+	if f := m.Form.Nth(1); f.HasHead("fail") {
+		msg, _ := f.Nth(1).Str()
+		return nil, errors.New(msg)
+	}
+	return &fakeInstance{name: m.Name}, nil
+}
+
+func (e *fakeEngine) Register(inst Instance, as string) error {
+	e.registered = append(e.registered, inst.(*fakeInstance).name+" as "+as)
+	return nil
+}
+
+func (e *fakeEngine) Invoke(inst Instance, field string, args []Value) ([]Value, error) {
+	f, ok := fakeExports[field]
+	if !ok {
+		return nil, fmt.Errorf("unknown export %q", field)
+	}
+	return f(args)
+}
+
+func (e *fakeEngine) Get(inst Instance, field string) (Value, error) {
+	if field != "g" {
+		return Value{}, fmt.Errorf("unknown export %q", field)
+	}
+	return Value{Type: ValueTypeI64, Bits: 42}, nil
+}
+
+func TestRunner(t *testing.T) {
+	script, err := Parse(`(module $a)
+(register "a" $a)
+(assert_return (invoke "add" (i32.const 1) (i32.const 2)) (i32.const 3))
+(assert_return (invoke "add" (i32.const 1) (i32.const 2)) (i32.const 4))
+(assert_return (invoke "nan") (f32.const nan:canonical))
+(assert_return (invoke "nan") (f32.const nan:arithmetic))
+(assert_return (get $a "g") (i64.const 42))
+(assert_trap (invoke "div" (i32.const 1) (i32.const 0)) "integer divide by zero")
+(assert_trap (invoke "div" (i32.const 4) (i32.const 2)) "integer divide by zero")
+(assert_exhaustion (invoke "loop") "call stack exhausted")
+(assert_trap (module (fail "out of bounds memory access")) "out of bounds memory access")
+(assert_invalid (module (fail "type mismatch")) "type mismatch")
+(assert_malformed (module) "unexpected end")
+(assert_malformed (module quote "(func") "unexpected end")
+(invoke $b "add")
+(module (fail "unknown import"))
+(invoke "add" (i32.const 1) (i32.const 2))
+`)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+
+	e := &fakeEngine{}
+	report := NewRunner(e).Run(script)
+	results := []string{}
+	for _, res := range report.Results {
+		results = append(results, res.String())
+	}
+	expected := []string{
+		"1: module: pass",
+		"2: register: pass",
+		"3: assert_return: pass",
+		"4: assert_return: fail: expected i32:4, got i32:3",
+		"5: assert_return: pass",
+		"6: assert_return: pass",
+		"7: assert_return: pass",
+		"8: assert_trap: pass",
+		`9: assert_trap: fail: expected trap "integer divide by zero", got i32:2`,
+		"10: assert_exhaustion: pass",
+		"11: assert_trap: pass",
+		"12: assert_invalid: pass",
+		"13: assert_malformed: fail: expected malformed module, but it was instantiated",
+		"14: assert_malformed: skip: text modules: unsupported",
+		"15: invoke: fail: unknown module $b",
+		"16: module: fail: unknown import",
+		"17: invoke: fail: no module",
+	}
+	if diff := pretty.Compare(expected, results); diff != "" {
+		t.Fatalf("\n%s", diff)
+	}
+	if diff := pretty.Compare([]string{"$a as a"}, e.registered); diff != "" {
+		t.Fatalf("\n%s", diff)
+	}
+
+	var b strings.Builder
+	_, err = report.WriteTo(&b)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	expectedReport := `4: assert_return: fail: expected i32:4, got i32:3
+9: assert_trap: fail: expected trap "integer divide by zero", got i32:2
+13: assert_malformed: fail: expected malformed module, but it was instantiated
+14: assert_malformed: skip: text modules: unsupported
+15: invoke: fail: unknown module $b
+16: module: fail: unknown import
+17: invoke: fail: no module
+10 passed, 6 failed, 1 skipped
+`
+	if expectedReport != b.String() {
+		t.Fatalf("\n%s", pretty.Compare(expectedReport, b.String()))
+	}
+	if report.OK() {
+		t.Fatalf("the report should not be OK")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	f32 := func(bits uint32) Value { return Value{Type: ValueTypeF32, Bits: uint64(bits)} }
+	f64 := func(bits uint64) Value { return Value{Type: ValueTypeF64, Bits: bits} }
+	canonical32 := Value{Type: ValueTypeF32, NaN: sexp.NaNKindCanonical}
+	arithmetic32 := Value{Type: ValueTypeF32, NaN: sexp.NaNKindArithmetic}
+	canonical64 := Value{Type: ValueTypeF64, NaN: sexp.NaNKindCanonical}
+	arithmetic64 := Value{Type: ValueTypeF64, NaN: sexp.NaNKindArithmetic}
+
+	testData := []struct {
+		Name     string
+		Expected Value
+		Actual   Value
+		Match    bool
+	}{
+		{Name: "pattern 1 - same i32", Expected: Value{Type: ValueTypeI32, Bits: 1}, Actual: Value{Type: ValueTypeI32, Bits: 1}, Match: true},
+		{Name: "pattern 2 - different types", Expected: Value{Type: ValueTypeI32, Bits: 1}, Actual: Value{Type: ValueTypeI64, Bits: 1}, Match: false},
+		{Name: "pattern 3 - i32 ignores high bits", Expected: Value{Type: ValueTypeI32, Bits: 0xffffffff}, Actual: Value{Type: ValueTypeI32, Bits: 0xffffffffffffffff}, Match: true},
+		{Name: "pattern 4 - floats by bits", Expected: f32(0x80000000), Actual: f32(0), Match: false},
+		{Name: "pattern 5 - nan payload by bits", Expected: f32(0x7fc00001), Actual: f32(0x7fc00001), Match: true},
+		{Name: "pattern 6 - canonical f32", Expected: canonical32, Actual: f32(0xffc00000), Match: true},
+		{Name: "pattern 7 - canonical f32, payload", Expected: canonical32, Actual: f32(0x7fc00001), Match: false},
+		{Name: "pattern 8 - arithmetic f32, payload", Expected: arithmetic32, Actual: f32(0x7fc00001), Match: true},
+		{Name: "pattern 9 - arithmetic f32, signalling", Expected: arithmetic32, Actual: f32(0x7f800001), Match: false},
+		{Name: "pattern 10 - canonical f64", Expected: canonical64, Actual: f64(0x7ff8000000000000), Match: true},
+		{Name: "pattern 11 - canonical f64, payload", Expected: canonical64, Actual: f64(0x7ff8000000000001), Match: false},
+		{Name: "pattern 12 - arithmetic f64", Expected: arithmetic64, Actual: f64(0xfff8000000000001), Match: true},
+		{Name: "pattern 13 - arithmetic f64, infinity", Expected: arithmetic64, Actual: f64(0x7ff0000000000000), Match: false},
+		{Name: "pattern 14 - null", Expected: Value{Type: ValueTypeExternRef, Null: true}, Actual: Value{Type: ValueTypeExternRef, Null: true}, Match: true},
+		{Name: "pattern 15 - null, non-null", Expected: Value{Type: ValueTypeExternRef, Null: true}, Actual: Value{Type: ValueTypeExternRef}, Match: false},
+		{Name: "pattern 16 - any reference", Expected: Value{Type: ValueTypeFuncRef, Any: true}, Actual: Value{Type: ValueTypeFuncRef, Bits: 3}, Match: true},
+		{Name: "pattern 17 - any reference, null", Expected: Value{Type: ValueTypeFuncRef, Any: true}, Actual: Value{Type: ValueTypeFuncRef, Null: true}, Match: false},
+		{Name: "pattern 18 - extern index", Expected: Value{Type: ValueTypeExternRef, Bits: 1}, Actual: Value{Type: ValueTypeExternRef, Bits: 2}, Match: false},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			if Match(data.Expected, data.Actual) != data.Match {
+				t.Fatalf("\nExpected: %v\nActual:   %v\nMatch:    %v", data.Expected, data.Actual, data.Match)
+			}
+		})
+	}
+}
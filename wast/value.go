@@ -0,0 +1,166 @@
+package wast
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/bearmini/sexp"
+)
+
+type ValueType int
+
+const (
+	ValueTypeI32 ValueType = iota
+	ValueTypeI64
+	ValueTypeF32
+	ValueTypeF64
+	ValueTypeFuncRef
+	ValueTypeExternRef
+)
+
+var valueTypeNames = []string{"i32", "i64", "f32", "f64", "funcref", "externref"}
+
+func (t ValueType) String() string {
+	if int(t) < len(valueTypeNames) {
+		return valueTypeNames[t]
+	}
+	return fmt.Sprintf("ValueType(%d)", int(t))
+}
+
+// Value is an argument of an action or an expected result.
+type Value struct {
+	Type ValueType
+
+	// Bits is the bit pattern of a number: two's complement for integers and IEEE 754
+	// for floats, in the low bits for 32-bit types. For a reference, it is the index
+	// given by ref.extern or ref.func.
+	Bits uint64
+
+	// NaN is sexp.NaNKindCanonical or sexp.NaNKindArithmetic for an expected result
+	// that matches any NaN of that class, and sexp.NaNKindDefault otherwise.
+	NaN sexp.NaNKind
+
+	Null bool // the value is ref.null
+	Any  bool // the expected result is a reference without an index, which matches any non-null reference
+}
+
+// Float32 returns the value of an f32.
+func (v Value) Float32() float32 {
+	return math.Float32frombits(uint32(v.Bits))
+}
+
+// Float64 returns the value of an f64.
+func (v Value) Float64() float64 {
+	return math.Float64frombits(v.Bits)
+}
+
+func (v Value) String() string {
+	switch {
+	case v.NaN == sexp.NaNKindCanonical:
+		return v.Type.String() + ":nan:canonical"
+	case v.NaN == sexp.NaNKindArithmetic:
+		return v.Type.String() + ":nan:arithmetic"
+	case v.Null:
+		return v.Type.String() + ":null"
+	case v.Any:
+		return v.Type.String()
+	}
+
+	switch v.Type {
+	case ValueTypeI32:
+		return fmt.Sprintf("i32:%d", int32(v.Bits))
+	case ValueTypeI64:
+		return fmt.Sprintf("i64:%d", int64(v.Bits))
+	case ValueTypeF32:
+		return fmt.Sprintf("f32:%v", v.Float32())
+	case ValueTypeF64:
+		return fmt.Sprintf("f64:%v", v.Float64())
+	}
+	return fmt.Sprintf("%s:%d", v.Type, v.Bits)
+}
+
+// parseValue parses a constant such as (i32.const 1). If result is true, the
+// patterns only allowed in expected results are accepted as well.
+func parseValue(s *sexp.Sexp, result bool) (Value, error) {
+	op, ok := s.Head().Symbol()
+	if !ok {
+		return Value{}, expected(s, "constant")
+	}
+	arg := s.Nth(1)
+
+	switch op {
+	case "i32.const", "i64.const", "f32.const", "f64.const":
+		if s.Len() != 2 {
+			return Value{}, errorf(s, "%s should have a single number", op)
+		}
+		n, ok := arg.Number()
+		if !ok {
+			return Value{}, expected(arg, "number")
+		}
+		return numberValue(arg, op, n, result)
+	case "ref.null":
+		if s.Len() != 2 {
+			return Value{}, errorf(s, "ref.null should have a heap type")
+		}
+		switch {
+		case arg.IsSymbol("func"):
+			return Value{Type: ValueTypeFuncRef, Null: true}, nil
+		case arg.IsSymbol("extern"):
+			return Value{Type: ValueTypeExternRef, Null: true}, nil
+		}
+		return Value{}, expected(arg, "func or extern")
+	case "ref.extern", "ref.func":
+		typ := ValueTypeExternRef
+		if op == "ref.func" {
+			typ = ValueTypeFuncRef
+		}
+		if s.Len() == 1 && result {
+			return Value{Type: typ, Any: true}, nil
+		}
+		if s.Len() != 2 {
+			return Value{}, errorf(s, "%s should have a single index", op)
+		}
+		n, ok := arg.Number()
+		if !ok {
+			return Value{}, expected(arg, "index")
+		}
+		bits, err := n.Uint64()
+		if err != nil || bits > math.MaxUint32 {
+			return Value{}, errorf(arg, "invalid index %s", arg.Atom.Value)
+		}
+		return Value{Type: typ, Bits: bits}, nil
+	}
+	return Value{}, errorf(s, "unknown constant %s", op)
+}
+
+func numberValue(arg *sexp.Sexp, op string, n *sexp.Number, result bool) (Value, error) {
+	var v Value
+	var err error
+	switch op {
+	case "i32.const":
+		v.Type = ValueTypeI32
+		v.Bits, err = n.IntBits(32)
+	case "i64.const":
+		v.Type = ValueTypeI64
+		v.Bits, err = n.IntBits(64)
+	case "f32.const":
+		var bits uint32
+		v.Type = ValueTypeF32
+		bits, err = n.Float32Bits()
+		v.Bits = uint64(bits)
+	case "f64.const":
+		v.Type = ValueTypeF64
+		v.Bits, err = n.Float64Bits()
+	}
+	if err != nil {
+		return Value{}, errorf(arg, "invalid %s: %s", op, arg.Atom.Value)
+	}
+
+	if n.Kind == sexp.NumberKindNaN && (n.NaN == sexp.NaNKindCanonical || n.NaN == sexp.NaNKindArithmetic) {
+		if !result {
+			return Value{}, errorf(arg, "%s is only allowed in expected results", arg.Atom.Value)
+		}
+		v.NaN = n.NaN
+	}
+	return v, nil
+}
@@ -0,0 +1,147 @@
+// Package wast reads WebAssembly script files (.wast), the format of the WebAssembly
+// specification tests, into typed commands.
+package wast
+
+import (
+	"fmt"
+
+	"github.com/bearmini/sexp"
+)
+
+// Script is a parsed WebAssembly script.
+type Script struct {
+	Commands []Command
+}
+
+// Command is one of *Module, *Register, *ActionCommand, *AssertReturn, *AssertTrap,
+// *AssertExhaustion, *AssertMalformed, *AssertInvalid and *AssertUnlinkable.
+type Command interface {
+	// Line returns the line of the command in the script.
+	Line() int
+	command()
+}
+
+type ModuleKind int
+
+const (
+	ModuleKindText   ModuleKind = iota // (module ...)
+	ModuleKindBinary                   // (module binary "...")
+	ModuleKindQuote                    // (module quote "...")
+)
+
+// Module defines a module, and instantiates it if it is a command of its own.
+type Module struct {
+	Pos  sexp.Position
+	Name string // the $name of the module, or ""
+	Kind ModuleKind
+
+	// Form is the whole module form. For a text module, the fields are the
+	// elements of the form after the name.
+	Form *sexp.Sexp
+
+	// Strings are the string literals of a binary or quoted module. Their decoded
+	// contents concatenated are the binary or the text of the module.
+	Strings []*sexp.Sexp
+}
+
+// Bytes returns the binary of a binary module or the text of a quoted module: the
+// contents of its string literals concatenated, byte for byte.
+func (m *Module) Bytes() ([]byte, error) {
+	if m.Kind == ModuleKindText {
+		return nil, fmt.Errorf("%s: module is not binary or quoted", m.Pos)
+	}
+	return sexp.ConcatStrings(m.Strings)
+}
+
+// Register makes the exports of a module available for import under a name.
+type Register struct {
+	Pos    sexp.Position
+	As     string // the name to register the module as
+	Module string // the $name of the module, or "" for the most recent one
+}
+
+type ActionKind int
+
+const (
+	ActionKindInvoke ActionKind = iota // (invoke ...)
+	ActionKindGet                      // (get ...)
+)
+
+// Action invokes an exported function or gets the value of an exported global.
+type Action struct {
+	Pos    sexp.Position
+	Kind   ActionKind
+	Module string // the $name of the module, or "" for the most recent one
+	Field  string // the name of the export
+	Args   []Value
+}
+
+// ActionCommand performs an action on its own.
+type ActionCommand struct {
+	Pos    sexp.Position
+	Action *Action
+}
+
+// AssertReturn asserts that an action returns the expected results.
+type AssertReturn struct {
+	Pos      sexp.Position
+	Action   *Action
+	Expected []Value
+}
+
+// AssertTrap asserts that an action, or the instantiation of a module, traps.
+// Exactly one of Action and Module is set.
+type AssertTrap struct {
+	Pos     sexp.Position
+	Action  *Action
+	Module  *Module
+	Message string
+}
+
+// AssertExhaustion asserts that an action exhausts the call stack.
+type AssertExhaustion struct {
+	Pos     sexp.Position
+	Action  *Action
+	Message string
+}
+
+// AssertMalformed asserts that a module fails to decode or to parse.
+type AssertMalformed struct {
+	Pos     sexp.Position
+	Module  *Module
+	Message string
+}
+
+// AssertInvalid asserts that a module fails to validate.
+type AssertInvalid struct {
+	Pos     sexp.Position
+	Module  *Module
+	Message string
+}
+
+// AssertUnlinkable asserts that a module fails to link.
+type AssertUnlinkable struct {
+	Pos     sexp.Position
+	Module  *Module
+	Message string
+}
+
+func (c *Module) Line() int           { return c.Pos.Line }
+func (c *Register) Line() int         { return c.Pos.Line }
+func (c *ActionCommand) Line() int    { return c.Pos.Line }
+func (c *AssertReturn) Line() int     { return c.Pos.Line }
+func (c *AssertTrap) Line() int       { return c.Pos.Line }
+func (c *AssertExhaustion) Line() int { return c.Pos.Line }
+func (c *AssertMalformed) Line() int  { return c.Pos.Line }
+func (c *AssertInvalid) Line() int    { return c.Pos.Line }
+func (c *AssertUnlinkable) Line() int { return c.Pos.Line }
+
+func (*Module) command()           {}
+func (*Register) command()         {}
+func (*ActionCommand) command()    {}
+func (*AssertReturn) command()     {}
+func (*AssertTrap) command()       {}
+func (*AssertExhaustion) command() {}
+func (*AssertMalformed) command()  {}
+func (*AssertInvalid) command()    {}
+func (*AssertUnlinkable) command() {}
@@ -0,0 +1,600 @@
+package wat
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"strings"
+
+	"github.com/bearmini/sexp"
+)
+
+// EncodeModule encodes a (module ...) form in the WebAssembly binary format.
+func EncodeModule(s *sexp.Sexp) ([]byte, error) {
+	m, err := ParseModule(s)
+	if err != nil {
+		return nil, err
+	}
+	return Encode(m)
+}
+
+// Encode encodes a module in the WebAssembly binary format, resolving it first.
+// The $identifiers of the module, its functions and their locals are written to
+// a name section. The module is not validated.
+func Encode(m *Module) ([]byte, error) {
+	err := Resolve(m)
+	if err != nil {
+		return nil, err
+	}
+	e := &encoder{m: m}
+	return e.encode()
+}
+
+const (
+	sectionCustom    = 0
+	sectionType      = 1
+	sectionImport    = 2
+	sectionFunction  = 3
+	sectionTable     = 4
+	sectionMemory    = 5
+	sectionGlobal    = 6
+	sectionExport    = 7
+	sectionStart     = 8
+	sectionElement   = 9
+	sectionCode      = 10
+	sectionData      = 11
+	sectionDataCount = 12
+)
+
+func appendU32(b []byte, v uint32) []byte {
+	return appendU64(b, uint64(v))
+}
+
+func appendU64(b []byte, v uint64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func appendS64(b []byte, v int64) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v == 0 && c&0x40 == 0 || v == -1 && c&0x40 != 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
+
+func appendName(b []byte, s string) []byte {
+	b = appendU32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+func appendBytes(b []byte, data []byte) []byte {
+	b = appendU32(b, uint32(len(data)))
+	return append(b, data...)
+}
+
+func appendLimits(b []byte, l *Limits) []byte {
+	if !l.HasMax {
+		return appendU32(append(b, 0x00), l.Min)
+	}
+	b = appendU32(append(b, 0x01), l.Min)
+	return appendU32(b, l.Max)
+}
+
+func appendGlobalType(b []byte, t *GlobalType) []byte {
+	mut := byte(0)
+	if t.Mutable {
+		mut = 1
+	}
+	return append(b, byte(t.Type), mut)
+}
+
+type encoder struct {
+	m   *Module
+	out []byte
+
+	// dataCount is set when an instruction refers to a data segment, which requires
+	// the data count section.
+	dataCount bool
+}
+
+// section appends a section if it has contents, or if force is set.
+func (e *encoder) section(id byte, contents []byte, force bool) {
+	if len(contents) == 0 && !force {
+		return
+	}
+	e.out = append(e.out, id)
+	e.out = appendBytes(e.out, contents)
+}
+
+// vec returns the encoding of a vector of n items.
+func vec(n int, item func(b []byte, i int) ([]byte, error)) ([]byte, error) {
+	if n == 0 {
+		return nil, nil
+	}
+	b := appendU32(nil, uint32(n))
+	for i := 0; i < n; i++ {
+		var err error
+		b, err = item(b, i)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+func (e *encoder) encode() ([]byte, error) {
+	m := e.m
+	e.out = []byte("\x00asm\x01\x00\x00\x00")
+
+	// The code comes after the sections that tell whether a data count section is
+	// needed, so it is encoded first.
+	code, err := vec(len(m.Funcs), func(b []byte, i int) ([]byte, error) {
+		body, err := e.function(m.Funcs[i])
+		if err != nil {
+			return nil, err
+		}
+		return appendBytes(b, body), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	types, _ := vec(len(m.Types), func(b []byte, i int) ([]byte, error) {
+		ft := m.Types[i].Func
+		b = appendU32(append(b, 0x60), uint32(len(ft.Params)))
+		for _, p := range ft.Params {
+			b = append(b, byte(p.Type))
+		}
+		b = appendU32(b, uint32(len(ft.Results)))
+		for _, t := range ft.Results {
+			b = append(b, byte(t))
+		}
+		return b, nil
+	})
+	e.section(sectionType, types, false)
+
+	imports, _ := vec(len(m.Imports), func(b []byte, i int) ([]byte, error) {
+		imp := m.Imports[i]
+		b = appendName(b, imp.Module)
+		b = appendName(b, imp.Name)
+		b = append(b, byte(imp.Kind))
+		switch imp.Kind {
+		case ExternFunc:
+			b = appendU32(b, imp.Func.Type.Num)
+		case ExternTable:
+			b = appendLimits(append(b, byte(imp.Table.Elem)), &imp.Table.Limits)
+		case ExternMemory:
+			b = appendLimits(b, imp.Memory)
+		case ExternGlobal:
+			b = appendGlobalType(b, imp.Global)
+		}
+		return b, nil
+	})
+	e.section(sectionImport, imports, false)
+
+	funcs, _ := vec(len(m.Funcs), func(b []byte, i int) ([]byte, error) {
+		return appendU32(b, m.Funcs[i].Type.Type.Num), nil
+	})
+	e.section(sectionFunction, funcs, false)
+
+	tables, _ := vec(len(m.Tables), func(b []byte, i int) ([]byte, error) {
+		t := m.Tables[i].Type
+		return appendLimits(append(b, byte(t.Elem)), &t.Limits), nil
+	})
+	e.section(sectionTable, tables, false)
+
+	memories, _ := vec(len(m.Memories), func(b []byte, i int) ([]byte, error) {
+		return appendLimits(b, m.Memories[i].Limits), nil
+	})
+	e.section(sectionMemory, memories, false)
+
+	globals, err := vec(len(m.Globals), func(b []byte, i int) ([]byte, error) {
+		g := m.Globals[i]
+		return e.expr(appendGlobalType(b, g.Type), g.Init)
+	})
+	if err != nil {
+		return nil, err
+	}
+	e.section(sectionGlobal, globals, false)
+
+	exports, _ := vec(len(m.Exports), func(b []byte, i int) ([]byte, error) {
+		exp := m.Exports[i]
+		b = append(appendName(b, exp.Name), byte(exp.Kind))
+		return appendU32(b, exp.Index.Num), nil
+	})
+	e.section(sectionExport, exports, false)
+
+	if m.Start != nil {
+		e.section(sectionStart, appendU32(nil, m.Start.Func.Num), true)
+	}
+
+	elems, err := vec(len(m.Elems), func(b []byte, i int) ([]byte, error) {
+		return e.elem(b, m.Elems[i])
+	})
+	if err != nil {
+		return nil, err
+	}
+	e.section(sectionElement, elems, false)
+
+	if e.dataCount {
+		e.section(sectionDataCount, appendU32(nil, uint32(len(m.Datas))), true)
+	}
+	e.section(sectionCode, code, false)
+
+	datas, err := vec(len(m.Datas), func(b []byte, i int) ([]byte, error) {
+		d := m.Datas[i]
+		var err error
+		switch {
+		case d.Mode == SegmentPassive:
+			b = append(b, 0x01)
+		case d.Memory.Num == 0:
+			b, err = e.expr(append(b, 0x00), d.Offset)
+		default:
+			b, err = e.expr(appendU32(append(b, 0x02), d.Memory.Num), d.Offset)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return appendBytes(b, d.Data), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	e.section(sectionData, datas, false)
+
+	e.names()
+	return e.out, nil
+}
+
+// elem appends an element segment, in the most compact of its encodings.
+func (e *encoder) elem(b []byte, el *Elem) ([]byte, error) {
+	// Bit 0 is set for passive and declarative segments, bit 1 for active segments
+	// with a table index and for declarative segments, and bit 2 when the elements
+	// are expressions.
+	flags := byte(0)
+	switch {
+	case el.Mode == SegmentPassive:
+		flags = 1
+	case el.Mode == SegmentDeclarative:
+		flags = 3
+	case el.Table.Num != 0 || el.Type != FuncRef:
+		flags = 2
+	}
+	exprs := el.Exprs != nil || el.Type != FuncRef
+	if exprs {
+		flags |= 4
+	}
+
+	b = append(b, flags)
+	var err error
+	if el.Mode == SegmentActive {
+		if flags&2 != 0 {
+			b = appendU32(b, el.Table.Num)
+		}
+		b, err = e.expr(b, el.Offset)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if flags&3 != 0 {
+		if exprs {
+			b = append(b, byte(el.Type))
+		} else {
+			b = append(b, 0x00) // funcref
+		}
+	}
+
+	if !exprs {
+		b = appendU32(b, uint32(len(el.Funcs)))
+		for _, x := range el.Funcs {
+			b = appendU32(b, x.Num)
+		}
+		return b, nil
+	}
+	b = appendU32(b, uint32(len(el.Exprs)))
+	for _, expr := range el.Exprs {
+		b, err = e.expr(b, expr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// function returns the locals and the code of a function.
+func (e *encoder) function(f *Func) ([]byte, error) {
+	// Consecutive locals of the same type are encoded together.
+	var runs []*Param
+	var counts []uint32
+	for _, l := range f.Locals {
+		if n := len(runs); n > 0 && runs[n-1].Type == l.Type {
+			counts[n-1]++
+			continue
+		}
+		runs = append(runs, l)
+		counts = append(counts, 1)
+	}
+	b := appendU32(nil, uint32(len(runs)))
+	for i, l := range runs {
+		b = append(appendU32(b, counts[i]), byte(l.Type))
+	}
+	return e.expr(b, f.Body)
+}
+
+// expr appends instructions followed by end.
+func (e *encoder) expr(b []byte, ss []*sexp.Sexp) ([]byte, error) {
+	instrs, err := Unfold(ss)
+	if err != nil {
+		return nil, err
+	}
+	for _, in := range instrs {
+		b, err = e.instr(b, in)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return append(b, 0x0b), nil
+}
+
+// argIndex returns the i-th argument of an instruction as a resolved index, or
+// 0 if it is omitted.
+func argIndex(in *Instr, i int) (uint32, error) {
+	if i >= len(in.Args) {
+		return 0, nil
+	}
+	x, err := index(in.Args[i])
+	if err != nil {
+		return 0, err
+	}
+	if x.ID != "" {
+		return 0, errorAt(x.Pos, "unresolved identifier %s", x.ID)
+	}
+	return x.Num, nil
+}
+
+func (e *encoder) instr(b []byte, in *Instr) ([]byte, error) {
+	info := instrTable[in.Op]
+	code := info.code
+	if in.Op == "select" && in.Type != nil {
+		code = 0x1c // select with types
+	}
+	if code > 0xff {
+		b = appendU32(append(b, byte(code>>8)), code&0xff)
+	} else {
+		b = append(b, byte(code))
+	}
+
+	// indices appends the arguments at the given positions as indices, which default to 0.
+	indices := func(order ...int) ([]byte, error) {
+		for _, i := range order {
+			x, err := argIndex(in, i)
+			if err != nil {
+				return nil, err
+			}
+			b = appendU32(b, x)
+		}
+		return b, nil
+	}
+
+	switch info.imm {
+	case immBlock:
+		return e.blockType(b, in.Type)
+	case immLabel, immFunc, immLocal, immGlobal, immElem, immData, immMemory, immTable:
+		if info.imm == immData {
+			e.dataCount = true
+		}
+		return indices(0)
+	case immBrTable:
+		b = appendU32(b, uint32(len(in.Args)-1))
+		for i := range in.Args {
+			x, err := argIndex(in, i)
+			if err != nil {
+				return nil, err
+			}
+			b = appendU32(b, x)
+		}
+		return b, nil
+	case immCallIndirect:
+		n, err := e.typeIndex(in.Type)
+		if err != nil {
+			return nil, err
+		}
+		b = appendU32(b, n)
+		return indices(0)
+	case immMemArg:
+		return memArg(b, in, info.align)
+	case immI32, immI64:
+		n, _ := in.Args[0].Number()
+		size := 32
+		if info.imm == immI64 {
+			size = 64
+		}
+		v, err := n.IntBits(size)
+		if err != nil {
+			return nil, errorf(in.Args[0], "invalid %s %s", in.Op, in.Args[0].Atom.Value)
+		}
+		if size == 32 {
+			return appendS64(b, int64(int32(v))), nil
+		}
+		return appendS64(b, int64(v)), nil
+	case immF32:
+		n, _ := in.Args[0].Number()
+		v, err := n.Float32Bits()
+		if err != nil {
+			return nil, errorf(in.Args[0], "invalid %s %s", in.Op, in.Args[0].Atom.Value)
+		}
+		var buf [4]byte
+		binary.LittleEndian.PutUint32(buf[:], v)
+		return append(b, buf[:]...), nil
+	case immF64:
+		n, _ := in.Args[0].Number()
+		v, err := n.Float64Bits()
+		if err != nil {
+			return nil, errorf(in.Args[0], "invalid %s %s", in.Op, in.Args[0].Atom.Value)
+		}
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], v)
+		return append(b, buf[:]...), nil
+	case immRefNull:
+		if in.Args[0].IsSymbol("func") {
+			return append(b, byte(FuncRef)), nil
+		}
+		return append(b, byte(ExternRef)), nil
+	case immSelect:
+		if in.Type == nil {
+			return b, nil
+		}
+		b = appendU32(b, uint32(len(in.Type.Results)))
+		for _, t := range in.Type.Results {
+			b = append(b, byte(t))
+		}
+		return b, nil
+	case immTableCopy, immMemoryCopy:
+		return indices(0, 1)
+	case immTableInit:
+		// table.init takes the table first in the text format, but last in the binary format.
+		if len(in.Args) == 1 {
+			return indices(0, 1)
+		}
+		return indices(1, 0)
+	case immMemoryInit:
+		e.dataCount = true
+		if len(in.Args) == 1 {
+			return indices(0, 1)
+		}
+		return indices(1, 0)
+	}
+	return b, nil
+}
+
+// typeIndex returns the index of the type definition of a type use.
+func (e *encoder) typeIndex(u *TypeUse) (uint32, error) {
+	if u.Type != nil {
+		return u.Type.Num, nil
+	}
+	n, ok := e.m.FindType(u.Inline())
+	if !ok {
+		return 0, fmt.Errorf("wat: no type definition for %v", u.Inline())
+	}
+	return n, nil
+}
+
+func (e *encoder) blockType(b []byte, u *TypeUse) ([]byte, error) {
+	if u.Type == nil && len(u.Params) == 0 {
+		switch len(u.Results) {
+		case 0:
+			return append(b, 0x40), nil
+		case 1:
+			return append(b, byte(u.Results[0])), nil
+		}
+	}
+	n, err := e.typeIndex(u)
+	if err != nil {
+		return nil, err
+	}
+	return appendS64(b, int64(n)), nil
+}
+
+// memArg appends the alignment, as a power of 2, and the offset of a memory access.
+func memArg(b []byte, in *Instr, align uint32) ([]byte, error) {
+	var offset uint64
+	for _, arg := range in.Args {
+		kv := strings.SplitN(arg.Atom.Value, "=", 2)
+		n, err := sexp.ParseNumber(kv[1])
+		var v uint64
+		if err == nil {
+			v, err = n.Uint64()
+		}
+		if err != nil || v > 0xffffffff {
+			return nil, errorf(arg, "invalid %s", arg.Atom.Value)
+		}
+		if kv[0] == "offset" {
+			offset = v
+			continue
+		}
+		if v == 0 || v&(v-1) != 0 {
+			return nil, errorf(arg, "alignment should be a power of 2")
+		}
+		align = uint32(bits.TrailingZeros64(v))
+	}
+	return appendU64(appendU32(b, align), offset), nil
+}
+
+// names appends the name section, with the names of the module, of the functions
+// and of their locals given by $identifiers.
+func (e *encoder) names() {
+	m := e.m
+	var section []byte
+	subsection := func(id byte, contents []byte) {
+		if len(contents) > 0 {
+			section = appendBytes(append(section, id), contents)
+		}
+	}
+
+	if m.ID != "" {
+		subsection(0, appendName(nil, m.ID[1:]))
+	}
+
+	type name struct {
+		index uint32
+		name  string
+	}
+	nameMap := func(names []name) []byte {
+		if len(names) == 0 {
+			return nil
+		}
+		b := appendU32(nil, uint32(len(names)))
+		for _, n := range names {
+			b = appendName(appendU32(b, n.index), n.name[1:])
+		}
+		return b
+	}
+
+	var funcs []name
+	var locals []byte
+	numLocals := 0
+	index := uint32(0)
+	for _, imp := range m.Imports {
+		if imp.Kind != ExternFunc {
+			continue
+		}
+		if imp.ID != "" {
+			funcs = append(funcs, name{index, imp.ID})
+		}
+		index++
+	}
+	for _, f := range m.Funcs {
+		if f.ID != "" {
+			funcs = append(funcs, name{index, f.ID})
+		}
+		var ls []name
+		for i, p := range append(append([]*Param{}, f.Type.Params...), f.Locals...) {
+			if p.ID != "" {
+				ls = append(ls, name{uint32(i), p.ID})
+			}
+		}
+		if len(ls) > 0 {
+			locals = append(appendU32(locals, index), nameMap(ls)...)
+			numLocals++
+		}
+		index++
+	}
+	subsection(1, nameMap(funcs))
+	if numLocals > 0 {
+		subsection(2, append(appendU32(nil, uint32(numLocals)), locals...))
+	}
+
+	if len(section) > 0 {
+		e.section(sectionCustom, append(appendName(nil, "name"), section...), true)
+	}
+}
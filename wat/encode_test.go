@@ -0,0 +1,250 @@
+package wat
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/bearmini/sexp"
+)
+
+func TestLEB128(t *testing.T) {
+	testData := []struct {
+		Name     string
+		Signed   bool
+		Value    int64
+		Expected string
+	}{
+		{Name: "pattern 1 - unsigned 0", Value: 0, Expected: "00"},
+		{Name: "pattern 2 - unsigned 127", Value: 127, Expected: "7f"},
+		{Name: "pattern 3 - unsigned 128", Value: 128, Expected: "8001"},
+		{Name: "pattern 4 - unsigned 624485", Value: 624485, Expected: "e58e26"},
+		{Name: "pattern 5 - unsigned max u32", Value: 0xffffffff, Expected: "ffffffff0f"},
+		{Name: "pattern 6 - signed 0", Signed: true, Value: 0, Expected: "00"},
+		{Name: "pattern 7 - signed 63", Signed: true, Value: 63, Expected: "3f"},
+		{Name: "pattern 8 - signed 64", Signed: true, Value: 64, Expected: "c000"},
+		{Name: "pattern 9 - signed -1", Signed: true, Value: -1, Expected: "7f"},
+		{Name: "pattern 10 - signed -64", Signed: true, Value: -64, Expected: "40"},
+		{Name: "pattern 11 - signed -65", Signed: true, Value: -65, Expected: "bf7f"},
+		{Name: "pattern 12 - signed -123456", Signed: true, Value: -123456, Expected: "c0bb78"},
+		{Name: "pattern 13 - signed min i64", Signed: true, Value: -1 << 63, Expected: "8080808080808080807f"},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			var b []byte
+			if data.Signed {
+				b = appendS64(nil, data.Value)
+			} else {
+				b = appendU64(nil, uint64(data.Value))
+			}
+			if actual := hex.EncodeToString(b); actual != data.Expected {
+				t.Fatalf("\nExpected: %s\nActual:   %v", data.Expected, actual)
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	testData := []struct {
+		Name    string
+		Pattern string
+		// Expected is the module in hexadecimal, without the header; spaces are ignored.
+		Expected string
+	}{
+		{
+			Name:     "pattern 1 - empty module",
+			Pattern:  `(module)`,
+			Expected: "",
+		},
+		{
+			Name:    "pattern 2 - exported function",
+			Pattern: `(module (func (export "f") (result i32) (i32.const -1)))`,
+			Expected: "01 05 01 60 00 01 7f" +
+				" 03 02 01 00" +
+				" 07 05 01 01 66 00 00" +
+				" 0a 06 01 04 00 41 7f 0b",
+		},
+		{
+			Name: "pattern 3 - imports",
+			Pattern: `(module
+  (import "m" "f" (func (param i64)))
+  (import "m" "t" (table 1 funcref))
+  (import "m" "m" (memory 1 2))
+  (import "m" "g" (global (mut f32))))`,
+			Expected: "01 05 01 60 01 7e 00" +
+				" 02 1e 04" +
+				" 01 6d 01 66 00 00" +
+				" 01 6d 01 74 01 70 00 01" +
+				" 01 6d 01 6d 02 01 01 02" +
+				" 01 6d 01 67 03 7d 01",
+		},
+		{
+			Name: "pattern 4 - memory, globals, start and data",
+			Pattern: `(module
+  (memory 1)
+  (global i64 (i64.const 128))
+  (func
+    (i64.store offset=8 (i32.const 0) (global.get 0))
+    (drop (i32.load8_u align=1 (i32.const 1)))
+    (drop (f64.load (i32.const 2))))
+  (start 0)
+  (data (i32.const 16) "hi"))`,
+			Expected: "01 04 01 60 00 00" +
+				" 03 02 01 00" +
+				" 05 03 01 00 01" +
+				" 06 07 01 7e 00 42 80 01 0b" +
+				" 08 01 00" +
+				" 0a 17 01 15 00 41 00 23 00 37 03 08 41 01 2d 00 00 1a 41 02 2b 03 00 1a 0b" +
+				" 0b 08 01 00 41 10 0b 02 68 69",
+		},
+		{
+			Name: "pattern 5 - control instructions",
+			Pattern: `(module
+  (func (param i32) (result i32)
+    (block $b (result i32)
+      (br_table 0 $b (i32.const 1) (local.get 0))
+      (if (i32.const 0) (then (nop)) (else (unreachable))))
+    (block (param i32) (result i32 i32) (i32.const 2))
+    (drop) (drop)))`,
+			Expected: "01 0c 02 60 01 7f 01 7f 60 01 7f 02 7f 7f" +
+				" 03 02 01 00" +
+				" 0a 1e 01 1c 00 02 7f 41 01 20 00 0e 01 00 00 41 00 04 40 01 05 00 0b 0b 02 01 41 02 0b 1a 1a 0b",
+		},
+		{
+			Name: "pattern 6 - floats, locals and select",
+			Pattern: `(module
+  (func (local i32 i32 f32) (local $x f64)
+    (drop (select (result f32) (f32.const 1) (f32.const -0.5) (i32.const 0)))
+    (drop (f64.const nan:0x1))))`,
+			Expected: "01 04 01 60 00 00" +
+				" 03 02 01 00" +
+				" 0a 24 01 22 03 02 7f 01 7d 01 7c" +
+				" 43 00 00 80 3f 43 00 00 00 bf 41 00 1c 01 7d 1a" +
+				" 44 01 00 00 00 00 00 f0 7f 1a 0b" +
+				" 00 0d 04 6e 61 6d 65 02 06 01 00 01 03 01 78",
+		},
+		{
+			Name: "pattern 7 - element segments",
+			Pattern: `(module
+  (table $t0 1 funcref)
+  (table $t1 1 externref)
+  (func $f)
+  (elem (i32.const 0) $f)
+  (elem $p func $f)
+  (elem declare func $f)
+  (elem (table $t1) (i32.const 0) externref (ref.null extern))
+  (elem funcref (ref.func $f)))`,
+			Expected: "01 04 01 60 00 00" +
+				" 03 02 01 00" +
+				" 04 07 02 70 00 01 6f 00 01" +
+				" 09 1f 05" +
+				" 00 41 00 0b 01 00" +
+				" 01 00 01 00" +
+				" 03 00 01 00" +
+				" 06 01 41 00 0b 6f 01 d0 6f 0b" +
+				" 05 70 01 d2 00 0b" +
+				" 0a 04 01 02 00 0b" +
+				" 00 0b 04 6e 61 6d 65 01 04 01 00 01 66",
+		},
+		{
+			Name: "pattern 8 - bulk memory",
+			Pattern: `(module
+  (memory 1)
+  (func
+    (memory.init 0 (i32.const 0) (i32.const 0) (i32.const 1))
+    (data.drop 0)
+    (memory.copy (i32.const 0) (i32.const 0) (i32.const 0)))
+  (data "x"))`,
+			Expected: "01 04 01 60 00 00" +
+				" 03 02 01 00" +
+				" 05 03 01 00 01" +
+				" 0c 01 01" +
+				" 0a 1b 01 19 00 41 00 41 00 41 01 fc 08 00 00 fc 09 00 41 00 41 00 41 00 fc 0a 00 00 0b" +
+				" 0b 04 01 01 01 78",
+		},
+		{
+			Name:    "pattern 9 - names",
+			Pattern: `(module $m (import "m" "f" (func $imported)) (func $g (param $a i32) (param i32) (local $b i64)))`,
+			Expected: "01 09 02 60 00 00 60 02 7f 7f 00" +
+				" 02 07 01 01 6d 01 66 00 00" +
+				" 03 02 01 01" +
+				" 0a 06 01 04 01 01 7e 0b" +
+				" 00 24 04 6e 61 6d 65" +
+				" 00 02 01 6d" +
+				" 01 0e 02 00 08 69 6d 70 6f 72 74 65 64 01 01 67" +
+				" 02 09 01 01 02 00 01 61 02 01 62",
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			s, err := sexp.ParseStrict(data.Pattern)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			b, err := EncodeModule(s)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			expected := "0061736d01000000" + strings.Replace(data.Expected, " ", "", -1)
+			if actual := hex.EncodeToString(b); actual != expected {
+				t.Fatalf("\nExpected: %s\nActual:   %v", expected, actual)
+			}
+		})
+	}
+}
+
+func TestEncodeError(t *testing.T) {
+	testData := []struct {
+		Name          string
+		Pattern       string
+		ExpectedError string
+	}{
+		{
+			Name:          "pattern 1 - binary module",
+			Pattern:       `(module binary "\00asm\01\00\00\00")`,
+			ExpectedError: "1:9: binary module is not a text module",
+		},
+		{
+			Name:          "pattern 2 - undefined function",
+			Pattern:       `(module (func (call $g)))`,
+			ExpectedError: "1:21: undefined func $g",
+		},
+		{
+			Name:          "pattern 3 - i32 out of range",
+			Pattern:       `(module (func (drop (i32.const 0x100000000))))`,
+			ExpectedError: "1:32: invalid i32.const 0x100000000",
+		},
+		{
+			Name:          "pattern 4 - alignment",
+			Pattern:       `(module (memory 1) (func (drop (i32.load align=3 (i32.const 0)))))`,
+			ExpectedError: "1:42: alignment should be a power of 2",
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			s, err := sexp.ParseStrict(data.Pattern)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			_, err = EncodeModule(s)
+			if err == nil {
+				t.Fatalf("expected an error, got none")
+			}
+			if err.Error() != data.ExpectedError {
+				t.Fatalf("\nExpected: %s\nActual:   %v", data.ExpectedError, err)
+			}
+		})
+	}
+}
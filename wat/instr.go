@@ -0,0 +1,730 @@
+package wat
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bearmini/sexp"
+)
+
+// Instr is a plain instruction.
+type Instr struct {
+	Pos   sexp.Position
+	Op    string       // the name of the instruction, such as i32.add, block, else or end
+	Label string       // the $label of block, loop and if, or the one repeated after else and end
+	Args  []*sexp.Sexp // the immediates written as atoms: indices, constants and memory arguments
+	Type  *TypeUse     // the block type of block, loop and if, the type of call_indirect or the results of select
+}
+
+func (in *Instr) String() string {
+	ss := []string{}
+	for _, s := range in.sexps() {
+		ss = append(ss, s.String())
+	}
+	return strings.Join(ss, " ")
+}
+
+// sexps returns the instruction name followed by its immediates.
+func (in *Instr) sexps() []*sexp.Sexp {
+	ss := []*sexp.Sexp{newSymbol(in.Pos, in.Op)}
+	if in.Label != "" {
+		ss = append(ss, newSymbol(in.Pos, in.Label))
+	}
+	ss = append(ss, in.Args...)
+	if in.Type != nil {
+		ss = append(ss, typeUseSexps(in.Pos, in.Type)...)
+	}
+	return ss
+}
+
+type immKind int
+
+const (
+	immNone         immKind = iota
+	immBlock                // $label? blocktype
+	immLabel                // labelidx
+	immBrTable              // labelidx+
+	immFunc                 // funcidx
+	immCallIndirect         // tableidx? typeuse
+	immLocal                // localidx
+	immGlobal               // globalidx
+	immMemArg               // offset=N? align=N?
+	immI32                  // i32
+	immI64                  // i64
+	immF32                  // f32
+	immF64                  // f64
+	immMemory               // memidx?
+	immRefNull              // func or extern
+	immSelect               // (result t*)*
+	immTable                // tableidx?
+	immTableCopy            // (tableidx tableidx)?
+	immTableInit            // tableidx? elemidx
+	immElem                 // elemidx
+	immData                 // dataidx
+	immMemoryInit           // memidx? dataidx
+	immMemoryCopy           // (memidx memidx)?
+)
+
+// instrInfo describes an instruction. Pops and pushes are the numbers of operands
+// and results, or -1 if they depend on the types of labels, functions or blocks.
+type instrInfo struct {
+	code   uint32 // the opcode, or 0xfc00 | n for the instructions prefixed with 0xfc
+	imm    immKind
+	pops   int
+	pushes int
+	align  uint32 // the natural alignment of a memory access, as a power of 2
+}
+
+var instrTable = map[string]instrInfo{
+	"unreachable":         {code: 0x00, imm: immNone, pops: 0, pushes: 0},
+	"nop":                 {code: 0x01, imm: immNone, pops: 0, pushes: 0},
+	"block":               {code: 0x02, imm: immBlock, pops: -1, pushes: -1},
+	"loop":                {code: 0x03, imm: immBlock, pops: -1, pushes: -1},
+	"if":                  {code: 0x04, imm: immBlock, pops: -1, pushes: -1},
+	"else":                {code: 0x05, imm: immNone, pops: -1, pushes: -1},
+	"end":                 {code: 0x0b, imm: immNone, pops: -1, pushes: -1},
+	"br":                  {code: 0x0c, imm: immLabel, pops: -1, pushes: -1},
+	"br_if":               {code: 0x0d, imm: immLabel, pops: -1, pushes: -1},
+	"br_table":            {code: 0x0e, imm: immBrTable, pops: -1, pushes: -1},
+	"return":              {code: 0x0f, imm: immNone, pops: -1, pushes: -1},
+	"call":                {code: 0x10, imm: immFunc, pops: -1, pushes: -1},
+	"call_indirect":       {code: 0x11, imm: immCallIndirect, pops: -1, pushes: -1},
+	"drop":                {code: 0x1a, imm: immNone, pops: 1, pushes: 0},
+	"select":              {code: 0x1b, imm: immSelect, pops: 3, pushes: 1},
+	"local.get":           {code: 0x20, imm: immLocal, pops: 0, pushes: 1},
+	"local.set":           {code: 0x21, imm: immLocal, pops: 1, pushes: 0},
+	"local.tee":           {code: 0x22, imm: immLocal, pops: 1, pushes: 1},
+	"global.get":          {code: 0x23, imm: immGlobal, pops: 0, pushes: 1},
+	"global.set":          {code: 0x24, imm: immGlobal, pops: 1, pushes: 0},
+	"table.get":           {code: 0x25, imm: immTable, pops: 1, pushes: 1},
+	"table.set":           {code: 0x26, imm: immTable, pops: 2, pushes: 0},
+	"i32.load":            {code: 0x28, imm: immMemArg, pops: 1, pushes: 1, align: 2},
+	"i64.load":            {code: 0x29, imm: immMemArg, pops: 1, pushes: 1, align: 3},
+	"f32.load":            {code: 0x2a, imm: immMemArg, pops: 1, pushes: 1, align: 2},
+	"f64.load":            {code: 0x2b, imm: immMemArg, pops: 1, pushes: 1, align: 3},
+	"i32.load8_s":         {code: 0x2c, imm: immMemArg, pops: 1, pushes: 1, align: 0},
+	"i32.load8_u":         {code: 0x2d, imm: immMemArg, pops: 1, pushes: 1, align: 0},
+	"i32.load16_s":        {code: 0x2e, imm: immMemArg, pops: 1, pushes: 1, align: 1},
+	"i32.load16_u":        {code: 0x2f, imm: immMemArg, pops: 1, pushes: 1, align: 1},
+	"i64.load8_s":         {code: 0x30, imm: immMemArg, pops: 1, pushes: 1, align: 0},
+	"i64.load8_u":         {code: 0x31, imm: immMemArg, pops: 1, pushes: 1, align: 0},
+	"i64.load16_s":        {code: 0x32, imm: immMemArg, pops: 1, pushes: 1, align: 1},
+	"i64.load16_u":        {code: 0x33, imm: immMemArg, pops: 1, pushes: 1, align: 1},
+	"i64.load32_s":        {code: 0x34, imm: immMemArg, pops: 1, pushes: 1, align: 2},
+	"i64.load32_u":        {code: 0x35, imm: immMemArg, pops: 1, pushes: 1, align: 2},
+	"i32.store":           {code: 0x36, imm: immMemArg, pops: 2, pushes: 0, align: 2},
+	"i64.store":           {code: 0x37, imm: immMemArg, pops: 2, pushes: 0, align: 3},
+	"f32.store":           {code: 0x38, imm: immMemArg, pops: 2, pushes: 0, align: 2},
+	"f64.store":           {code: 0x39, imm: immMemArg, pops: 2, pushes: 0, align: 3},
+	"i32.store8":          {code: 0x3a, imm: immMemArg, pops: 2, pushes: 0, align: 0},
+	"i32.store16":         {code: 0x3b, imm: immMemArg, pops: 2, pushes: 0, align: 1},
+	"i64.store8":          {code: 0x3c, imm: immMemArg, pops: 2, pushes: 0, align: 0},
+	"i64.store16":         {code: 0x3d, imm: immMemArg, pops: 2, pushes: 0, align: 1},
+	"i64.store32":         {code: 0x3e, imm: immMemArg, pops: 2, pushes: 0, align: 2},
+	"memory.size":         {code: 0x3f, imm: immMemory, pops: 0, pushes: 1},
+	"memory.grow":         {code: 0x40, imm: immMemory, pops: 1, pushes: 1},
+	"i32.const":           {code: 0x41, imm: immI32, pops: 0, pushes: 1},
+	"i64.const":           {code: 0x42, imm: immI64, pops: 0, pushes: 1},
+	"f32.const":           {code: 0x43, imm: immF32, pops: 0, pushes: 1},
+	"f64.const":           {code: 0x44, imm: immF64, pops: 0, pushes: 1},
+	"i32.eqz":             {code: 0x45, imm: immNone, pops: 1, pushes: 1},
+	"i32.eq":              {code: 0x46, imm: immNone, pops: 2, pushes: 1},
+	"i32.ne":              {code: 0x47, imm: immNone, pops: 2, pushes: 1},
+	"i32.lt_s":            {code: 0x48, imm: immNone, pops: 2, pushes: 1},
+	"i32.lt_u":            {code: 0x49, imm: immNone, pops: 2, pushes: 1},
+	"i32.gt_s":            {code: 0x4a, imm: immNone, pops: 2, pushes: 1},
+	"i32.gt_u":            {code: 0x4b, imm: immNone, pops: 2, pushes: 1},
+	"i32.le_s":            {code: 0x4c, imm: immNone, pops: 2, pushes: 1},
+	"i32.le_u":            {code: 0x4d, imm: immNone, pops: 2, pushes: 1},
+	"i32.ge_s":            {code: 0x4e, imm: immNone, pops: 2, pushes: 1},
+	"i32.ge_u":            {code: 0x4f, imm: immNone, pops: 2, pushes: 1},
+	"i64.eqz":             {code: 0x50, imm: immNone, pops: 1, pushes: 1},
+	"i64.eq":              {code: 0x51, imm: immNone, pops: 2, pushes: 1},
+	"i64.ne":              {code: 0x52, imm: immNone, pops: 2, pushes: 1},
+	"i64.lt_s":            {code: 0x53, imm: immNone, pops: 2, pushes: 1},
+	"i64.lt_u":            {code: 0x54, imm: immNone, pops: 2, pushes: 1},
+	"i64.gt_s":            {code: 0x55, imm: immNone, pops: 2, pushes: 1},
+	"i64.gt_u":            {code: 0x56, imm: immNone, pops: 2, pushes: 1},
+	"i64.le_s":            {code: 0x57, imm: immNone, pops: 2, pushes: 1},
+	"i64.le_u":            {code: 0x58, imm: immNone, pops: 2, pushes: 1},
+	"i64.ge_s":            {code: 0x59, imm: immNone, pops: 2, pushes: 1},
+	"i64.ge_u":            {code: 0x5a, imm: immNone, pops: 2, pushes: 1},
+	"f32.eq":              {code: 0x5b, imm: immNone, pops: 2, pushes: 1},
+	"f32.ne":              {code: 0x5c, imm: immNone, pops: 2, pushes: 1},
+	"f32.lt":              {code: 0x5d, imm: immNone, pops: 2, pushes: 1},
+	"f32.gt":              {code: 0x5e, imm: immNone, pops: 2, pushes: 1},
+	"f32.le":              {code: 0x5f, imm: immNone, pops: 2, pushes: 1},
+	"f32.ge":              {code: 0x60, imm: immNone, pops: 2, pushes: 1},
+	"f64.eq":              {code: 0x61, imm: immNone, pops: 2, pushes: 1},
+	"f64.ne":              {code: 0x62, imm: immNone, pops: 2, pushes: 1},
+	"f64.lt":              {code: 0x63, imm: immNone, pops: 2, pushes: 1},
+	"f64.gt":              {code: 0x64, imm: immNone, pops: 2, pushes: 1},
+	"f64.le":              {code: 0x65, imm: immNone, pops: 2, pushes: 1},
+	"f64.ge":              {code: 0x66, imm: immNone, pops: 2, pushes: 1},
+	"i32.clz":             {code: 0x67, imm: immNone, pops: 1, pushes: 1},
+	"i32.ctz":             {code: 0x68, imm: immNone, pops: 1, pushes: 1},
+	"i32.popcnt":          {code: 0x69, imm: immNone, pops: 1, pushes: 1},
+	"i32.add":             {code: 0x6a, imm: immNone, pops: 2, pushes: 1},
+	"i32.sub":             {code: 0x6b, imm: immNone, pops: 2, pushes: 1},
+	"i32.mul":             {code: 0x6c, imm: immNone, pops: 2, pushes: 1},
+	"i32.div_s":           {code: 0x6d, imm: immNone, pops: 2, pushes: 1},
+	"i32.div_u":           {code: 0x6e, imm: immNone, pops: 2, pushes: 1},
+	"i32.rem_s":           {code: 0x6f, imm: immNone, pops: 2, pushes: 1},
+	"i32.rem_u":           {code: 0x70, imm: immNone, pops: 2, pushes: 1},
+	"i32.and":             {code: 0x71, imm: immNone, pops: 2, pushes: 1},
+	"i32.or":              {code: 0x72, imm: immNone, pops: 2, pushes: 1},
+	"i32.xor":             {code: 0x73, imm: immNone, pops: 2, pushes: 1},
+	"i32.shl":             {code: 0x74, imm: immNone, pops: 2, pushes: 1},
+	"i32.shr_s":           {code: 0x75, imm: immNone, pops: 2, pushes: 1},
+	"i32.shr_u":           {code: 0x76, imm: immNone, pops: 2, pushes: 1},
+	"i32.rotl":            {code: 0x77, imm: immNone, pops: 2, pushes: 1},
+	"i32.rotr":            {code: 0x78, imm: immNone, pops: 2, pushes: 1},
+	"i64.clz":             {code: 0x79, imm: immNone, pops: 1, pushes: 1},
+	"i64.ctz":             {code: 0x7a, imm: immNone, pops: 1, pushes: 1},
+	"i64.popcnt":          {code: 0x7b, imm: immNone, pops: 1, pushes: 1},
+	"i64.add":             {code: 0x7c, imm: immNone, pops: 2, pushes: 1},
+	"i64.sub":             {code: 0x7d, imm: immNone, pops: 2, pushes: 1},
+	"i64.mul":             {code: 0x7e, imm: immNone, pops: 2, pushes: 1},
+	"i64.div_s":           {code: 0x7f, imm: immNone, pops: 2, pushes: 1},
+	"i64.div_u":           {code: 0x80, imm: immNone, pops: 2, pushes: 1},
+	"i64.rem_s":           {code: 0x81, imm: immNone, pops: 2, pushes: 1},
+	"i64.rem_u":           {code: 0x82, imm: immNone, pops: 2, pushes: 1},
+	"i64.and":             {code: 0x83, imm: immNone, pops: 2, pushes: 1},
+	"i64.or":              {code: 0x84, imm: immNone, pops: 2, pushes: 1},
+	"i64.xor":             {code: 0x85, imm: immNone, pops: 2, pushes: 1},
+	"i64.shl":             {code: 0x86, imm: immNone, pops: 2, pushes: 1},
+	"i64.shr_s":           {code: 0x87, imm: immNone, pops: 2, pushes: 1},
+	"i64.shr_u":           {code: 0x88, imm: immNone, pops: 2, pushes: 1},
+	"i64.rotl":            {code: 0x89, imm: immNone, pops: 2, pushes: 1},
+	"i64.rotr":            {code: 0x8a, imm: immNone, pops: 2, pushes: 1},
+	"f32.abs":             {code: 0x8b, imm: immNone, pops: 1, pushes: 1},
+	"f32.neg":             {code: 0x8c, imm: immNone, pops: 1, pushes: 1},
+	"f32.ceil":            {code: 0x8d, imm: immNone, pops: 1, pushes: 1},
+	"f32.floor":           {code: 0x8e, imm: immNone, pops: 1, pushes: 1},
+	"f32.trunc":           {code: 0x8f, imm: immNone, pops: 1, pushes: 1},
+	"f32.nearest":         {code: 0x90, imm: immNone, pops: 1, pushes: 1},
+	"f32.sqrt":            {code: 0x91, imm: immNone, pops: 1, pushes: 1},
+	"f32.add":             {code: 0x92, imm: immNone, pops: 2, pushes: 1},
+	"f32.sub":             {code: 0x93, imm: immNone, pops: 2, pushes: 1},
+	"f32.mul":             {code: 0x94, imm: immNone, pops: 2, pushes: 1},
+	"f32.div":             {code: 0x95, imm: immNone, pops: 2, pushes: 1},
+	"f32.min":             {code: 0x96, imm: immNone, pops: 2, pushes: 1},
+	"f32.max":             {code: 0x97, imm: immNone, pops: 2, pushes: 1},
+	"f32.copysign":        {code: 0x98, imm: immNone, pops: 2, pushes: 1},
+	"f64.abs":             {code: 0x99, imm: immNone, pops: 1, pushes: 1},
+	"f64.neg":             {code: 0x9a, imm: immNone, pops: 1, pushes: 1},
+	"f64.ceil":            {code: 0x9b, imm: immNone, pops: 1, pushes: 1},
+	"f64.floor":           {code: 0x9c, imm: immNone, pops: 1, pushes: 1},
+	"f64.trunc":           {code: 0x9d, imm: immNone, pops: 1, pushes: 1},
+	"f64.nearest":         {code: 0x9e, imm: immNone, pops: 1, pushes: 1},
+	"f64.sqrt":            {code: 0x9f, imm: immNone, pops: 1, pushes: 1},
+	"f64.add":             {code: 0xa0, imm: immNone, pops: 2, pushes: 1},
+	"f64.sub":             {code: 0xa1, imm: immNone, pops: 2, pushes: 1},
+	"f64.mul":             {code: 0xa2, imm: immNone, pops: 2, pushes: 1},
+	"f64.div":             {code: 0xa3, imm: immNone, pops: 2, pushes: 1},
+	"f64.min":             {code: 0xa4, imm: immNone, pops: 2, pushes: 1},
+	"f64.max":             {code: 0xa5, imm: immNone, pops: 2, pushes: 1},
+	"f64.copysign":        {code: 0xa6, imm: immNone, pops: 2, pushes: 1},
+	"i32.wrap_i64":        {code: 0xa7, imm: immNone, pops: 1, pushes: 1},
+	"i32.trunc_f32_s":     {code: 0xa8, imm: immNone, pops: 1, pushes: 1},
+	"i32.trunc_f32_u":     {code: 0xa9, imm: immNone, pops: 1, pushes: 1},
+	"i32.trunc_f64_s":     {code: 0xaa, imm: immNone, pops: 1, pushes: 1},
+	"i32.trunc_f64_u":     {code: 0xab, imm: immNone, pops: 1, pushes: 1},
+	"i64.extend_i32_s":    {code: 0xac, imm: immNone, pops: 1, pushes: 1},
+	"i64.extend_i32_u":    {code: 0xad, imm: immNone, pops: 1, pushes: 1},
+	"i64.trunc_f32_s":     {code: 0xae, imm: immNone, pops: 1, pushes: 1},
+	"i64.trunc_f32_u":     {code: 0xaf, imm: immNone, pops: 1, pushes: 1},
+	"i64.trunc_f64_s":     {code: 0xb0, imm: immNone, pops: 1, pushes: 1},
+	"i64.trunc_f64_u":     {code: 0xb1, imm: immNone, pops: 1, pushes: 1},
+	"f32.convert_i32_s":   {code: 0xb2, imm: immNone, pops: 1, pushes: 1},
+	"f32.convert_i32_u":   {code: 0xb3, imm: immNone, pops: 1, pushes: 1},
+	"f32.convert_i64_s":   {code: 0xb4, imm: immNone, pops: 1, pushes: 1},
+	"f32.convert_i64_u":   {code: 0xb5, imm: immNone, pops: 1, pushes: 1},
+	"f32.demote_f64":      {code: 0xb6, imm: immNone, pops: 1, pushes: 1},
+	"f64.convert_i32_s":   {code: 0xb7, imm: immNone, pops: 1, pushes: 1},
+	"f64.convert_i32_u":   {code: 0xb8, imm: immNone, pops: 1, pushes: 1},
+	"f64.convert_i64_s":   {code: 0xb9, imm: immNone, pops: 1, pushes: 1},
+	"f64.convert_i64_u":   {code: 0xba, imm: immNone, pops: 1, pushes: 1},
+	"f64.promote_f32":     {code: 0xbb, imm: immNone, pops: 1, pushes: 1},
+	"i32.reinterpret_f32": {code: 0xbc, imm: immNone, pops: 1, pushes: 1},
+	"i64.reinterpret_f64": {code: 0xbd, imm: immNone, pops: 1, pushes: 1},
+	"f32.reinterpret_i32": {code: 0xbe, imm: immNone, pops: 1, pushes: 1},
+	"f64.reinterpret_i64": {code: 0xbf, imm: immNone, pops: 1, pushes: 1},
+	"i32.extend8_s":       {code: 0xc0, imm: immNone, pops: 1, pushes: 1},
+	"i32.extend16_s":      {code: 0xc1, imm: immNone, pops: 1, pushes: 1},
+	"i64.extend8_s":       {code: 0xc2, imm: immNone, pops: 1, pushes: 1},
+	"i64.extend16_s":      {code: 0xc3, imm: immNone, pops: 1, pushes: 1},
+	"i64.extend32_s":      {code: 0xc4, imm: immNone, pops: 1, pushes: 1},
+	"ref.null":            {code: 0xd0, imm: immRefNull, pops: 0, pushes: 1},
+	"ref.is_null":         {code: 0xd1, imm: immNone, pops: 1, pushes: 1},
+	"ref.func":            {code: 0xd2, imm: immFunc, pops: 0, pushes: 1},
+	"i32.trunc_sat_f32_s": {code: 0xfc00, imm: immNone, pops: 1, pushes: 1},
+	"i32.trunc_sat_f32_u": {code: 0xfc01, imm: immNone, pops: 1, pushes: 1},
+	"i32.trunc_sat_f64_s": {code: 0xfc02, imm: immNone, pops: 1, pushes: 1},
+	"i32.trunc_sat_f64_u": {code: 0xfc03, imm: immNone, pops: 1, pushes: 1},
+	"i64.trunc_sat_f32_s": {code: 0xfc04, imm: immNone, pops: 1, pushes: 1},
+	"i64.trunc_sat_f32_u": {code: 0xfc05, imm: immNone, pops: 1, pushes: 1},
+	"i64.trunc_sat_f64_s": {code: 0xfc06, imm: immNone, pops: 1, pushes: 1},
+	"i64.trunc_sat_f64_u": {code: 0xfc07, imm: immNone, pops: 1, pushes: 1},
+	"memory.init":         {code: 0xfc08, imm: immMemoryInit, pops: 3, pushes: 0},
+	"data.drop":           {code: 0xfc09, imm: immData, pops: 0, pushes: 0},
+	"memory.copy":         {code: 0xfc0a, imm: immMemoryCopy, pops: 3, pushes: 0},
+	"memory.fill":         {code: 0xfc0b, imm: immMemory, pops: 3, pushes: 0},
+	"table.init":          {code: 0xfc0c, imm: immTableInit, pops: 3, pushes: 0},
+	"elem.drop":           {code: 0xfc0d, imm: immElem, pops: 0, pushes: 0},
+	"table.copy":          {code: 0xfc0e, imm: immTableCopy, pops: 3, pushes: 0},
+	"table.grow":          {code: 0xfc0f, imm: immTable, pops: 2, pushes: 1},
+	"table.size":          {code: 0xfc10, imm: immTable, pops: 0, pushes: 1},
+	"table.fill":          {code: 0xfc11, imm: immTable, pops: 3, pushes: 0},
+}
+
+func isIndex(s *sexp.Sexp) bool {
+	return isID(s) || s.IsNumber()
+}
+
+// immediates parses the immediates of an instruction from the elements that follow
+// its name.
+func immediates(in *Instr, info instrInfo, l *list) error {
+	// arg takes the next element as an argument of a given kind.
+	arg := func(what string, ok func(*sexp.Sexp) bool) error {
+		e := l.peek()
+		if e == nil {
+			return &sexp.SyntaxError{Pos: in.Pos, Msg: fmt.Sprintf("%s should have %s", in.Op, what)}
+		}
+		if !ok(e) {
+			return expected(e, what)
+		}
+		in.Args = append(in.Args, l.next())
+		return nil
+	}
+	// optional takes up to n indices.
+	optional := func(n int) {
+		for i := 0; i < n && isIndex(l.peek()); i++ {
+			in.Args = append(in.Args, l.next())
+		}
+	}
+
+	var err error
+	switch info.imm {
+	case immBlock:
+		in.Label = l.id()
+		in.Type, err = typeUse(l)
+	case immLabel, immFunc, immLocal, immGlobal, immElem, immData:
+		err = arg("an index", isIndex)
+	case immBrTable:
+		err = arg("an index", isIndex)
+		for isIndex(l.peek()) {
+			in.Args = append(in.Args, l.next())
+		}
+	case immCallIndirect:
+		optional(1)
+		in.Type, err = typeUse(l)
+	case immMemArg:
+		for {
+			name, ok := l.peek().Symbol()
+			if !ok || !strings.HasPrefix(name, "offset=") && !strings.HasPrefix(name, "align=") {
+				break
+			}
+			in.Args = append(in.Args, l.next())
+		}
+	case immI32, immI64, immF32, immF64:
+		err = arg("a number", (*sexp.Sexp).IsNumber)
+	case immMemory, immTable:
+		optional(1)
+	case immRefNull:
+		err = arg("a heap type", func(s *sexp.Sexp) bool { return s.IsSymbol("func", "extern") })
+	case immSelect:
+		var u *TypeUse
+		u, err = typeUse(l)
+		if err == nil && len(u.Results) > 0 {
+			if u.Type != nil || len(u.Params) > 0 {
+				return &sexp.SyntaxError{Pos: in.Pos, Msg: "select should only have results"}
+			}
+			in.Type = u
+		}
+	case immTableCopy, immMemoryCopy:
+		optional(2)
+		if len(in.Args) == 1 {
+			return &sexp.SyntaxError{Pos: in.Pos, Msg: fmt.Sprintf("%s should have two indices or none", in.Op)}
+		}
+	case immTableInit, immMemoryInit:
+		err = arg("an index", isIndex)
+		optional(1)
+	}
+	return err
+}
+
+// block is a block, a loop or an if being unfolded.
+type block struct {
+	instr   *Instr
+	folded  bool // written in folded form, closed by its paren rather than by end
+	hasElse bool
+}
+
+type unfolder struct {
+	instrs []*Instr
+	blocks []*block
+}
+
+// Unfold returns the plain instructions of a sequence of instructions written in
+// plain form, folded form or a mix of both, such as the body of a function. For
+// example, (i32.add (local.get 0) (i32.const 1)) becomes local.get 0, i32.const 1,
+// i32.add.
+func Unfold(instrs []*sexp.Sexp) ([]*Instr, error) {
+	u := &unfolder{instrs: []*Instr{}}
+	err := u.seq(&list{s: &sexp.Sexp{Children: instrs}})
+	if err != nil {
+		return nil, err
+	}
+	if len(u.blocks) > 0 {
+		in := u.blocks[len(u.blocks)-1].instr
+		return nil, &sexp.SyntaxError{Pos: in.Pos, Msg: fmt.Sprintf("missing end of %s", in.Op)}
+	}
+	return u.instrs, nil
+}
+
+func (u *unfolder) seq(l *list) error {
+	for !l.done() {
+		e := l.next()
+		var err error
+		if e.IsList() {
+			err = u.folded(e)
+		} else {
+			err = u.plain(e, l)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func lookup(s *sexp.Sexp) (string, instrInfo, error) {
+	name, ok := s.Symbol()
+	if !ok {
+		return "", instrInfo{}, expected(s, "instruction")
+	}
+	info, ok := instrTable[name]
+	if !ok {
+		return "", instrInfo{}, errorf(s, "unknown instruction %s", name)
+	}
+	return name, info, nil
+}
+
+func (u *unfolder) top() *block {
+	if len(u.blocks) == 0 {
+		return nil
+	}
+	return u.blocks[len(u.blocks)-1]
+}
+
+// plain unfolds a plain instruction, whose immediates are the elements that follow it.
+func (u *unfolder) plain(op *sexp.Sexp, l *list) error {
+	name, info, err := lookup(op)
+	if err != nil {
+		return err
+	}
+	in := &Instr{Pos: op.Start, Op: name}
+
+	switch name {
+	case "else", "end":
+		b := u.top()
+		if b == nil || b.folded || name == "else" && (b.instr.Op != "if" || b.hasElse) {
+			return errorf(op, "unexpected %s", name)
+		}
+		if e := l.peek(); isID(e) {
+			in.Label = l.next().Atom.Value
+			if in.Label != b.instr.Label {
+				return errorf(e, "mismatching label %s", in.Label)
+			}
+		}
+		if name == "else" {
+			b.hasElse = true
+		} else {
+			u.blocks = u.blocks[:len(u.blocks)-1]
+		}
+		u.instrs = append(u.instrs, in)
+		return nil
+	}
+
+	err = immediates(in, info, l)
+	if err != nil {
+		return err
+	}
+	u.instrs = append(u.instrs, in)
+	if info.imm == immBlock {
+		u.blocks = append(u.blocks, &block{instr: in})
+	}
+	return nil
+}
+
+// folded unfolds a folded instruction.
+func (u *unfolder) folded(s *sexp.Sexp) error {
+	name, info, err := lookup(s.Head())
+	if err != nil {
+		return err
+	}
+	if name == "else" || name == "end" {
+		return errorf(s, "unexpected %s", name)
+	}
+	in := &Instr{Pos: s.Start, Op: name}
+	l := &list{s: s, i: 1}
+	err = immediates(in, info, l)
+	if err != nil {
+		return err
+	}
+
+	if info.imm != immBlock {
+		// The operands come first.
+		for !l.done() {
+			e := l.next()
+			if !e.IsList() {
+				return expected(e, "folded instruction")
+			}
+			err := u.folded(e)
+			if err != nil {
+				return err
+			}
+		}
+		u.instrs = append(u.instrs, in)
+		return nil
+	}
+
+	if name != "if" {
+		u.instrs = append(u.instrs, in)
+		err = u.body(in, l)
+		if err != nil {
+			return err
+		}
+		u.end(s)
+		return nil
+	}
+
+	// (if label? blocktype condition* (then instr*) (else instr*)?)
+	for !l.done() && !l.peek().HasHead("then") {
+		e := l.next()
+		if !e.IsList() {
+			return expected(e, "(then ...)")
+		}
+		err := u.folded(e)
+		if err != nil {
+			return err
+		}
+	}
+	then, err := l.expect("(then ...)")
+	if err != nil {
+		return err
+	}
+	u.instrs = append(u.instrs, in)
+	err = u.body(in, &list{s: then, i: 1})
+	if err != nil {
+		return err
+	}
+	if e := l.peek(); e.HasHead("else") {
+		l.next()
+		u.instrs = append(u.instrs, &Instr{Pos: e.Start, Op: "else"})
+		err = u.body(in, &list{s: e, i: 1})
+		if err != nil {
+			return err
+		}
+	}
+	err = l.end()
+	if err != nil {
+		return err
+	}
+	u.end(s)
+	return nil
+}
+
+// body unfolds the instructions of a folded block.
+func (u *unfolder) body(in *Instr, l *list) error {
+	b := &block{instr: in, folded: true}
+	u.blocks = append(u.blocks, b)
+	err := u.seq(l)
+	if err != nil {
+		return err
+	}
+	if top := u.top(); top != b {
+		return &sexp.SyntaxError{Pos: top.instr.Pos, Msg: fmt.Sprintf("missing end of %s", top.instr.Op)}
+	}
+	u.blocks = u.blocks[:len(u.blocks)-1]
+	return nil
+}
+
+// end adds the end of the folded block s, at its close paren.
+func (u *unfolder) end(s *sexp.Sexp) {
+	pos := s.End
+	pos.Offset--
+	pos.Column--
+	u.instrs = append(u.instrs, &Instr{Pos: pos, Op: "end"})
+}
+
+// entry is a folded instruction and the number of values it leaves on the stack,
+// or -1 if it is not known.
+type entry struct {
+	s      *sexp.Sexp
+	pushes int
+}
+
+type folder struct {
+	instrs []*Instr
+	i      int
+}
+
+// Fold returns the folded form of plain instructions. An instruction takes the
+// preceding ones as operands when they each leave one value on the stack and it
+// pops as many; others are left as they are, which means the same.
+func Fold(instrs []*Instr) ([]*sexp.Sexp, error) {
+	f := &folder{instrs: instrs}
+	ss, term, err := f.seq()
+	if err != nil {
+		return nil, err
+	}
+	if term != nil {
+		return nil, &sexp.SyntaxError{Pos: term.Pos, Msg: fmt.Sprintf("unexpected %s", term.Op)}
+	}
+	return ss, nil
+}
+
+// seq folds instructions up to an else or an end, which it returns, or to the end
+// of the instructions.
+func (f *folder) seq() ([]*sexp.Sexp, *Instr, error) {
+	var stack []entry
+	for f.i < len(f.instrs) {
+		in := f.instrs[f.i]
+		f.i++
+		if in.Op == "else" || in.Op == "end" {
+			return entrySexps(stack), in, nil
+		}
+
+		info, ok := instrTable[in.Op]
+		if !ok {
+			return nil, nil, &sexp.SyntaxError{Pos: in.Pos, Msg: fmt.Sprintf("unknown instruction %s", in.Op)}
+		}
+		head := in.sexps()
+		pops, pushes := info.pops, info.pushes
+		var children []*sexp.Sexp
+		if info.imm == immBlock {
+			body, err := f.block(in)
+			if err != nil {
+				return nil, nil, err
+			}
+			children = body
+			pops, pushes = 0, -1
+			if in.Op == "if" {
+				pops = 1 // the condition
+			}
+			if in.Type == nil {
+				pushes = 0
+			} else if in.Type.Type == nil && len(in.Type.Params) == 0 {
+				pushes = len(in.Type.Results)
+			}
+		}
+
+		var operands []*sexp.Sexp
+		if pops > 0 && len(stack) >= pops {
+			operands = []*sexp.Sexp{}
+			for _, e := range stack[len(stack)-pops:] {
+				if e.pushes != 1 {
+					operands = nil
+					break
+				}
+				operands = append(operands, e.s)
+			}
+			if operands != nil {
+				stack = stack[:len(stack)-pops]
+			}
+		}
+
+		children = append(append(head, operands...), children...)
+		stack = append(stack, entry{s: newList(in.Pos, children...), pushes: pushes})
+	}
+	return entrySexps(stack), nil, nil
+}
+
+// block folds the body of a block, a loop or an if.
+func (f *folder) block(in *Instr) ([]*sexp.Sexp, error) {
+	body, term, err := f.seq()
+	if err != nil {
+		return nil, err
+	}
+	if term == nil {
+		return nil, &sexp.SyntaxError{Pos: in.Pos, Msg: fmt.Sprintf("missing end of %s", in.Op)}
+	}
+	if in.Op != "if" {
+		if term.Op == "else" {
+			return nil, &sexp.SyntaxError{Pos: term.Pos, Msg: "unexpected else"}
+		}
+		return body, nil
+	}
+
+	ss := []*sexp.Sexp{newList(in.Pos, append([]*sexp.Sexp{newSymbol(in.Pos, "then")}, body...)...)}
+	if term.Op == "else" {
+		els, end, err := f.seq()
+		if err != nil {
+			return nil, err
+		}
+		if end == nil || end.Op != "end" {
+			return nil, &sexp.SyntaxError{Pos: in.Pos, Msg: "missing end of if"}
+		}
+		ss = append(ss, newList(term.Pos, append([]*sexp.Sexp{newSymbol(term.Pos, "else")}, els...)...))
+	}
+	return ss, nil
+}
+
+func entrySexps(stack []entry) []*sexp.Sexp {
+	ss := make([]*sexp.Sexp, len(stack))
+	for i, e := range stack {
+		ss[i] = e.s
+	}
+	return ss
+}
+
+// typeUseSexps returns the (type ...), (param ...) and (result ...) lists of a
+// type use. Consecutive anonymous parameters share a list.
+func typeUseSexps(pos sexp.Position, u *TypeUse) []*sexp.Sexp {
+	var ss []*sexp.Sexp
+	if u.Type != nil {
+		ss = append(ss, newList(pos, newSymbol(pos, "type"), indexSexp(u.Type)))
+	}
+	var anon *sexp.Sexp
+	for _, p := range u.Params {
+		if p.ID != "" {
+			ss = append(ss, newList(pos, newSymbol(pos, "param"), newSymbol(pos, p.ID), newSymbol(pos, p.Type.String())))
+			anon = nil
+			continue
+		}
+		if anon == nil {
+			anon = newList(pos, newSymbol(pos, "param"))
+			ss = append(ss, anon)
+		}
+		anon.Children = append(anon.Children, newSymbol(pos, p.Type.String()))
+	}
+	if len(u.Results) > 0 {
+		r := newList(pos, newSymbol(pos, "result"))
+		for _, t := range u.Results {
+			r.Children = append(r.Children, newSymbol(pos, t.String()))
+		}
+		ss = append(ss, r)
+	}
+	return ss
+}
+
+func indexSexp(x *Index) *sexp.Sexp {
+	if x.ID != "" {
+		return newSymbol(x.Pos, x.ID)
+	}
+	return newNumber(x.Pos, fmt.Sprint(x.Num))
+}
+
+func newSymbol(pos sexp.Position, v string) *sexp.Sexp {
+	return &sexp.Sexp{Atom: &sexp.Token{Type: sexp.TokenTypeSymbol, Value: v, Start: pos, End: pos}, Start: pos, End: pos}
+}
+
+func newNumber(pos sexp.Position, v string) *sexp.Sexp {
+	return &sexp.Sexp{Atom: &sexp.Token{Type: sexp.TokenTypeNumber, Value: v, Start: pos, End: pos}, Start: pos, End: pos}
+}
+
+func newList(pos sexp.Position, children ...*sexp.Sexp) *sexp.Sexp {
+	return &sexp.Sexp{Children: children, Start: pos, End: pos}
+}
@@ -0,0 +1,332 @@
+package wat
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/bearmini/sexp"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func instrStrings(instrs []*Instr) []string {
+	ss := []string{}
+	for _, in := range instrs {
+		ss = append(ss, in.String())
+	}
+	return ss
+}
+
+func TestUnfold(t *testing.T) {
+	testData := []struct {
+		Name     string
+		Pattern  string
+		Expected []string
+	}{
+		{
+			Name:     "pattern 1 - plain",
+			Pattern:  `local.get 0 i32.const 1 i32.add`,
+			Expected: []string{"local.get 0", "i32.const 1", "i32.add"},
+		},
+		{
+			Name:     "pattern 2 - folded",
+			Pattern:  `(i32.add (local.get 0) (i32.const 1))`,
+			Expected: []string{"local.get 0", "i32.const 1", "i32.add"},
+		},
+		{
+			Name:     "pattern 3 - nested",
+			Pattern:  `(i32.mul (i32.add (local.get $a) (local.get $b)) (i32.const -1)) drop`,
+			Expected: []string{"local.get $a", "local.get $b", "i32.add", "i32.const -1", "i32.mul", "drop"},
+		},
+		{
+			Name:    "pattern 4 - folded block and loop",
+			Pattern: `(block $out (result i32) (loop $in (br_if $in (local.get 0))) (i32.const 1))`,
+			Expected: []string{
+				"block $out (result i32)",
+				"loop $in",
+				"local.get 0",
+				"br_if $in",
+				"end",
+				"i32.const 1",
+				"end",
+			},
+		},
+		{
+			Name:    "pattern 5 - folded if",
+			Pattern: `(if (result i32) (local.get 0) (then (i32.const 1)) (else (i32.const 2)))`,
+			Expected: []string{
+				"local.get 0",
+				"if (result i32)",
+				"i32.const 1",
+				"else",
+				"i32.const 2",
+				"end",
+			},
+		},
+		{
+			Name:     "pattern 6 - folded if without else",
+			Pattern:  `(if $l (i32.eqz (local.get 0)) (then (br $l)))`,
+			Expected: []string{"local.get 0", "i32.eqz", "if $l", "br $l", "end"},
+		},
+		{
+			Name:    "pattern 7 - plain blocks with labels",
+			Pattern: `block $a loop $b i32.const 0 if $c (param i32) drop else $c drop end $c end end $a`,
+			Expected: []string{
+				"block $a",
+				"loop $b",
+				"i32.const 0",
+				"if $c (param i32)",
+				"drop",
+				"else $c",
+				"drop",
+				"end $c",
+				"end",
+				"end $a",
+			},
+		},
+		{
+			Name:     "pattern 8 - plain inside folded",
+			Pattern:  `(block local.get 0 (drop))`,
+			Expected: []string{"block", "local.get 0", "drop", "end"},
+		},
+		{
+			Name:    "pattern 9 - immediates",
+			Pattern: `(i32.store offset=4 align=2 (i32.const 0) (i64.load (i32.const 8))) br_table 0 1 $l call_indirect $t (type $f) (param i32) (select (result i32) (i32.const 1) (i32.const 2) (i32.const 3)) (ref.null extern) memory.size table.copy $a $b`,
+			Expected: []string{
+				"i32.const 0",
+				"i32.const 8",
+				"i64.load",
+				"i32.store offset=4 align=2",
+				"br_table 0 1 $l",
+				"call_indirect $t (type $f) (param i32)",
+				"i32.const 1",
+				"i32.const 2",
+				"i32.const 3",
+				"select (result i32)",
+				"ref.null extern",
+				"memory.size",
+				"table.copy $a $b",
+			},
+		},
+		{
+			Name:     "pattern 10 - empty",
+			Pattern:  ``,
+			Expected: []string{},
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			body, err := sexp.ParseAll(data.Pattern)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			instrs, err := Unfold(body)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if diff := pretty.Compare(data.Expected, instrStrings(instrs)); diff != "" {
+				t.Fatalf("\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUnfoldError(t *testing.T) {
+	testData := []struct {
+		Name          string
+		Pattern       string
+		ExpectedError string
+	}{
+		{
+			Name:          "pattern 1 - unknown instruction",
+			Pattern:       `(i32.add (local.get 0) (i32.frob))`,
+			ExpectedError: "1:25: unknown instruction i32.frob",
+		},
+		{
+			Name:          "pattern 2 - missing index",
+			Pattern:       `i32.const 1 local.get`,
+			ExpectedError: "1:13: local.get should have an index",
+		},
+		{
+			Name:          "pattern 3 - bad index",
+			Pattern:       `local.get i32.add`,
+			ExpectedError: `1:11: expected an index, but found "i32.add"`,
+		},
+		{
+			Name:          "pattern 4 - bad constant",
+			Pattern:       `(i32.const $x)`,
+			ExpectedError: `1:12: expected a number, but found "$x"`,
+		},
+		{
+			Name:          "pattern 5 - operand that is not folded",
+			Pattern:       `(i32.add 1 2)`,
+			ExpectedError: `1:10: expected folded instruction, but found "1"`,
+		},
+		{
+			Name:          "pattern 6 - missing end",
+			Pattern:       "nop\nblock $b nop",
+			ExpectedError: "2:1: missing end of block",
+		},
+		{
+			Name:          "pattern 7 - missing end in a folded block",
+			Pattern:       `(block (loop nop))`,
+			ExpectedError: "", // a folded loop is closed by its paren
+		},
+		{
+			Name:          "pattern 8 - plain block closed by a paren",
+			Pattern:       `(block loop nop)`,
+			ExpectedError: "1:8: missing end of loop",
+		},
+		{
+			Name:          "pattern 9 - unexpected end",
+			Pattern:       `nop end`,
+			ExpectedError: "1:5: unexpected end",
+		},
+		{
+			Name:          "pattern 10 - else in a block",
+			Pattern:       `block else end`,
+			ExpectedError: "1:7: unexpected else",
+		},
+		{
+			Name:          "pattern 11 - mismatching label",
+			Pattern:       `block $a end $b`,
+			ExpectedError: "1:14: mismatching label $b",
+		},
+		{
+			Name:          "pattern 12 - if without then",
+			Pattern:       `(if (local.get 0))`,
+			ExpectedError: `1:18: expected (then ...), but found ")"`,
+		},
+		{
+			Name:          "pattern 13 - folded end",
+			Pattern:       `(block (end))`,
+			ExpectedError: "1:8: unexpected end",
+		},
+		{
+			Name:          "pattern 14 - select with params",
+			Pattern:       `select (param i32) (result i32)`,
+			ExpectedError: "1:1: select should only have results",
+		},
+		{
+			Name:          "pattern 15 - table.copy with one index",
+			Pattern:       `table.copy 0 nop`,
+			ExpectedError: "1:1: table.copy should have two indices or none",
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			body, err := sexp.ParseAll(data.Pattern)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			_, err = Unfold(body)
+			if data.ExpectedError == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %+v", err)
+				}
+				return
+			}
+			if err == nil || data.ExpectedError != err.Error() {
+				t.Fatalf("\nExpected: %s\nActual:   %v", data.ExpectedError, err)
+			}
+		})
+	}
+}
+
+func TestFold(t *testing.T) {
+	testData := []struct {
+		Name     string
+		Pattern  string
+		Expected string
+	}{
+		{
+			Name:     "pattern 1 - operands",
+			Pattern:  `local.get 0 i32.const 1 i32.add`,
+			Expected: `(i32.add (local.get 0) (i32.const 1))`,
+		},
+		{
+			Name:     "pattern 2 - nested operands",
+			Pattern:  `local.get 0 local.get 1 i32.add i32.const 2 i32.mul drop`,
+			Expected: `(drop (i32.mul (i32.add (local.get 0) (local.get 1)) (i32.const 2)))`,
+		},
+		{
+			Name:     "pattern 3 - unknown arity",
+			Pattern:  `i32.const 1 call $f i32.const 2 i32.add`,
+			Expected: `(i32.const 1) (call $f) (i32.const 2) (i32.add)`,
+		},
+		{
+			Name:     "pattern 4 - blocks",
+			Pattern:  `block $b (result i32) loop $l i32.const 0 br_if $l end i32.const 1 end drop`,
+			Expected: `(drop (block $b (result i32) (loop $l (i32.const 0) (br_if $l)) (i32.const 1)))`,
+		},
+		{
+			Name:     "pattern 5 - if",
+			Pattern:  `local.get 0 if (result i32) i32.const 1 else i32.const 2 end`,
+			Expected: `(if (result i32) (local.get 0) (then (i32.const 1)) (else (i32.const 2)))`,
+		},
+		{
+			Name:     "pattern 6 - if without else",
+			Pattern:  `call $c if nop end`,
+			Expected: `(call $c) (if (then (nop)))`,
+		},
+		{
+			Name:     "pattern 7 - immediates",
+			Pattern:  `i32.const 0 i32.load offset=4 call_indirect (type 0) (param $x i32) (param i32 i64) (result i32)`,
+			Expected: `(i32.load offset=4 (i32.const 0)) (call_indirect (type 0) (param $x i32) (param i32 i64) (result i32))`,
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			body, err := sexp.ParseAll(data.Pattern)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			instrs, err := Unfold(body)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			folded, err := Fold(instrs)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			ss := []string{}
+			for _, s := range folded {
+				ss = append(ss, s.String())
+			}
+			if actual := strings.Join(ss, " "); data.Expected != actual {
+				t.Fatalf("\nExpected: %s\nActual:   %s", data.Expected, actual)
+			}
+
+			// Unfolding the folded form gives back the plain instructions.
+			unfolded, err := Unfold(folded)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if diff := pretty.Compare(instrStrings(instrs), instrStrings(unfolded)); diff != "" {
+				t.Fatalf("\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFoldError(t *testing.T) {
+	_, err := Fold([]*Instr{{Op: "block", Type: &TypeUse{}}, {Op: "nop"}})
+	if err == nil || err.Error() != "-: missing end of block" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, err = Fold([]*Instr{{Op: "nop"}, {Op: "end"}})
+	if err == nil || err.Error() != "-: unexpected end" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,925 @@
+package wat
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/bearmini/sexp"
+)
+
+// Parse parses a text module, written either as a (module ...) form or as a
+// sequence of module fields.
+func Parse(src string) (*Module, error) {
+	forms, err := sexp.ParseAll(src)
+	if err != nil {
+		return nil, err
+	}
+	if len(forms) == 1 && forms[0].HasHead("module") {
+		return ParseModule(forms[0])
+	}
+
+	m := &Module{Pos: sexp.Position{Line: 1, Column: 1}}
+	p := &parser{m: m}
+	for _, f := range forms {
+		err := p.field(f)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// ParseModule converts a (module ...) form to a module.
+func ParseModule(s *sexp.Sexp) (*Module, error) {
+	if !s.HasHead("module") {
+		return nil, expected(s, "(module ...)")
+	}
+
+	m := &Module{Pos: s.Start}
+	l := &list{s: s, i: 1}
+	m.ID = l.id()
+	if e := l.peek(); e.IsSymbol("binary", "quote") {
+		return nil, errorf(e, "%s module is not a text module", e.Atom.Value)
+	}
+
+	p := &parser{m: m}
+	for !l.done() {
+		err := p.field(l.next())
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func errorf(s *sexp.Sexp, format string, args ...interface{}) error {
+	return &sexp.SyntaxError{Pos: s.Start, Msg: fmt.Sprintf(format, args...)}
+}
+
+// expected reports that s is not what was expected.
+func expected(s *sexp.Sexp, what string) error {
+	return &sexp.SyntaxError{Pos: s.Start, Expected: what, Found: describe(s)}
+}
+
+// missing reports that the list s ends where something was expected.
+func missing(s *sexp.Sexp, what string) error {
+	pos := s.End
+	pos.Offset--
+	pos.Column-- // the close paren
+	return &sexp.SyntaxError{Pos: pos, Expected: what, Found: `")"`}
+}
+
+func describe(s *sexp.Sexp) string {
+	if s.IsAtom() {
+		return fmt.Sprintf("%q", s.Atom.Value)
+	}
+	if name, ok := s.Head().Symbol(); ok {
+		return fmt.Sprintf("(%s ...)", name)
+	}
+	return "list"
+}
+
+// isID reports whether s is a $identifier.
+func isID(s *sexp.Sexp) bool {
+	n, ok := s.Symbol()
+	return ok && len(n) > 1 && n[0] == '$'
+}
+
+// list walks the elements of a list.
+type list struct {
+	s *sexp.Sexp
+	i int
+}
+
+func (l *list) done() bool {
+	return l.i >= l.s.Len()
+}
+
+// peek returns the next element, or nil at the end of the list.
+func (l *list) peek() *sexp.Sexp {
+	return l.s.Nth(l.i)
+}
+
+func (l *list) next() *sexp.Sexp {
+	e := l.s.Nth(l.i)
+	l.i++
+	return e
+}
+
+// rest returns the remaining elements.
+func (l *list) rest() []*sexp.Sexp {
+	if l.done() {
+		return nil
+	}
+	rest := l.s.Children[l.i:]
+	l.i = l.s.Len()
+	return rest
+}
+
+// id returns the next element if it is a $identifier, and "" otherwise.
+func (l *list) id() string {
+	if !isID(l.peek()) {
+		return ""
+	}
+	return l.next().Atom.Value
+}
+
+// expect returns the next element, or reports that it is missing.
+func (l *list) expect(what string) (*sexp.Sexp, error) {
+	if l.done() {
+		return nil, missing(l.s, what)
+	}
+	return l.next(), nil
+}
+
+// end reports any element left.
+func (l *list) end() error {
+	if l.done() {
+		return nil
+	}
+	return errorf(l.peek(), "unexpected %s", describe(l.peek()))
+}
+
+// str returns the decoded next string.
+func (l *list) str(what string) (string, error) {
+	e, err := l.expect(what)
+	if err != nil {
+		return "", err
+	}
+	if !e.IsString() {
+		return "", expected(e, what)
+	}
+	return e.Atom.StringValue()
+}
+
+func u32(s *sexp.Sexp, what string) (uint32, error) {
+	n, ok := s.Number()
+	if !ok {
+		return 0, expected(s, what)
+	}
+	v, err := n.Uint64()
+	if err != nil || v > math.MaxUint32 {
+		return 0, errorf(s, "invalid %s %s", what, s.Atom.Value)
+	}
+	return uint32(v), nil
+}
+
+func index(s *sexp.Sexp) (*Index, error) {
+	if isID(s) {
+		return &Index{Pos: s.Start, ID: s.Atom.Value, atom: s}, nil
+	}
+	if !s.IsNumber() {
+		return nil, expected(s, "index")
+	}
+	n, err := u32(s, "index")
+	if err != nil {
+		return nil, err
+	}
+	return &Index{Pos: s.Start, Num: n, atom: s}, nil
+}
+
+var valueTypes = map[string]ValueType{
+	"i32":       I32,
+	"i64":       I64,
+	"f32":       F32,
+	"f64":       F64,
+	"funcref":   FuncRef,
+	"externref": ExternRef,
+}
+
+func valueType(s *sexp.Sexp) (ValueType, error) {
+	name, _ := s.Symbol()
+	t, ok := valueTypes[name]
+	if !ok {
+		return 0, expected(s, "value type")
+	}
+	return t, nil
+}
+
+func refType(s *sexp.Sexp) (ValueType, error) {
+	t, err := valueType(s)
+	if err != nil || !t.IsRef() {
+		return 0, expected(s, "reference type")
+	}
+	return t, nil
+}
+
+func isRefType(s *sexp.Sexp) bool {
+	return s.IsSymbol("funcref", "externref")
+}
+
+// parser converts module fields, counting the items of each index space so that
+// inline exports and segments can refer to them.
+type parser struct {
+	m       *Module
+	funcs   uint32
+	tables  uint32
+	mems    uint32
+	globals uint32
+	defined bool // a function, a table, a memory or a global has been defined
+}
+
+func (p *parser) field(f *sexp.Sexp) error {
+	head, ok := f.Head().Symbol()
+	if !ok {
+		return expected(f, "module field")
+	}
+
+	switch head {
+	case "type":
+		return p.typeDef(f)
+	case "import":
+		return p.importField(f)
+	case "func":
+		return p.funcField(f)
+	case "table":
+		return p.tableField(f)
+	case "memory":
+		return p.memoryField(f)
+	case "global":
+		return p.globalField(f)
+	case "export":
+		return p.exportField(f)
+	case "start":
+		return p.startField(f)
+	case "elem":
+		return p.elemField(f)
+	case "data":
+		return p.dataField(f)
+	}
+	return errorf(f.Head(), "unknown module field %s", head)
+}
+
+func (p *parser) typeDef(f *sexp.Sexp) error {
+	l := &list{s: f, i: 1}
+	t := &TypeDef{Pos: f.Start, ID: l.id()}
+	fn, err := l.expect("(func ...)")
+	if err != nil {
+		return err
+	}
+	if !fn.HasHead("func") {
+		return expected(fn, "(func ...)")
+	}
+	err = l.end()
+	if err != nil {
+		return err
+	}
+
+	fl := &list{s: fn, i: 1}
+	u, err := typeUse(fl)
+	if err != nil {
+		return err
+	}
+	if u.Type != nil {
+		return errorf(fn.Nth(1), "unexpected type use in a type definition")
+	}
+	err = fl.end()
+	if err != nil {
+		return err
+	}
+	t.Func = u.Inline()
+	p.m.Types = append(p.m.Types, t)
+	return nil
+}
+
+// typeUse parses (type idx)? (param ...)* (result ...)*.
+func typeUse(l *list) (*TypeUse, error) {
+	u := &TypeUse{}
+	if e := l.peek(); e.HasHead("type") {
+		l.next()
+		if e.Len() != 2 {
+			return nil, errorf(e, "type should have a single index")
+		}
+		x, err := index(e.Nth(1))
+		if err != nil {
+			return nil, err
+		}
+		u.Type = x
+	}
+
+	var err error
+	u.Params, err = params(l, "param")
+	if err != nil {
+		return nil, err
+	}
+	for l.peek().HasHead("result") {
+		e := l.next()
+		for _, c := range e.Children[1:] {
+			t, err := valueType(c)
+			if err != nil {
+				return nil, err
+			}
+			u.Results = append(u.Results, t)
+		}
+	}
+	if e := l.peek(); e.HasHead("param") {
+		return nil, errorf(e, "param after result")
+	}
+	return u, nil
+}
+
+// params parses (param ...)* or (local ...)*. Each is either a single named
+// parameter, as in (param $x i32), or any number of anonymous ones.
+func params(l *list, head string) ([]*Param, error) {
+	var ps []*Param
+	for l.peek().HasHead(head) {
+		e := l.next()
+		if isID(e.Nth(1)) {
+			if e.Len() != 3 {
+				return nil, errorf(e, "named %s should have a single type", head)
+			}
+			t, err := valueType(e.Nth(2))
+			if err != nil {
+				return nil, err
+			}
+			ps = append(ps, &Param{Pos: e.Start, ID: e.Nth(1).Atom.Value, Type: t})
+			continue
+		}
+		for _, c := range e.Children[1:] {
+			t, err := valueType(c)
+			if err != nil {
+				return nil, err
+			}
+			ps = append(ps, &Param{Pos: c.Start, Type: t})
+		}
+	}
+	return ps, nil
+}
+
+func limits(l *list) (*Limits, error) {
+	e, err := l.expect("limits")
+	if err != nil {
+		return nil, err
+	}
+	min, err := u32(e, "limits")
+	if err != nil {
+		return nil, err
+	}
+	lim := &Limits{Min: min}
+	if l.peek().IsNumber() {
+		lim.Max, err = u32(l.next(), "limits")
+		if err != nil {
+			return nil, err
+		}
+		lim.HasMax = true
+	}
+	return lim, nil
+}
+
+func tableType(l *list) (*TableType, error) {
+	lim, err := limits(l)
+	if err != nil {
+		return nil, err
+	}
+	e, err := l.expect("reference type")
+	if err != nil {
+		return nil, err
+	}
+	t, err := refType(e)
+	if err != nil {
+		return nil, err
+	}
+	return &TableType{Limits: *lim, Elem: t}, nil
+}
+
+func globalType(l *list) (*GlobalType, error) {
+	e, err := l.expect("global type")
+	if err != nil {
+		return nil, err
+	}
+	if e.HasHead("mut") {
+		if e.Len() != 2 {
+			return nil, errorf(e, "mut should have a single type")
+		}
+		t, err := valueType(e.Nth(1))
+		if err != nil {
+			return nil, err
+		}
+		return &GlobalType{Type: t, Mutable: true}, nil
+	}
+	t, err := valueType(e)
+	if err != nil {
+		return nil, err
+	}
+	return &GlobalType{Type: t}, nil
+}
+
+func (p *parser) importField(f *sexp.Sexp) error {
+	l := &list{s: f, i: 1}
+	module, err := l.str("module name")
+	if err != nil {
+		return err
+	}
+	name, err := l.str("import name")
+	if err != nil {
+		return err
+	}
+	desc, err := l.expect("import description")
+	if err != nil {
+		return err
+	}
+	err = l.end()
+	if err != nil {
+		return err
+	}
+
+	kind, ok := desc.Head().Symbol()
+	if !ok {
+		return expected(desc, "import description")
+	}
+	dl := &list{s: desc, i: 1}
+	imp := &Import{Pos: f.Start, Module: module, Name: name, ID: dl.id()}
+	switch kind {
+	case "func":
+		imp.Kind = ExternFunc
+		imp.Func, err = typeUse(dl)
+	case "table":
+		imp.Kind = ExternTable
+		imp.Table, err = tableType(dl)
+	case "memory":
+		imp.Kind = ExternMemory
+		imp.Memory, err = limits(dl)
+	case "global":
+		imp.Kind = ExternGlobal
+		imp.Global, err = globalType(dl)
+	default:
+		return expected(desc, "import description")
+	}
+	if err != nil {
+		return err
+	}
+	err = dl.end()
+	if err != nil {
+		return err
+	}
+	return p.addImport(f, imp)
+}
+
+func (p *parser) addImport(f *sexp.Sexp, imp *Import) error {
+	if p.defined {
+		return errorf(f, "imports must occur before all non-import definitions")
+	}
+	switch imp.Kind {
+	case ExternFunc:
+		p.funcs++
+	case ExternTable:
+		p.tables++
+	case ExternMemory:
+		p.mems++
+	case ExternGlobal:
+		p.globals++
+	}
+	p.m.Imports = append(p.m.Imports, imp)
+	return nil
+}
+
+// inline parses the inline exports and the inline import of an item.
+func (p *parser) inline(l *list) ([]*Export, *Import, error) {
+	var exports []*Export
+	for l.peek().HasHead("export") {
+		e := l.next()
+		el := &list{s: e, i: 1}
+		name, err := el.str("export name")
+		if err != nil {
+			return nil, nil, err
+		}
+		err = el.end()
+		if err != nil {
+			return nil, nil, err
+		}
+		exports = append(exports, &Export{Pos: e.Start, Name: name})
+	}
+
+	e := l.peek()
+	if !e.HasHead("import") {
+		return exports, nil, nil
+	}
+	l.next()
+	il := &list{s: e, i: 1}
+	module, err := il.str("module name")
+	if err != nil {
+		return nil, nil, err
+	}
+	name, err := il.str("import name")
+	if err != nil {
+		return nil, nil, err
+	}
+	err = il.end()
+	if err != nil {
+		return nil, nil, err
+	}
+	return exports, &Import{Pos: e.Start, Module: module, Name: name}, nil
+}
+
+// addExports adds inline exports for the item at an index.
+func (p *parser) addExports(exports []*Export, kind ExternKind, pos sexp.Position, n uint32) {
+	for _, e := range exports {
+		e.Kind = kind
+		e.Index = &Index{Pos: pos, Num: n}
+		p.m.Exports = append(p.m.Exports, e)
+	}
+}
+
+func (p *parser) funcField(f *sexp.Sexp) error {
+	l := &list{s: f, i: 1}
+	id := l.id()
+	exports, imp, err := p.inline(l)
+	if err != nil {
+		return err
+	}
+	u, err := typeUse(l)
+	if err != nil {
+		return err
+	}
+
+	if imp != nil {
+		err = l.end()
+		if err != nil {
+			return err
+		}
+		p.addExports(exports, ExternFunc, f.Start, p.funcs)
+		imp.Kind, imp.ID, imp.Func = ExternFunc, id, u
+		return p.addImport(f, imp)
+	}
+
+	fn := &Func{Pos: f.Start, ID: id, Type: u}
+	fn.Locals, err = params(l, "local")
+	if err != nil {
+		return err
+	}
+	fn.Body = l.rest()
+	p.addExports(exports, ExternFunc, f.Start, p.funcs)
+	p.funcs++
+	p.defined = true
+	p.m.Funcs = append(p.m.Funcs, fn)
+	return nil
+}
+
+func (p *parser) tableField(f *sexp.Sexp) error {
+	l := &list{s: f, i: 1}
+	id := l.id()
+	exports, imp, err := p.inline(l)
+	if err != nil {
+		return err
+	}
+
+	if imp != nil {
+		imp.Table, err = tableType(l)
+		if err != nil {
+			return err
+		}
+		err = l.end()
+		if err != nil {
+			return err
+		}
+		p.addExports(exports, ExternTable, f.Start, p.tables)
+		imp.Kind, imp.ID = ExternTable, id
+		return p.addImport(f, imp)
+	}
+
+	t := &Table{Pos: f.Start, ID: id}
+	if isRefType(l.peek()) {
+		// (table reftype (elem ...)) defines a table just large enough for the elements.
+		typ, _ := refType(l.next())
+		e, err := l.expect("(elem ...)")
+		if err != nil {
+			return err
+		}
+		if !e.HasHead("elem") {
+			return expected(e, "(elem ...)")
+		}
+		err = l.end()
+		if err != nil {
+			return err
+		}
+
+		elem := &Elem{
+			Pos:    e.Start,
+			Mode:   SegmentActive,
+			Table:  &Index{Pos: f.Start, Num: p.tables},
+			Offset: constExpr(e.Start, "i32.const", "0"),
+			Type:   typ,
+		}
+		el := &list{s: e, i: 1}
+		if el.peek().IsList() {
+			elem.Exprs, err = elemExprs(el)
+		} else {
+			elem.Funcs, err = funcIndices(el)
+		}
+		if err != nil {
+			return err
+		}
+		n := uint32(len(elem.Funcs) + len(elem.Exprs))
+		t.Type = &TableType{Limits: Limits{Min: n, Max: n, HasMax: true}, Elem: typ}
+		p.m.Elems = append(p.m.Elems, elem)
+	} else {
+		t.Type, err = tableType(l)
+		if err != nil {
+			return err
+		}
+		err = l.end()
+		if err != nil {
+			return err
+		}
+	}
+
+	p.addExports(exports, ExternTable, f.Start, p.tables)
+	p.tables++
+	p.defined = true
+	p.m.Tables = append(p.m.Tables, t)
+	return nil
+}
+
+const pageSize = 65536
+
+func (p *parser) memoryField(f *sexp.Sexp) error {
+	l := &list{s: f, i: 1}
+	id := l.id()
+	exports, imp, err := p.inline(l)
+	if err != nil {
+		return err
+	}
+
+	if imp != nil {
+		imp.Memory, err = limits(l)
+		if err != nil {
+			return err
+		}
+		err = l.end()
+		if err != nil {
+			return err
+		}
+		p.addExports(exports, ExternMemory, f.Start, p.mems)
+		imp.Kind, imp.ID = ExternMemory, id
+		return p.addImport(f, imp)
+	}
+
+	mem := &Memory{Pos: f.Start, ID: id}
+	if e := l.peek(); e.HasHead("data") {
+		// (memory (data ...)) defines a memory just large enough for the data.
+		l.next()
+		err = l.end()
+		if err != nil {
+			return err
+		}
+		b, err := sexp.ConcatStrings(e.Tail())
+		if err != nil {
+			return err
+		}
+		n := uint32((len(b) + pageSize - 1) / pageSize)
+		mem.Limits = &Limits{Min: n, Max: n, HasMax: true}
+		p.m.Datas = append(p.m.Datas, &Data{
+			Pos:    e.Start,
+			Mode:   SegmentActive,
+			Memory: &Index{Pos: f.Start, Num: p.mems},
+			Offset: constExpr(e.Start, "i32.const", "0"),
+			Data:   b,
+		})
+	} else {
+		mem.Limits, err = limits(l)
+		if err != nil {
+			return err
+		}
+		err = l.end()
+		if err != nil {
+			return err
+		}
+	}
+
+	p.addExports(exports, ExternMemory, f.Start, p.mems)
+	p.mems++
+	p.defined = true
+	p.m.Memories = append(p.m.Memories, mem)
+	return nil
+}
+
+func (p *parser) globalField(f *sexp.Sexp) error {
+	l := &list{s: f, i: 1}
+	id := l.id()
+	exports, imp, err := p.inline(l)
+	if err != nil {
+		return err
+	}
+	t, err := globalType(l)
+	if err != nil {
+		return err
+	}
+
+	if imp != nil {
+		err = l.end()
+		if err != nil {
+			return err
+		}
+		p.addExports(exports, ExternGlobal, f.Start, p.globals)
+		imp.Kind, imp.ID, imp.Global = ExternGlobal, id, t
+		return p.addImport(f, imp)
+	}
+
+	p.addExports(exports, ExternGlobal, f.Start, p.globals)
+	p.globals++
+	p.defined = true
+	p.m.Globals = append(p.m.Globals, &Global{Pos: f.Start, ID: id, Type: t, Init: l.rest()})
+	return nil
+}
+
+var externKinds = map[string]ExternKind{
+	"func":   ExternFunc,
+	"table":  ExternTable,
+	"memory": ExternMemory,
+	"global": ExternGlobal,
+}
+
+func (p *parser) exportField(f *sexp.Sexp) error {
+	l := &list{s: f, i: 1}
+	name, err := l.str("export name")
+	if err != nil {
+		return err
+	}
+	desc, err := l.expect("export description")
+	if err != nil {
+		return err
+	}
+	err = l.end()
+	if err != nil {
+		return err
+	}
+
+	head, _ := desc.Head().Symbol()
+	kind, ok := externKinds[head]
+	if !ok || desc.Len() != 2 {
+		return expected(desc, "export description")
+	}
+	x, err := index(desc.Nth(1))
+	if err != nil {
+		return err
+	}
+	p.m.Exports = append(p.m.Exports, &Export{Pos: f.Start, Name: name, Kind: kind, Index: x})
+	return nil
+}
+
+func (p *parser) startField(f *sexp.Sexp) error {
+	if p.m.Start != nil {
+		return errorf(f, "multiple start fields")
+	}
+	l := &list{s: f, i: 1}
+	e, err := l.expect("function index")
+	if err != nil {
+		return err
+	}
+	x, err := index(e)
+	if err != nil {
+		return err
+	}
+	err = l.end()
+	if err != nil {
+		return err
+	}
+	p.m.Start = &Start{Pos: f.Start, Func: x}
+	return nil
+}
+
+func (p *parser) elemField(f *sexp.Sexp) error {
+	l := &list{s: f, i: 1}
+	elem := &Elem{Pos: f.Start, ID: l.id(), Mode: SegmentPassive}
+
+	// legacy is set for (elem (offset ...) funcidx*), whose function indices are
+	// not preceded by func.
+	legacy := false
+	switch e := l.peek(); {
+	case e.IsSymbol("declare"):
+		l.next()
+		elem.Mode = SegmentDeclarative
+	case e.HasHead("table"):
+		l.next()
+		if e.Len() != 2 {
+			return errorf(e, "table should have a single index")
+		}
+		x, err := index(e.Nth(1))
+		if err != nil {
+			return err
+		}
+		o, err := l.expect("offset")
+		if err != nil {
+			return err
+		}
+		if !o.IsList() {
+			return expected(o, "offset")
+		}
+		elem.Mode = SegmentActive
+		elem.Table = x
+		elem.Offset = offset(o)
+	case e.IsList() && !e.HasHead("item"):
+		l.next()
+		elem.Mode = SegmentActive
+		elem.Table = &Index{Pos: e.Start}
+		elem.Offset = offset(e)
+		legacy = true
+	}
+
+	var err error
+	switch e := l.peek(); {
+	case e.IsSymbol("func"):
+		l.next()
+		elem.Type = FuncRef
+		elem.Funcs, err = funcIndices(l)
+	case isRefType(e):
+		elem.Type, _ = refType(l.next())
+		elem.Exprs, err = elemExprs(l)
+	case legacy:
+		elem.Type = FuncRef
+		elem.Funcs, err = funcIndices(l)
+	case e == nil:
+		err = missing(f, "element list")
+	default:
+		err = expected(e, "element list")
+	}
+	if err != nil {
+		return err
+	}
+	p.m.Elems = append(p.m.Elems, elem)
+	return nil
+}
+
+// funcIndices parses the remaining elements as function indices.
+func funcIndices(l *list) ([]*Index, error) {
+	xs := []*Index{}
+	for !l.done() {
+		x, err := index(l.next())
+		if err != nil {
+			return nil, err
+		}
+		xs = append(xs, x)
+	}
+	return xs, nil
+}
+
+// elemExprs parses the remaining elements as element expressions, written either
+// as (item instr*) or as a single folded instruction.
+func elemExprs(l *list) ([][]*sexp.Sexp, error) {
+	exprs := [][]*sexp.Sexp{}
+	for !l.done() {
+		e := l.next()
+		switch {
+		case e.HasHead("item"):
+			exprs = append(exprs, e.Tail())
+		case e.IsList():
+			exprs = append(exprs, []*sexp.Sexp{e})
+		default:
+			return nil, expected(e, "element expression")
+		}
+	}
+	return exprs, nil
+}
+
+// offset returns the instructions of (offset instr*), or the single folded instruction
+// that abbreviates it.
+func offset(e *sexp.Sexp) []*sexp.Sexp {
+	if e.HasHead("offset") {
+		return e.Tail()
+	}
+	return []*sexp.Sexp{e}
+}
+
+func (p *parser) dataField(f *sexp.Sexp) error {
+	l := &list{s: f, i: 1}
+	d := &Data{Pos: f.Start, ID: l.id(), Mode: SegmentPassive}
+
+	if e := l.peek(); e.HasHead("memory") {
+		l.next()
+		if e.Len() != 2 {
+			return errorf(e, "memory should have a single index")
+		}
+		x, err := index(e.Nth(1))
+		if err != nil {
+			return err
+		}
+		d.Memory = x
+		o, err := l.expect("offset")
+		if err != nil {
+			return err
+		}
+		if !o.IsList() {
+			return expected(o, "offset")
+		}
+		d.Mode = SegmentActive
+		d.Offset = offset(o)
+	} else if e.IsList() {
+		l.next()
+		d.Mode = SegmentActive
+		d.Memory = &Index{Pos: e.Start}
+		d.Offset = offset(e)
+	}
+
+	b, err := sexp.ConcatStrings(l.rest())
+	if err != nil {
+		return err
+	}
+	d.Data = b
+	p.m.Datas = append(p.m.Datas, d)
+	return nil
+}
+
+// constExpr returns the folded instruction (op arg) for an abbreviation.
+func constExpr(pos sexp.Position, op, arg string) []*sexp.Sexp {
+	return []*sexp.Sexp{newList(pos, newSymbol(pos, op), newNumber(pos, arg))}
+}
@@ -0,0 +1,400 @@
+package wat
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/bearmini/sexp"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+// dump returns a line for each item of a module, without positions.
+func dump(m *Module) []string {
+	lines := []string{}
+	add := func(format string, args ...interface{}) {
+		lines = append(lines, strings.TrimSpace(fmt.Sprintf(format, args...)))
+	}
+	for _, t := range m.Types {
+		add("type %s %s", t.ID, dumpTypeUse(&TypeUse{Params: t.Func.Params, Results: t.Func.Results}))
+	}
+	for _, i := range m.Imports {
+		desc := ""
+		switch i.Kind {
+		case ExternFunc:
+			desc = dumpTypeUse(i.Func)
+		case ExternTable:
+			desc = dumpTableType(i.Table)
+		case ExternMemory:
+			desc = dumpLimits(i.Memory)
+		case ExternGlobal:
+			desc = dumpGlobalType(i.Global)
+		}
+		add("import %q %q %s %s %s", i.Module, i.Name, i.Kind, i.ID, desc)
+	}
+	for _, f := range m.Funcs {
+		add("func %s %s locals=%s body=%s", f.ID, dumpTypeUse(f.Type), dumpParams(f.Locals), dumpExpr(f.Body))
+	}
+	for _, t := range m.Tables {
+		add("table %s %s", t.ID, dumpTableType(t.Type))
+	}
+	for _, mem := range m.Memories {
+		add("memory %s %s", mem.ID, dumpLimits(mem.Limits))
+	}
+	for _, g := range m.Globals {
+		add("global %s %s %s", g.ID, dumpGlobalType(g.Type), dumpExpr(g.Init))
+	}
+	for _, e := range m.Exports {
+		add("export %q %s %s", e.Name, e.Kind, e.Index)
+	}
+	if m.Start != nil {
+		add("start %s", m.Start.Func)
+	}
+	for _, e := range m.Elems {
+		elems := []string{}
+		for _, x := range e.Funcs {
+			elems = append(elems, x.String())
+		}
+		for _, expr := range e.Exprs {
+			elems = append(elems, dumpExpr(expr))
+		}
+		add("elem %s mode=%d table=%v offset=%s %s %v", e.ID, e.Mode, e.Table, dumpExpr(e.Offset), e.Type, elems)
+	}
+	for _, d := range m.Datas {
+		add("data %s mode=%d memory=%v offset=%s %q", d.ID, d.Mode, d.Memory, dumpExpr(d.Offset), d.Data)
+	}
+	return lines
+}
+
+func dumpTypeUse(u *TypeUse) string {
+	s := ""
+	if u.Type != nil {
+		s += "(type " + u.Type.String() + ") "
+	}
+	s += "(param" + dumpParams(u.Params) + ") (result"
+	for _, t := range u.Results {
+		s += " " + t.String()
+	}
+	return s + ")"
+}
+
+func dumpParams(ps []*Param) string {
+	s := ""
+	for _, p := range ps {
+		s += " " + strings.TrimPrefix(p.ID+" "+p.Type.String(), " ")
+	}
+	return s
+}
+
+func dumpLimits(l *Limits) string {
+	if l.HasMax {
+		return fmt.Sprintf("%d %d", l.Min, l.Max)
+	}
+	return fmt.Sprint(l.Min)
+}
+
+func dumpTableType(t *TableType) string {
+	return dumpLimits(&t.Limits) + " " + t.Elem.String()
+}
+
+func dumpGlobalType(t *GlobalType) string {
+	if t.Mutable {
+		return "(mut " + t.Type.String() + ")"
+	}
+	return t.Type.String()
+}
+
+func dumpExpr(ss []*sexp.Sexp) string {
+	strs := make([]string, len(ss))
+	for i, s := range ss {
+		strs[i] = s.String()
+	}
+	return "[" + strings.Join(strs, " ") + "]"
+}
+
+func TestParseModule(t *testing.T) {
+	testData := []struct {
+		Name     string
+		Pattern  string
+		Expected []string
+	}{
+		{
+			Name:     "pattern 1 - empty",
+			Pattern:  `(module $m)`,
+			Expected: []string{},
+		},
+		{
+			Name: "pattern 2 - types",
+			Pattern: `(module
+  (type (func))
+  (type $t (func (param i32 i64) (param $x f32) (result i32 i64) (result f64))))`,
+			Expected: []string{
+				"type  (param) (result)",
+				"type $t (param i32 i64 $x f32) (result i32 i64 f64)",
+			},
+		},
+		{
+			Name: "pattern 3 - imports",
+			Pattern: `(module
+  (import "m" "f" (func $f (type $t) (param i32)))
+  (import "m" "t" (table 1 funcref))
+  (import "m" "mem" (memory $mem 1 2))
+  (import "m" "g" (global $g (mut i64))))`,
+			Expected: []string{
+				`import "m" "f" func $f (type $t) (param i32) (result)`,
+				`import "m" "t" table  1 funcref`,
+				`import "m" "mem" memory $mem 1 2`,
+				`import "m" "g" global $g (mut i64)`,
+			},
+		},
+		{
+			Name: "pattern 4 - functions",
+			Pattern: `(module
+  (func $add (param $a i32) (param $b i32) (result i32) (local $c i32) (local i64 f64)
+    (i32.add (local.get $a) (local.get $b)))
+  (func (type 0) nop nop))`,
+			Expected: []string{
+				"func $add (param $a i32 $b i32) (result i32) locals= $c i32 i64 f64 body=[(i32.add (local.get $a) (local.get $b))]",
+				"func  (type 0) (param) (result) locals= body=[nop nop]",
+			},
+		},
+		{
+			Name: "pattern 5 - inline exports and imports",
+			Pattern: `(module
+  (func $i (export "i") (import "m" "i") (param i32))
+  (global (import "m" "g") i32)
+  (func $f (export "f") (export "g"))
+  (table (export "t") 1 funcref)
+  (memory (export "mem") 1)
+  (global $g (export "g2") (mut i32) (i32.const 0))
+  (export "h" (func $f)))`,
+			Expected: []string{
+				`import "m" "i" func $i (param i32) (result)`,
+				`import "m" "g" global  i32`,
+				"func $f (param) (result) locals= body=[]",
+				"table  1 funcref",
+				"memory  1",
+				"global $g (mut i32) [(i32.const 0)]",
+				`export "i" func 0`,
+				`export "f" func 1`,
+				`export "g" func 1`,
+				`export "t" table 0`,
+				`export "mem" memory 0`,
+				`export "g2" global 1`,
+				`export "h" func $f`,
+			},
+		},
+		{
+			Name: "pattern 6 - inline elements and data",
+			Pattern: `(module
+  (import "m" "t" (table 0 funcref))
+  (table $t funcref (elem $f $g 2))
+  (memory (data "abc" "\00"))
+  (func $f) (func $g))`,
+			Expected: []string{
+				`import "m" "t" table  0 funcref`,
+				"func $f (param) (result) locals= body=[]",
+				"func $g (param) (result) locals= body=[]",
+				"table $t 3 3 funcref",
+				"memory  1 1",
+				"elem  mode=0 table=1 offset=[(i32.const 0)] funcref [$f $g 2]",
+				`data  mode=0 memory=0 offset=[(i32.const 0)] "abc\x00"`,
+			},
+		},
+		{
+			Name: "pattern 7 - element segments",
+			Pattern: `(module
+  (elem (i32.const 1) $f 0)
+  (elem $e (table $t) (offset (global.get 0)) func $f)
+  (elem (offset (i32.const 2)) func)
+  (elem funcref (ref.func $f) (item ref.null func))
+  (elem declare func $f)
+  (start $f))`,
+			Expected: []string{
+				"start $f",
+				"elem  mode=0 table=0 offset=[(i32.const 1)] funcref [$f 0]",
+				"elem $e mode=0 table=$t offset=[(global.get 0)] funcref [$f]",
+				"elem  mode=0 table=0 offset=[(i32.const 2)] funcref []",
+				"elem  mode=1 table=<nil> offset=[] funcref [[(ref.func $f)] [ref.null func]]",
+				"elem  mode=2 table=<nil> offset=[] funcref [$f]",
+			},
+		},
+		{
+			Name: "pattern 8 - data segments",
+			Pattern: `(module
+  (data (i32.const 8) "a" "b")
+  (data $d (memory $m) (offset (i32.const 16)) "\ff")
+  (data $p "passive"))`,
+			Expected: []string{
+				`data  mode=0 memory=0 offset=[(i32.const 8)] "ab"`,
+				`data $d mode=0 memory=$m offset=[(i32.const 16)] "\xff"`,
+				`data $p mode=1 memory=<nil> offset=[] "passive"`,
+			},
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			s, err := sexp.ParseStrict(data.Pattern)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			m, err := ParseModule(s)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if diff := pretty.Compare(data.Expected, dump(m)); diff != "" {
+				t.Fatalf("\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	m, err := Parse(`(func (export "f")) (memory 1)`)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	expected := []string{
+		"func  (param) (result) locals= body=[]",
+		"memory  1",
+		`export "f" func 0`,
+	}
+	if diff := pretty.Compare(expected, dump(m)); diff != "" {
+		t.Fatalf("\n%s", diff)
+	}
+
+	m, err = Parse(`(module $m (func))`)
+	if err != nil {
+		t.Fatalf("unexpected error: %+v", err)
+	}
+	if m.ID != "$m" || len(m.Funcs) != 1 {
+		t.Fatalf("unexpected module: %s %v", m.ID, dump(m))
+	}
+}
+
+func TestParseError(t *testing.T) {
+	testData := []struct {
+		Name          string
+		Pattern       string
+		ExpectedError string
+	}{
+		{
+			Name:          "pattern 1 - not a module",
+			Pattern:       `(func)`,
+			ExpectedError: `1:1: expected (module ...), but found (func ...)`,
+		},
+		{
+			Name:          "pattern 2 - binary module",
+			Pattern:       `(module binary "")`,
+			ExpectedError: `1:9: binary module is not a text module`,
+		},
+		{
+			Name:          "pattern 3 - unknown field",
+			Pattern:       `(module (funk))`,
+			ExpectedError: `1:10: unknown module field funk`,
+		},
+		{
+			Name:          "pattern 4 - bad value type",
+			Pattern:       "(module\n  (func (param i33)))",
+			ExpectedError: `2:16: expected value type, but found "i33"`,
+		},
+		{
+			Name:          "pattern 5 - import after definition",
+			Pattern:       `(module (func) (import "m" "f" (func)))`,
+			ExpectedError: `1:16: imports must occur before all non-import definitions`,
+		},
+		{
+			Name:          "pattern 6 - inline import after definition",
+			Pattern:       `(module (memory 1) (global (import "m" "g") i32))`,
+			ExpectedError: `1:20: imports must occur before all non-import definitions`,
+		},
+		{
+			Name:          "pattern 7 - param after result",
+			Pattern:       `(module (func (result i32) (param i32)))`,
+			ExpectedError: `1:28: param after result`,
+		},
+		{
+			Name:          "pattern 8 - named param with two types",
+			Pattern:       `(module (func (param $x i32 i32)))`,
+			ExpectedError: `1:15: named param should have a single type`,
+		},
+		{
+			Name:          "pattern 9 - missing limits",
+			Pattern:       `(module (memory $m))`,
+			ExpectedError: `1:19: expected limits, but found ")"`,
+		},
+		{
+			Name:          "pattern 10 - limits out of range",
+			Pattern:       `(module (memory 0x100000000))`,
+			ExpectedError: `1:17: invalid limits 0x100000000`,
+		},
+		{
+			Name:          "pattern 11 - table without reference type",
+			Pattern:       `(module (table 1 i32))`,
+			ExpectedError: `1:18: expected reference type, but found "i32"`,
+		},
+		{
+			Name:          "pattern 12 - bad export",
+			Pattern:       `(module (export "f" (fun 0)))`,
+			ExpectedError: `1:21: expected export description, but found (fun ...)`,
+		},
+		{
+			Name:          "pattern 13 - bad index",
+			Pattern:       `(module (start f))`,
+			ExpectedError: `1:16: expected index, but found "f"`,
+		},
+		{
+			Name:          "pattern 14 - multiple start",
+			Pattern:       `(module (start 0) (start 1))`,
+			ExpectedError: `1:19: multiple start fields`,
+		},
+		{
+			Name:          "pattern 15 - elem without element list",
+			Pattern:       `(module (elem (table 0) (i32.const 0)))`,
+			ExpectedError: `1:38: expected element list, but found ")"`,
+		},
+		{
+			Name:          "pattern 16 - data that is not a string",
+			Pattern:       `(module (data (i32.const 0) "a" 1))`,
+			ExpectedError: `1:33: expected string, but found "1"`,
+		},
+		{
+			Name:          "pattern 17 - trailing element",
+			Pattern:       `(module (import "m" "f" (func)) extra)`,
+			ExpectedError: `1:33: expected module field, but found "extra"`,
+		},
+		{
+			Name:          "pattern 18 - type with a type use",
+			Pattern:       `(module (type (func (type 0))))`,
+			ExpectedError: `1:21: unexpected type use in a type definition`,
+		},
+		{
+			Name:          "pattern 19 - extra element in an import",
+			Pattern:       `(module (import "m" "f" (func) 1))`,
+			ExpectedError: `1:32: unexpected "1"`,
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			s, err := sexp.ParseStrict(data.Pattern)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			_, err = ParseModule(s)
+			if err == nil {
+				t.Fatalf("expected an error")
+			}
+			if data.ExpectedError != err.Error() {
+				t.Fatalf("\nExpected: %s\nActual:   %s", data.ExpectedError, err.Error())
+			}
+		})
+	}
+}
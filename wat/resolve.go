@@ -0,0 +1,443 @@
+package wat
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/bearmini/sexp"
+)
+
+// Resolve replaces the $identifiers of the references of a module with indices,
+// in place. The atoms of the instructions are rewritten as well, so that labels
+// become relative depths and Unfold gives numeric immediates afterwards.
+//
+// Type uses are completed: each function, import and call_indirect refers to a
+// type definition, which is added at the end of the types if no type matches an
+// inline type, and the parameters and results of a type use given by index only
+// are filled in. Blocks refer to a type definition only when they need one, that
+// is when they have parameters or more than one result.
+func Resolve(m *Module) error {
+	r := &resolver{
+		m:        m,
+		types:    newSpace("type"),
+		funcs:    newSpace("func"),
+		tables:   newSpace("table"),
+		memories: newSpace("memory"),
+		globals:  newSpace("global"),
+		elems:    newSpace("elem"),
+		datas:    newSpace("data"),
+	}
+	return r.resolve()
+}
+
+// space is an index space.
+type space struct {
+	kind string
+	ids  map[string]uint32
+	n    uint32
+}
+
+func newSpace(kind string) *space {
+	return &space{kind: kind, ids: map[string]uint32{}}
+}
+
+func errorAt(pos sexp.Position, format string, args ...interface{}) error {
+	return &sexp.SyntaxError{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// add adds an item with an optional $identifier.
+func (s *space) add(id string, pos sexp.Position) error {
+	if id != "" {
+		if _, ok := s.ids[id]; ok {
+			return errorAt(pos, "duplicate %s %s", s.kind, id)
+		}
+		s.ids[id] = s.n
+	}
+	s.n++
+	return nil
+}
+
+// resolve replaces the $identifier of a reference with its index.
+func (s *space) resolve(x *Index) error {
+	if x.ID == "" {
+		return nil
+	}
+	n, ok := s.ids[x.ID]
+	if !ok {
+		return errorAt(x.Pos, "undefined %s %s", s.kind, x.ID)
+	}
+	x.setNum(n)
+	return nil
+}
+
+// setNum sets the index of a reference, and rewrites the atom it was read from.
+func (x *Index) setNum(n uint32) {
+	x.ID, x.Num = "", n
+	if x.atom != nil {
+		t := x.atom.Atom
+		x.atom.Atom = &sexp.Token{Type: sexp.TokenTypeNumber, Value: strconv.FormatUint(uint64(n), 10), Start: t.Start, End: t.End}
+	}
+}
+
+type resolver struct {
+	m        *Module
+	types    *space
+	funcs    *space
+	tables   *space
+	memories *space
+	globals  *space
+	elems    *space
+	datas    *space
+}
+
+func (r *resolver) resolve() error {
+	m := r.m
+	for _, t := range m.Types {
+		err := r.types.add(t.ID, t.Pos)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Imports come first in their index spaces.
+	for _, imp := range m.Imports {
+		var err error
+		switch imp.Kind {
+		case ExternFunc:
+			err = r.funcs.add(imp.ID, imp.Pos)
+		case ExternTable:
+			err = r.tables.add(imp.ID, imp.Pos)
+		case ExternMemory:
+			err = r.memories.add(imp.ID, imp.Pos)
+		case ExternGlobal:
+			err = r.globals.add(imp.ID, imp.Pos)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for _, f := range m.Funcs {
+		err := r.funcs.add(f.ID, f.Pos)
+		if err != nil {
+			return err
+		}
+	}
+	for _, t := range m.Tables {
+		err := r.tables.add(t.ID, t.Pos)
+		if err != nil {
+			return err
+		}
+	}
+	for _, mem := range m.Memories {
+		err := r.memories.add(mem.ID, mem.Pos)
+		if err != nil {
+			return err
+		}
+	}
+	for _, g := range m.Globals {
+		err := r.globals.add(g.ID, g.Pos)
+		if err != nil {
+			return err
+		}
+	}
+	for _, e := range m.Elems {
+		err := r.elems.add(e.ID, e.Pos)
+		if err != nil {
+			return err
+		}
+	}
+	for _, d := range m.Datas {
+		err := r.datas.add(d.ID, d.Pos)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, imp := range m.Imports {
+		if imp.Kind == ExternFunc {
+			err := r.typeUse(imp.Func, imp.Pos, false)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, f := range m.Funcs {
+		err := r.function(f)
+		if err != nil {
+			return err
+		}
+	}
+	for _, g := range m.Globals {
+		err := r.expr(g.Init)
+		if err != nil {
+			return err
+		}
+	}
+	for _, e := range m.Exports {
+		err := r.space(e.Kind).resolve(e.Index)
+		if err != nil {
+			return err
+		}
+	}
+	if m.Start != nil {
+		err := r.funcs.resolve(m.Start.Func)
+		if err != nil {
+			return err
+		}
+	}
+	for _, e := range m.Elems {
+		err := r.elem(e)
+		if err != nil {
+			return err
+		}
+	}
+	for _, d := range m.Datas {
+		if d.Mode != SegmentActive {
+			continue
+		}
+		err := r.memories.resolve(d.Memory)
+		if err != nil {
+			return err
+		}
+		err = r.expr(d.Offset)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *resolver) space(k ExternKind) *space {
+	switch k {
+	case ExternTable:
+		return r.tables
+	case ExternMemory:
+		return r.memories
+	case ExternGlobal:
+		return r.globals
+	}
+	return r.funcs
+}
+
+// FindType returns the index of the first type definition equal to a function
+// type, ignoring the identifiers of parameters.
+func (m *Module) FindType(ft *FuncType) (uint32, bool) {
+	for i, t := range m.Types {
+		if sameType(t.Func, ft) {
+			return uint32(i), true
+		}
+	}
+	return 0, false
+}
+
+func sameType(a, b *FuncType) bool {
+	if len(a.Params) != len(b.Params) || len(a.Results) != len(b.Results) {
+		return false
+	}
+	for i, p := range a.Params {
+		if p.Type != b.Params[i].Type {
+			return false
+		}
+	}
+	for i, t := range a.Results {
+		if t != b.Results[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// typeUse resolves a type use. A block type only refers to a type definition when
+// it cannot be written as at most one result.
+func (r *resolver) typeUse(u *TypeUse, pos sexp.Position, block bool) error {
+	if u.Type != nil {
+		err := r.types.resolve(u.Type)
+		if err != nil {
+			return err
+		}
+		if int(u.Type.Num) >= len(r.m.Types) {
+			return errorAt(u.Type.Pos, "undefined type %d", u.Type.Num)
+		}
+		def := r.m.Types[u.Type.Num].Func
+		if len(u.Params) == 0 && len(u.Results) == 0 {
+			for _, p := range def.Params {
+				u.Params = append(u.Params, &Param{Pos: u.Type.Pos, Type: p.Type})
+			}
+			u.Results = append(u.Results, def.Results...)
+			return nil
+		}
+		if !sameType(def, u.Inline()) {
+			return errorAt(pos, "inline function type does not match type %d", u.Type.Num)
+		}
+		return nil
+	}
+
+	if block && len(u.Params) == 0 && len(u.Results) <= 1 {
+		return nil
+	}
+	n, ok := r.m.FindType(u.Inline())
+	if !ok {
+		params := make([]*Param, len(u.Params))
+		for i, p := range u.Params {
+			params[i] = &Param{Pos: p.Pos, Type: p.Type}
+		}
+		n = uint32(len(r.m.Types))
+		r.m.Types = append(r.m.Types, &TypeDef{Pos: pos, Func: &FuncType{Params: params, Results: u.Results}})
+		r.types.n++
+	}
+	u.Type = &Index{Pos: pos, Num: n}
+	return nil
+}
+
+func (r *resolver) function(f *Func) error {
+	err := r.typeUse(f.Type, f.Pos, false)
+	if err != nil {
+		return err
+	}
+
+	locals := newSpace("local")
+	for _, p := range f.Type.Params {
+		err := locals.add(p.ID, p.Pos)
+		if err != nil {
+			return err
+		}
+	}
+	for _, l := range f.Locals {
+		err := locals.add(l.ID, l.Pos)
+		if err != nil {
+			return err
+		}
+	}
+
+	instrs, err := Unfold(f.Body)
+	if err != nil {
+		return err
+	}
+	return r.instrs(instrs, locals)
+}
+
+// expr resolves a constant expression.
+func (r *resolver) expr(ss []*sexp.Sexp) error {
+	instrs, err := Unfold(ss)
+	if err != nil {
+		return err
+	}
+	return r.instrs(instrs, newSpace("local"))
+}
+
+func (r *resolver) elem(e *Elem) error {
+	if e.Mode == SegmentActive {
+		err := r.tables.resolve(e.Table)
+		if err != nil {
+			return err
+		}
+		err = r.expr(e.Offset)
+		if err != nil {
+			return err
+		}
+	}
+	for _, x := range e.Funcs {
+		err := r.funcs.resolve(x)
+		if err != nil {
+			return err
+		}
+	}
+	for _, expr := range e.Exprs {
+		err := r.expr(expr)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// instrs resolves the references of plain instructions. Labels are resolved to
+// their depth, counted from the innermost enclosing block.
+func (r *resolver) instrs(instrs []*Instr, locals *space) error {
+	var labels []string
+	for _, in := range instrs {
+		info := instrTable[in.Op]
+		switch in.Op {
+		case "block", "loop", "if":
+			labels = append(labels, in.Label)
+		case "end":
+			labels = labels[:len(labels)-1]
+		}
+
+		// spaces are the index spaces of the arguments, in order.
+		var spaces []*space
+		switch info.imm {
+		case immBlock:
+			err := r.typeUse(in.Type, in.Pos, true)
+			if err != nil {
+				return err
+			}
+		case immLabel, immBrTable:
+			for _, arg := range in.Args {
+				err := resolveLabel(arg, labels)
+				if err != nil {
+					return err
+				}
+			}
+		case immFunc:
+			spaces = []*space{r.funcs}
+		case immCallIndirect:
+			spaces = []*space{r.tables}
+			err := r.typeUse(in.Type, in.Pos, false)
+			if err != nil {
+				return err
+			}
+		case immLocal:
+			spaces = []*space{locals}
+		case immGlobal:
+			spaces = []*space{r.globals}
+		case immMemory, immMemoryCopy:
+			spaces = []*space{r.memories, r.memories}
+		case immTable, immTableCopy:
+			spaces = []*space{r.tables, r.tables}
+		case immTableInit:
+			spaces = []*space{r.elems}
+			if len(in.Args) == 2 {
+				spaces = []*space{r.tables, r.elems}
+			}
+		case immElem:
+			spaces = []*space{r.elems}
+		case immData:
+			spaces = []*space{r.datas}
+		case immMemoryInit:
+			spaces = []*space{r.datas}
+			if len(in.Args) == 2 {
+				spaces = []*space{r.memories, r.datas}
+			}
+		}
+		for i, s := range spaces {
+			if i >= len(in.Args) {
+				break
+			}
+			x, err := index(in.Args[i])
+			if err != nil {
+				return err
+			}
+			err = s.resolve(x)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveLabel replaces the $label of a branch with its depth.
+func resolveLabel(arg *sexp.Sexp, labels []string) error {
+	x, err := index(arg)
+	if err != nil || x.ID == "" {
+		return err
+	}
+	for depth := 0; depth < len(labels); depth++ {
+		if labels[len(labels)-1-depth] == x.ID {
+			x.setNum(uint32(depth))
+			return nil
+		}
+	}
+	return errorAt(x.Pos, "undefined label %s", x.ID)
+}
@@ -0,0 +1,236 @@
+package wat
+
+import (
+	"testing"
+
+	"github.com/bearmini/sexp"
+	"github.com/kylelemons/godebug/pretty"
+)
+
+func TestResolve(t *testing.T) {
+	testData := []struct {
+		Name     string
+		Pattern  string
+		Expected []string
+	}{
+		{
+			Name: "pattern 1 - functions and locals",
+			Pattern: `(module
+  (import "m" "f" (func $imported (param i32)))
+  (func $add (param $a i32) (param $b i32) (result i32) (local $c i32)
+    (local.set $c (i32.add (local.get $a) (local.get $b)))
+    (call $imported (local.get $c))
+    (call $add (local.get 1) (local.get $c)))
+  (export "add" (func $add))
+  (start $imported))`,
+			Expected: []string{
+				"type  (param i32) (result)",
+				"type  (param i32 i32) (result i32)",
+				`import "m" "f" func $imported (type 0) (param i32) (result)`,
+				"func $add (type 1) (param $a i32 $b i32) (result i32) locals= $c i32 body=[(local.set 2 (i32.add (local.get 0) (local.get 1))) (call 0 (local.get 2)) (call 1 (local.get 1) (local.get 2))]",
+				`export "add" func 1`,
+				"start 0",
+			},
+		},
+		{
+			Name: "pattern 2 - types",
+			Pattern: `(module
+  (type $v (func))
+  (type $ii (func (param i32) (result i32)))
+  (func (type $ii) (local.get 0))
+  (func (param i32) (result i32) (local.get 0))
+  (func (type $v) (param) (result))
+  (func (param i64)))`,
+			Expected: []string{
+				"type $v (param) (result)",
+				"type $ii (param i32) (result i32)",
+				"type  (param i64) (result)",
+				"func  (type 1) (param i32) (result i32) locals= body=[(local.get 0)]",
+				"func  (type 1) (param i32) (result i32) locals= body=[(local.get 0)]",
+				"func  (type 0) (param) (result) locals= body=[]",
+				"func  (type 2) (param i64) (result) locals= body=[]",
+			},
+		},
+		{
+			Name: "pattern 3 - labels",
+			Pattern: `(module
+  (func $f
+    (block $out
+      (loop $in
+        (br_if $out (i32.const 0))
+        (if $if (i32.const 1) (then (br $in)) (else (br $if)))
+        br_table $in $out 0
+        block $plain br $plain br $out end $plain))))`,
+			Expected: []string{
+				"type  (param) (result)",
+				"func $f (type 0) (param) (result) locals= body=[(block $out (loop $in (br_if 1 (i32.const 0)) (if $if (i32.const 1) (then (br 1)) (else (br 0))) br_table 0 1 0 block $plain br 0 br 2 end $plain))]",
+			},
+		},
+		{
+			Name: "pattern 4 - block types",
+			Pattern: `(module
+  (type $t (func (param i32) (result i64)))
+  (func
+    (block (result i32) (i32.const 0)) drop
+    (block (type $t) (param i32) (result i64) (i64.extend_i32_u))
+    (block (param f32) (result f32 f32) (f32.const 1))
+    (call_indirect (type $t) (i32.const 0) (i32.const 1)) drop
+    (call_indirect (param f64) (f64.const 1) (i32.const 0))))`,
+			Expected: []string{
+				"type $t (param i32) (result i64)",
+				"type  (param) (result)",
+				"type  (param f32) (result f32 f32)",
+				"type  (param f64) (result)",
+				"func  (type 1) (param) (result) locals= body=[(block (result i32) (i32.const 0)) drop (block (type 0) (param i32) (result i64) (i64.extend_i32_u)) (block (param f32) (result f32 f32) (f32.const 1)) (call_indirect (type 0) (i32.const 0) (i32.const 1)) drop (call_indirect (param f64) (f64.const 1) (i32.const 0))]",
+			},
+		},
+		{
+			Name: "pattern 5 - items and segments",
+			Pattern: `(module
+  (import "m" "g" (global $g0 i32))
+  (global $g1 (mut i32) (global.get $g0))
+  (table $t 2 funcref)
+  (memory $m 1)
+  (func $f
+    (global.set $g1 (i32.const 0))
+    (table.init $t $e (i32.const 0) (i32.const 0) (i32.const 1))
+    (elem.drop $e)
+    (memory.init $d (i32.const 0) (i32.const 0) (i32.const 1))
+    (data.drop $d)
+    (drop (ref.func $f))
+    (drop (table.size $t)))
+  (elem $e (table $t) (offset (global.get $g0)) func $f)
+  (elem funcref (ref.func $f))
+  (data $d (memory $m) (offset (global.get $g0)) "x")
+  (export "t" (table $t))
+  (export "m" (memory $m))
+  (export "g" (global $g1)))`,
+			Expected: []string{
+				"type  (param) (result)",
+				`import "m" "g" global $g0 i32`,
+				"func $f (type 0) (param) (result) locals= body=[(global.set 1 (i32.const 0)) (table.init 0 0 (i32.const 0) (i32.const 0) (i32.const 1)) (elem.drop 0) (memory.init 0 (i32.const 0) (i32.const 0) (i32.const 1)) (data.drop 0) (drop (ref.func 0)) (drop (table.size 0))]",
+				"table $t 2 funcref",
+				"memory $m 1",
+				"global $g1 (mut i32) [(global.get 0)]",
+				`export "t" table 0`,
+				`export "m" memory 0`,
+				`export "g" global 1`,
+				"elem $e mode=0 table=0 offset=[(global.get 0)] funcref [0]",
+				"elem  mode=1 table=<nil> offset=[] funcref [[(ref.func 0)]]",
+				`data $d mode=0 memory=0 offset=[(global.get 0)] "x"`,
+			},
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			s, err := sexp.ParseStrict(data.Pattern)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			m, err := ParseModule(s)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			err = Resolve(m)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			if diff := pretty.Compare(data.Expected, dump(m)); diff != "" {
+				t.Fatalf("\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestResolveError(t *testing.T) {
+	testData := []struct {
+		Name          string
+		Pattern       string
+		ExpectedError string
+	}{
+		{
+			Name:          "pattern 1 - duplicate function",
+			Pattern:       "(module\n  (import \"m\" \"f\" (func $f))\n  (func $f))",
+			ExpectedError: "3:3: duplicate func $f",
+		},
+		{
+			Name:          "pattern 2 - duplicate local",
+			Pattern:       `(module (func (param $x i32) (local $x i64)))`,
+			ExpectedError: "1:30: duplicate local $x",
+		},
+		{
+			Name:          "pattern 3 - undefined function",
+			Pattern:       `(module (func (call $g)))`,
+			ExpectedError: "1:21: undefined func $g",
+		},
+		{
+			Name:          "pattern 4 - undefined local",
+			Pattern:       "(module (func (param $x i32)\n  (drop (local.get $y))))",
+			ExpectedError: "2:20: undefined local $y",
+		},
+		{
+			Name:          "pattern 5 - undefined label",
+			Pattern:       `(module (func (block $a) (br $a)))`,
+			ExpectedError: "1:30: undefined label $a",
+		},
+		{
+			Name:          "pattern 6 - undefined type",
+			Pattern:       `(module (func (type $t)))`,
+			ExpectedError: "1:21: undefined type $t",
+		},
+		{
+			Name:          "pattern 7 - type out of range",
+			Pattern:       `(module (func (type 0)))`,
+			ExpectedError: "1:21: undefined type 0",
+		},
+		{
+			Name:          "pattern 8 - inline type mismatch",
+			Pattern:       `(module (type (func)) (func (type 0) (param i32)))`,
+			ExpectedError: "1:23: inline function type does not match type 0",
+		},
+		{
+			Name:          "pattern 9 - undefined export",
+			Pattern:       `(module (export "m" (memory $m)))`,
+			ExpectedError: "1:29: undefined memory $m",
+		},
+		{
+			Name:          "pattern 10 - undefined global in a segment offset",
+			Pattern:       `(module (data (global.get $g) ""))`,
+			ExpectedError: "1:27: undefined global $g",
+		},
+		{
+			Name:          "pattern 11 - duplicate type",
+			Pattern:       `(module (type $t (func)) (type $t (func)))`,
+			ExpectedError: "1:26: duplicate type $t",
+		},
+		{
+			Name:          "pattern 12 - undefined data",
+			Pattern:       `(module (func (data.drop $d)))`,
+			ExpectedError: "1:26: undefined data $d",
+		},
+	}
+
+	for _, data := range testData {
+		data := data // capture
+		t.Run(data.Name, func(t *testing.T) {
+			//t.Parallel()
+
+			s, err := sexp.ParseStrict(data.Pattern)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			m, err := ParseModule(s)
+			if err != nil {
+				t.Fatalf("unexpected error: %+v", err)
+			}
+			err = Resolve(m)
+			if err == nil || data.ExpectedError != err.Error() {
+				t.Fatalf("\nExpected: %s\nActual:   %v", data.ExpectedError, err)
+			}
+		})
+	}
+}
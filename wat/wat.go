@@ -0,0 +1,247 @@
+// Package wat reads WebAssembly text modules (.wat) into a typed syntax tree.
+//
+// The tree follows the text format closely: items keep their $identifiers and
+// references are written as identifiers or numbers, and function bodies and
+// constant expressions are kept as S-expressions. Abbreviations that only move
+// things around are expanded: inline imports become imports, inline exports become
+// exports, and the inline elements and data of tables and memories become segments.
+//
+// Unfold turns instructions written in folded form, plain form or a mix of both into
+// plain instructions, and Fold turns them back into folded form. Resolve replaces
+// the $identifiers of references with indices, and Encode writes a module in the
+// binary format (.wasm).
+package wat
+
+import (
+	"fmt"
+
+	"github.com/bearmini/sexp"
+)
+
+// Module is a text module.
+type Module struct {
+	Pos sexp.Position
+	ID  string // the $id of the module, or ""
+
+	Types    []*TypeDef
+	Imports  []*Import
+	Funcs    []*Func
+	Tables   []*Table
+	Memories []*Memory
+	Globals  []*Global
+	Exports  []*Export
+	Start    *Start
+	Elems    []*Elem
+	Datas    []*Data
+}
+
+// ValueType is a value type, with the encoding of the binary format as its value.
+type ValueType byte
+
+const (
+	I32       ValueType = 0x7f
+	I64       ValueType = 0x7e
+	F32       ValueType = 0x7d
+	F64       ValueType = 0x7c
+	FuncRef   ValueType = 0x70
+	ExternRef ValueType = 0x6f
+)
+
+var valueTypeNames = map[ValueType]string{
+	I32:       "i32",
+	I64:       "i64",
+	F32:       "f32",
+	F64:       "f64",
+	FuncRef:   "funcref",
+	ExternRef: "externref",
+}
+
+func (t ValueType) String() string {
+	if name, ok := valueTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("ValueType(%#x)", byte(t))
+}
+
+// IsRef reports whether t is a reference type.
+func (t ValueType) IsRef() bool {
+	return t == FuncRef || t == ExternRef
+}
+
+// Index refers to an item, a local or a label, by $identifier or by number.
+type Index struct {
+	Pos sexp.Position
+	ID  string // the $identifier, or "" for a number
+	Num uint32
+
+	atom *sexp.Sexp // the atom the index was read from, rewritten when it is resolved
+}
+
+func (x Index) String() string {
+	if x.ID != "" {
+		return x.ID
+	}
+	return fmt.Sprint(x.Num)
+}
+
+// Param is a parameter or a local.
+type Param struct {
+	Pos  sexp.Position
+	ID   string
+	Type ValueType
+}
+
+// FuncType is a function type.
+type FuncType struct {
+	Params  []*Param
+	Results []ValueType
+}
+
+// TypeUse is the type of a function, a block or an indirect call: a reference to a
+// type definition, an inline type, or both.
+type TypeUse struct {
+	Type    *Index // nil if the type is only given inline
+	Params  []*Param
+	Results []ValueType
+}
+
+// Inline returns the function type given inline.
+func (u *TypeUse) Inline() *FuncType {
+	return &FuncType{Params: u.Params, Results: u.Results}
+}
+
+// Limits are the limits of the size of a table or a memory.
+type Limits struct {
+	Min    uint32
+	Max    uint32
+	HasMax bool
+}
+
+// TableType is the type of a table.
+type TableType struct {
+	Limits Limits
+	Elem   ValueType // FuncRef or ExternRef
+}
+
+// GlobalType is the type of a global.
+type GlobalType struct {
+	Type    ValueType
+	Mutable bool
+}
+
+// TypeDef is a (type ...) field.
+type TypeDef struct {
+	Pos  sexp.Position
+	ID   string
+	Func *FuncType
+}
+
+type ExternKind byte
+
+const (
+	ExternFunc ExternKind = iota
+	ExternTable
+	ExternMemory
+	ExternGlobal
+)
+
+var externKindNames = []string{"func", "table", "memory", "global"}
+
+func (k ExternKind) String() string {
+	if int(k) < len(externKindNames) {
+		return externKindNames[k]
+	}
+	return fmt.Sprintf("ExternKind(%d)", int(k))
+}
+
+// Import is an (import ...) field, or the inline import of a function, a table,
+// a memory or a global. The description of the kind of the import is set.
+type Import struct {
+	Pos    sexp.Position
+	Module string
+	Name   string
+	Kind   ExternKind
+	ID     string // the $id of the imported item
+
+	Func   *TypeUse
+	Table  *TableType
+	Memory *Limits
+	Global *GlobalType
+}
+
+// Func is a function defined by the module.
+type Func struct {
+	Pos    sexp.Position
+	ID     string
+	Type   *TypeUse
+	Locals []*Param
+	Body   []*sexp.Sexp // the instructions, plain or folded
+}
+
+// Table is a table defined by the module.
+type Table struct {
+	Pos  sexp.Position
+	ID   string
+	Type *TableType
+}
+
+// Memory is a memory defined by the module.
+type Memory struct {
+	Pos    sexp.Position
+	ID     string
+	Limits *Limits
+}
+
+// Global is a global defined by the module.
+type Global struct {
+	Pos  sexp.Position
+	ID   string
+	Type *GlobalType
+	Init []*sexp.Sexp // the constant expression
+}
+
+// Export is an (export ...) field, or the inline export of an item.
+type Export struct {
+	Pos   sexp.Position
+	Name  string
+	Kind  ExternKind
+	Index *Index
+}
+
+// Start is the (start ...) field.
+type Start struct {
+	Pos  sexp.Position
+	Func *Index
+}
+
+type SegmentMode int
+
+const (
+	SegmentActive      SegmentMode = iota // copied into a table or a memory on instantiation
+	SegmentPassive                        // copied by table.init or memory.init
+	SegmentDeclarative                    // only declares references to functions
+)
+
+// Elem is an element segment.
+type Elem struct {
+	Pos    sexp.Position
+	ID     string
+	Mode   SegmentMode
+	Table  *Index       // the table of an active segment
+	Offset []*sexp.Sexp // the offset expression of an active segment
+	Type   ValueType
+
+	// The elements are given either as function indices or as expressions.
+	Funcs []*Index
+	Exprs [][]*sexp.Sexp
+}
+
+// Data is a data segment.
+type Data struct {
+	Pos    sexp.Position
+	ID     string
+	Mode   SegmentMode  // SegmentActive or SegmentPassive
+	Memory *Index       // the memory of an active segment
+	Offset []*sexp.Sexp // the offset expression of an active segment
+	Data   []byte
+}